@@ -0,0 +1,111 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//AffinityNone is the backend of the responses which don't name one.
+const AffinityNone = "(none)"
+
+//Affinity tells the backend instances behind a load balancer apart by a
+//response header or cookie,to show how the responses spread across them and
+//how sticky the workers are.
+type Affinity struct {
+	responses int64 //first for the alignment of atomic operations
+	switches  int64
+	header    string
+	cookie    string
+	Backends  *Breakdown
+}
+
+//ParseAffinity parses "header:NAME" or "cookie:NAME".
+func ParseAffinity(spec string) (*Affinity, error) {
+	i := strings.Index(spec, ":")
+	if i == -1 || spec[i+1:] == "" {
+		return nil, fmt.Errorf("invalid affinity %q,want header:NAME or cookie:NAME", spec)
+	}
+	a := &Affinity{}
+	switch name := spec[i+1:]; spec[:i] {
+	case "header":
+		a.header = http.CanonicalHeaderKey(name)
+	case "cookie":
+		a.cookie = name
+	default:
+		return nil, fmt.Errorf("invalid affinity %q,want header:NAME or cookie:NAME", spec)
+	}
+	a.Backends = NewBreakdown("Backend " + spec)
+	return a, nil
+}
+
+//Backend returns the backend which answered resp.A sticky cookie is usually only
+//set by the first response,later ones are matched by the cookie of the request.
+func (a *Affinity) Backend(resp *http.Response) string {
+	if a.header != "" {
+		if v := resp.Header.Get(a.header); v != "" {
+			return v
+		}
+		return AffinityNone
+	}
+	for _, c := range resp.Cookies() {
+		if c.Name == a.cookie {
+			return c.Value
+		}
+	}
+	if resp.Request != nil {
+		if c, err := resp.Request.Cookie(a.cookie); err == nil {
+			return c.Value
+		}
+	}
+	return AffinityNone
+}
+
+//NewTracker returns the tracker of one worker.
+func (a *Affinity) NewTracker() *AffinityTracker {
+	return &AffinityTracker{affinity: a}
+}
+
+//AffinityTracker follows the backends one worker is sent to.
+type AffinityTracker struct {
+	affinity *Affinity
+	last     string
+}
+
+//Record counts the backend of resp.
+func (t *AffinityTracker) Record(resp *http.Response, latency time.Duration) {
+	a := t.affinity
+	backend := a.Backend(resp)
+	a.Backends.Record(backend, latency, resp.StatusCode >= 400)
+	if t.last != "" && backend != AffinityNone {
+		atomic.AddInt64(&a.responses, 1)
+		if backend != t.last {
+			atomic.AddInt64(&a.switches, 1)
+		}
+	}
+	if backend != AffinityNone {
+		t.last = backend
+	}
+}
+
+//Stickiness returns the number of responses which followed an earlier one of the
+//same worker and how many of them came from another backend.
+func (a *Affinity) Stickiness() (responses, switches int64) {
+	return atomic.LoadInt64(&a.responses), atomic.LoadInt64(&a.switches)
+}
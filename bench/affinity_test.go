@@ -0,0 +1,63 @@
+package ibench
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseAffinity(t *testing.T) {
+	for _, spec := range []string{"", "header", "header:", "query:x"} {
+		if _, err := ParseAffinity(spec); err == nil {
+			t.Errorf("ParseAffinity(%q) must fail", spec)
+		}
+	}
+}
+
+//TestAffinityStickyCookie runs a balancer which assigns a backend by cookie,
+//like a sticky load balancer,with and without a cookie jar.
+func TestAffinityStickyCookie(t *testing.T) {
+	var next int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("SRV"); err != nil {
+			backend := fmt.Sprint("b", atomic.AddInt32(&next, 1)%3)
+			http.SetCookie(w, &http.Cookie{Name: "SRV", Value: backend})
+		}
+	}))
+	defer srv.Close()
+
+	for _, withJar := range []bool{true, false} {
+		a, err := ParseAffinity("cookie:SRV")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{}
+		if withJar {
+			client.Jar, _ = cookiejar.New(nil)
+		}
+		tracker := a.NewTracker()
+		for i := 0; i < 9; i++ {
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			tracker.Record(resp, time.Millisecond)
+		}
+		responses, switches := a.Stickiness()
+		if responses != 8 {
+			t.Errorf("jar %v: %d follow-up responses, want 8", withJar, responses)
+		}
+		rows := a.Backends.Rows()
+		if withJar && (switches != 0 || len(rows) != 1) {
+			t.Errorf("with a jar every request must stick to one backend, %d switches, rows %+v", switches, rows)
+		}
+		if !withJar && (switches != 8 || len(rows) != 3) {
+			t.Errorf("without a jar the backends must rotate, %d switches, rows %+v", switches, rows)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//Authenticator adds credentials to a request right before it's sent.
+type Authenticator interface {
+	Authenticate(req *http.Request, worker int) error
+}
+
+//AuthTransport authenticates every request of a worker at send time,after the
+//client added its cookies and followed redirects.
+type AuthTransport struct {
+	Base   http.RoundTripper
+	Auth   []Authenticator
+	Worker int
+}
+
+//RoundTrip implements http.RoundTripper.
+func (t *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	//a RoundTripper must not modify the request of the caller.
+	req = req.Clone(req.Context())
+	for _, a := range t.Auth {
+		if err := a.Authenticate(req, t.Worker); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	return t.Base.RoundTrip(req)
+}
+
+//Token rotations of a bearer token file.
+const (
+	RotateWorker  = "worker"  //every worker keeps one token
+	RotateRequest = "request" //every request takes the next token
+)
+
+//ParseAuth parses the -auth option:
+//	"basic:USER:PASSWORD"   basic authentication
+//	"bearer:TOKEN"          a bearer token
+//	"bearer:@FILE"          the bearer tokens of FILE,one per line,rotated per worker or per request
+func ParseAuth(spec, rotate string) (Authenticator, error) {
+	switch {
+	case strings.HasPrefix(spec, "basic:"):
+		cred := spec[len("basic:"):]
+		i := strings.Index(cred, ":")
+		if i == -1 {
+			return nil, errors.New("basic auth needs basic:USER:PASSWORD")
+		}
+		return basicAuth{cred[:i], cred[i+1:]}, nil
+	case strings.HasPrefix(spec, "bearer:"):
+		token := spec[len("bearer:"):]
+		if rotate != RotateWorker && rotate != RotateRequest {
+			return nil, fmt.Errorf("unknown token rotation %q", rotate)
+		}
+		b := &bearerAuth{perRequest: rotate == RotateRequest}
+		if !strings.HasPrefix(token, "@") {
+			b.tokens = []string{token}
+		} else if err := b.load(token[1:]); err != nil {
+			return nil, err
+		}
+		if len(b.tokens) == 0 || b.tokens[0] == "" {
+			return nil, errors.New("no bearer token")
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unknown auth %q,want basic:USER:PASSWORD or bearer:TOKEN", RedactAuth(spec))
+}
+
+//RedactAuth masks the credentials of an -auth option,the name of a token file is kept.
+func RedactAuth(spec string) string {
+	i := strings.Index(spec, ":")
+	if spec == "" || strings.HasPrefix(spec, "bearer:@") {
+		return spec
+	}
+	if i == -1 {
+		return "<redacted>"
+	}
+	return spec[:i+1] + "<redacted>"
+}
+
+type basicAuth struct{ user, password string }
+
+func (a basicAuth) Authenticate(req *http.Request, worker int) error {
+	req.SetBasicAuth(a.user, a.password)
+	return nil
+}
+
+type bearerAuth struct {
+	next       uint64 //first for the alignment of atomic operations
+	tokens     []string
+	perRequest bool
+}
+
+func (a *bearerAuth) load(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if t := strings.TrimSpace(s.Text()); t != "" {
+			a.tokens = append(a.tokens, t)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if len(a.tokens) == 0 {
+		return fmt.Errorf("no tokens in %s", name)
+	}
+	return nil
+}
+
+func (a *bearerAuth) Authenticate(req *http.Request, worker int) error {
+	i := uint64(worker)
+	if a.perRequest {
+		i = atomic.AddUint64(&a.next, 1) - 1
+	}
+	req.Header.Set("Authorization", "Bearer "+a.tokens[i%uint64(len(a.tokens))])
+	return nil
+}
+
+//Signer signs requests with an HMAC over a canonical string.The canonical string
+//and the header are templates with these placeholders,expanded at send time:
+//	{{method}} {{host}} {{path}} {{query}} {{uri}}    parts of the request line
+//	{{header:NAME}}                                    a request header
+//	{{body_sha256}}                                    the hex sha256 of the body
+//	{{timestamp}} {{timestamp:ms}} {{timestamp:rfc3339}}  the time of signing
+//	{{nonce}}                                          a random hex string
+//	{{signature}}                                      the signature,in the header only
+//A request sees the same timestamp and nonce in both templates.
+type Signer struct {
+	hash      func() hash.Hash
+	secret    []byte
+	base64    bool
+	canonical []templatePart
+	header    string
+	value     []templatePart
+}
+
+//SignerConfig configures a Signer.
+type SignerConfig struct {
+	Algorithm string //sha256,sha1 or sha512
+	Secret    string //"@FILE" reads the secret from FILE
+	Canonical string
+	Header    string //"Name: value template"
+	Encoding  string //hex or base64
+}
+
+//DefaultCanonical is the default canonical string of a Signer.
+const DefaultCanonical = "{{method}}\n{{path}}\n{{query}}\n{{timestamp}}\n{{body_sha256}}"
+
+//NewSigner returns a Signer for config.
+func NewSigner(config SignerConfig) (*Signer, error) {
+	s := &Signer{}
+	switch config.Algorithm {
+	case "sha256", "":
+		s.hash = sha256.New
+	case "sha1":
+		s.hash = sha1.New
+	case "sha512":
+		s.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("unknown hmac algorithm %q", config.Algorithm)
+	}
+	switch config.Encoding {
+	case "hex", "":
+	case "base64":
+		s.base64 = true
+	default:
+		return nil, fmt.Errorf("unknown signature encoding %q", config.Encoding)
+	}
+	secret := config.Secret
+	if strings.HasPrefix(secret, "@") {
+		b, err := ioutil.ReadFile(secret[1:])
+		if err != nil {
+			return nil, err
+		}
+		secret = strings.TrimRight(string(b), "\r\n")
+	}
+	if secret == "" {
+		return nil, errors.New("empty hmac secret")
+	}
+	s.secret = []byte(secret)
+	canonical := config.Canonical
+	if canonical == "" {
+		canonical = DefaultCanonical
+	}
+	var err error
+	if s.canonical, err = parseParts(unescapeNewlines(canonical), parseSignPlaceholder(false)); err != nil {
+		return nil, err
+	}
+	i := strings.Index(config.Header, ":")
+	if i == -1 {
+		return nil, fmt.Errorf("invalid signature header %q,want \"Name: value\"", config.Header)
+	}
+	s.header = http.CanonicalHeaderKey(strings.TrimSpace(config.Header[:i]))
+	if s.value, err = parseParts(strings.TrimSpace(config.Header[i+1:]), parseSignPlaceholder(true)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//unescapeNewlines turns the \n of a command line argument into a newline.
+func unescapeNewlines(s string) string {
+	return strings.Replace(s, `\n`, "\n", -1)
+}
+
+func parseSignPlaceholder(header bool) func(string) (templatePart, error) {
+	return func(s string) (templatePart, error) {
+		p := templatePart{fn: s}
+		if i := strings.Index(s, ":"); i != -1 {
+			p.fn, p.arg = s[:i], s[i+1:]
+		}
+		var err error
+		switch p.fn {
+		case "method", "host", "path", "query", "uri", "body_sha256", "nonce":
+			if p.arg != "" {
+				err = errors.New("takes no argument")
+			}
+		case "header":
+			if p.arg == "" {
+				err = errors.New("needs a name")
+			}
+		case "timestamp":
+			switch p.arg {
+			case "", "s", "ms", "rfc3339":
+			default:
+				err = errors.New("unknown format " + strconv.Quote(p.arg))
+			}
+		case "signature":
+			if !header {
+				err = errors.New("only allowed in the signature header")
+			}
+		default:
+			err = errors.New("unknown placeholder")
+		}
+		if err != nil {
+			return p, fmt.Errorf("signature template {{%s}}: %v", s, err)
+		}
+		return p, nil
+	}
+}
+
+//UsesBody reports whether the canonical string covers the request body.
+func (s *Signer) UsesBody() bool {
+	for _, p := range s.canonical {
+		if p.fn == "body_sha256" {
+			return true
+		}
+	}
+	return false
+}
+
+//signing is the state of one signature.
+type signing struct {
+	req       *http.Request
+	now       time.Time
+	nonce     string
+	signature string
+}
+
+func (s *signing) expand(parts []templatePart) (string, error) {
+	var b strings.Builder
+	for _, p := range parts {
+		switch p.fn {
+		case "":
+			b.WriteString(p.text)
+		case "method":
+			b.WriteString(s.req.Method)
+		case "host":
+			host := s.req.Host
+			if host == "" {
+				host = s.req.URL.Host
+			}
+			b.WriteString(host)
+		case "path":
+			b.WriteString(s.req.URL.EscapedPath())
+		case "query":
+			b.WriteString(s.req.URL.RawQuery)
+		case "uri":
+			b.WriteString(s.req.URL.RequestURI())
+		case "header":
+			b.WriteString(s.req.Header.Get(p.arg))
+		case "body_sha256":
+			sum, err := bodySHA256(s.req)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(sum)
+		case "timestamp":
+			switch p.arg {
+			case "ms":
+				b.WriteString(strconv.FormatInt(s.now.UnixNano()/int64(time.Millisecond), 10))
+			case "rfc3339":
+				b.WriteString(s.now.UTC().Format(time.RFC3339))
+			default:
+				b.WriteString(strconv.FormatInt(s.now.Unix(), 10))
+			}
+		case "nonce":
+			b.WriteString(s.nonce)
+		case "signature":
+			b.WriteString(s.signature)
+		}
+	}
+	return b.String(), nil
+}
+
+//bodySHA256 hashes the body of req without consuming it.
+func bodySHA256(req *http.Request) (string, error) {
+	h := sha256.New()
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return "", errors.New("{{body_sha256}} needs a literal body")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//Authenticate implements Authenticator.
+func (s *Signer) Authenticate(req *http.Request, worker int) error {
+	var nonce [8]byte
+	mrand.Read(nonce[:])
+	st := &signing{req: req, now: time.Now(), nonce: hex.EncodeToString(nonce[:])}
+	canonical, err := st.expand(s.canonical)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(s.hash, s.secret)
+	mac.Write([]byte(canonical))
+	if s.base64 {
+		st.signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	} else {
+		st.signature = hex.EncodeToString(mac.Sum(nil))
+	}
+	value, err := st.expand(s.value)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(s.header, value)
+	return nil
+}
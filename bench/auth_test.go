@@ -0,0 +1,151 @@
+package ibench
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+//sent returns the Authorization headers AuthTransport sends for n requests of worker.
+func sent(t *testing.T, a Authenticator, worker, n int) []string {
+	t.Helper()
+	var got []string
+	tr := &AuthTransport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			got = append(got, req.Header.Get("Authorization"))
+			return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+		}),
+		Auth:   []Authenticator{a},
+		Worker: worker,
+	}
+	for i := 0; i < n; i++ {
+		req, _ := http.NewRequest("GET", "http://h/", nil)
+		if _, err := tr.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Header.Get("Authorization") != "" {
+			t.Fatal("the request of the caller must not be modified")
+		}
+	}
+	return got
+}
+
+func TestParseAuth(t *testing.T) {
+	tokens := filepath.Join(t.TempDir(), "tokens")
+	ioutil.WriteFile(tokens, []byte("t0\n\nt1\nt2\n"), 0600)
+
+	a, err := ParseAuth("basic:ann:s3:cret", RotateWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sent(t, a, 0, 1)[0]; got != "Basic YW5uOnMzOmNyZXQ=" {
+		t.Errorf("basic = %q", got)
+	}
+
+	a, _ = ParseAuth("bearer:@"+tokens, RotateWorker)
+	if got := sent(t, a, 4, 2); got[0] != "Bearer t1" || got[1] != "Bearer t1" {
+		t.Errorf("per worker tokens = %v", got)
+	}
+	a, _ = ParseAuth("bearer:@"+tokens, RotateRequest)
+	if got := strings.Join(sent(t, a, 4, 4), ","); got != "Bearer t0,Bearer t1,Bearer t2,Bearer t0" {
+		t.Errorf("per request tokens = %v", got)
+	}
+
+	for _, spec := range []string{"basic:nopassword", "bearer:", "digest:x", "bearer:@/no/such/file"} {
+		if _, err := ParseAuth(spec, RotateWorker); err == nil {
+			t.Errorf("ParseAuth(%q) must fail", spec)
+		}
+	}
+	if _, err := ParseAuth("bearer:x", "sometimes"); err == nil {
+		t.Error("unknown rotations must be rejected")
+	}
+}
+
+func TestRedactAuth(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", ""},
+		{"basic:ann:secret", "basic:<redacted>"},
+		{"bearer:abc", "bearer:<redacted>"},
+		{"bearer:@tokens.txt", "bearer:@tokens.txt"},
+		{"garbage", "<redacted>"},
+	}
+	for _, tt := range tests {
+		if got := RedactAuth(tt.in); got != tt.want {
+			t.Errorf("RedactAuth(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSigner(t *testing.T) {
+	s, err := NewSigner(SignerConfig{
+		Secret:    "key",
+		Canonical: `{{method}}\n{{uri}}\n{{header:X-Date}}\n{{timestamp}}\n{{body_sha256}}`,
+		Header:    "Authorization: HMAC ts={{timestamp}},sig={{signature}}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.UsesBody() {
+		t.Error("the canonical string covers the body")
+	}
+	body, _ := ParseBody(`{"a":1}`)
+	url, _ := ParseTemplate("http://h/p?q=1")
+	rt := &RequestTemplate{Method: "POST", URL: url, Body: body}
+	rt.SetHeader("X-Date", "today")
+	req, err := rt.NewRequest(rt.NewContext(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Authenticate(req, 0); err != nil {
+		t.Fatal(err)
+	}
+	value := req.Header.Get("Authorization")
+	parts := strings.SplitN(strings.TrimPrefix(value, "HMAC ts="), ",sig=", 2)
+	if len(parts) != 2 {
+		t.Fatalf("header = %q", value)
+	}
+	bodySum := sha256.Sum256([]byte(`{"a":1}`))
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("POST\n/p?q=1\ntoday\n" + parts[0] + "\n" + hex.EncodeToString(bodySum[:])))
+	if want := hex.EncodeToString(mac.Sum(nil)); parts[1] != want {
+		t.Errorf("signature = %s, want %s", parts[1], want)
+	}
+	if b, _ := ioutil.ReadAll(req.Body); string(b) != `{"a":1}` {
+		t.Errorf("signing consumed the body, %q left", b)
+	}
+
+	for _, c := range []SignerConfig{
+		{Secret: "", Header: "X: {{signature}}"},
+		{Secret: "k", Header: "no colon"},
+		{Secret: "k", Header: "X: {{signature}}", Algorithm: "md5"},
+		{Secret: "k", Header: "X: {{signature}}", Encoding: "base32"},
+		{Secret: "k", Header: "X: {{signature}}", Canonical: "{{signature}}"},
+		{Secret: "k", Header: "X: {{seq}}"},
+		{Secret: "@/no/such/file", Header: "X: {{signature}}"},
+	} {
+		if _, err := NewSigner(c); err == nil {
+			t.Errorf("NewSigner(%+v) must fail", c)
+		}
+	}
+}
+
+func TestReplayable(t *testing.T) {
+	for spec, want := range map[string]bool{"": true, "text": true, "{{seq}}": true, "random:10": false} {
+		b, err := ParseBody(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Replayable(b) != want {
+			t.Errorf("Replayable(%q) = %v", spec, !want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+//BodySource produces the body of each request.
+//Bodies are read while the request is written,so large uploads are never held in memory.
+type BodySource interface {
+	//Next returns the next body and its length.A nil body has length 0.
+	Next() (io.ReadCloser, int64, error)
+}
+
+//ParseBody parses the -B option:
+//	""                 no body
+//	"text"             the literal text,"@@text" for a text starting with "@".
+//	                   Placeholders are expanded per request,see Template.
+//	"@file"            the content of file
+//	"@dir"             the files of dir,rotated per request
+//	"random:SIZE"      SIZE random bytes
+//	"random:MIN-MAX"   a uniformly distributed size of random bytes
+//Sizes accept the k,m and g suffixes.
+func ParseBody(spec string) (BodySource, error) {
+	switch {
+	case spec == "":
+		return literalBody(""), nil
+	case strings.HasPrefix(spec, "@@"):
+		return newLiteralBody(spec[1:])
+	case strings.HasPrefix(spec, "@"):
+		return newFileBody(spec[1:])
+	case strings.HasPrefix(spec, "random:"):
+		return newRandomBody(spec[len("random:"):])
+	}
+	return newLiteralBody(spec)
+}
+
+func newLiteralBody(text string) (BodySource, error) {
+	t, err := ParseTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	if t.IsStatic() {
+		return literalBody(text), nil
+	}
+	return &templateBody{template: t}, nil
+}
+
+type literalBody string
+
+func (b literalBody) Next() (io.ReadCloser, int64, error) {
+	if b == "" {
+		return nil, 0, nil
+	}
+	return newStringBody(string(b)), int64(len(b)), nil
+}
+
+//Replayable reports whether the bodies of b can be read again at send time,
+//which is the case for literal texts.
+func Replayable(b BodySource) bool {
+	switch b.(type) {
+	case literalBody, *templateBody:
+		return true
+	}
+	return b == nil
+}
+
+//fileBody streams files from disk,opening them per request.
+type fileBody struct {
+	files []string
+	next  uint64
+}
+
+func newFileBody(name string) (*fileBody, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return &fileBody{files: []string{name}}, nil
+	}
+	entries, err := ioutil.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	b := &fileBody{}
+	for _, e := range entries {
+		if e.Mode().IsRegular() {
+			b.files = append(b.files, filepath.Join(name, e.Name()))
+		}
+	}
+	if len(b.files) == 0 {
+		return nil, fmt.Errorf("no files in %s", name)
+	}
+	sort.Strings(b.files)
+	return b, nil
+}
+
+func (b *fileBody) Next() (io.ReadCloser, int64, error) {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	r, err := os.Open(b.files[n%uint64(len(b.files))])
+	if err != nil {
+		return nil, 0, err
+	}
+	//stat the opened file,so the length matches what is streamed even if the file was rewritten.
+	info, err := r.Stat()
+	if err != nil {
+		r.Close()
+		return nil, 0, err
+	}
+	if info.Size() == 0 {
+		r.Close()
+		return nil, 0, nil
+	}
+	//never send more than the announced length if the file grows while it's read.
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(r, info.Size()), r}, info.Size(), nil
+}
+
+//randomBlockSize is the size of the random block the payloads are cut from.
+const randomBlockSize = 64 * 1024
+
+//randomBody generates payloads by cycling over a random block from a random offset.
+type randomBody struct {
+	block    []byte
+	min, max int64
+}
+
+func newRandomBody(spec string) (*randomBody, error) {
+	b := &randomBody{block: make([]byte, randomBlockSize)}
+	var err error
+	if i := strings.Index(spec, "-"); i != -1 {
+		if b.min, err = ParseSize(spec[:i]); err == nil {
+			b.max, err = ParseSize(spec[i+1:])
+		}
+	} else if b.min, err = ParseSize(spec); err == nil {
+		b.max = b.min
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b.min > b.max {
+		return nil, fmt.Errorf("invalid random body size %q", spec)
+	}
+	if _, err := rand.Read(b.block); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *randomBody) Next() (io.ReadCloser, int64, error) {
+	size := b.min
+	if b.max > b.min {
+		size += mrand.Int63n(b.max - b.min + 1)
+	}
+	if size == 0 {
+		return nil, 0, nil
+	}
+	return &randomReader{block: b.block, off: mrand.Intn(len(b.block)), remain: size}, size, nil
+}
+
+type randomReader struct {
+	block  []byte
+	off    int
+	remain int64
+}
+
+func (r *randomReader) Read(p []byte) (int, error) {
+	if r.remain <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remain {
+		p = p[:r.remain]
+	}
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.block[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.block)
+	}
+	r.remain -= int64(n)
+	return n, nil
+}
+
+func (r *randomReader) Close() error { return nil }
+
+//ParseSize parses a byte size with an optional k,m or g suffix (powers of 1024).
+func ParseSize(size string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(size))
+	s = strings.TrimSuffix(s, "b")
+	mult := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			mult = 1 << 10
+		case 'm':
+			mult = 1 << 20
+		case 'g':
+			mult = 1 << 30
+		}
+		if mult != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid size " + strconv.Quote(size))
+	}
+	return n * mult, nil
+}
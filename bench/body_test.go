@@ -0,0 +1,124 @@
+package ibench
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{in: "0", want: 0},
+		{in: "1024", want: 1024},
+		{in: "4k", want: 4 << 10},
+		{in: "4KB", want: 4 << 10},
+		{in: "2m", want: 2 << 20},
+		{in: "1g", want: 1 << 30},
+		{in: " 8 ", want: 8},
+		{in: "", err: true},
+		{in: "-1", err: true},
+		{in: "k", err: true},
+		{in: "1.5k", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v", tt.in, got, err)
+		}
+	}
+}
+
+//readBody draws one body from b and returns its announced length and content.
+func readBody(t *testing.T, b BodySource) (int64, string) {
+	t.Helper()
+	r, n, err := b.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil {
+		return n, ""
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != n {
+		t.Fatalf("announced %d bytes, streamed %d", n, len(data))
+	}
+	return n, string(data)
+}
+
+func TestParseBody(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a": "first", "b": "second", "empty": ""} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		spec string
+		want []string //contents of consecutive bodies
+	}{
+		{"", []string{"", ""}},
+		{"hello", []string{"hello", "hello"}},
+		{"@@at", []string{"@at"}},
+		{"@" + filepath.Join(dir, "a"), []string{"first", "first"}},
+		{"@" + dir, []string{"first", "second", "", "first"}},
+	}
+	for _, tt := range tests {
+		b, err := ParseBody(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseBody(%q): %v", tt.spec, err)
+		}
+		for i, want := range tt.want {
+			if _, got := readBody(t, b); got != want {
+				t.Errorf("ParseBody(%q) body %d = %q, want %q", tt.spec, i, got, want)
+			}
+		}
+	}
+	for _, spec := range []string{"@" + filepath.Join(dir, "missing"), "random:x", "random:2k-1k", "@" + t.TempDir()} {
+		if _, err := ParseBody(spec); err == nil {
+			t.Errorf("ParseBody(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestFileBodyFollowsRewrites(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "body")
+	ioutil.WriteFile(name, []byte("short"), 0644)
+	b, err := ParseBody("@" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(name, []byte("a longer body"), 0644)
+	if n, got := readBody(t, b); n != 13 || got != "a longer body" {
+		t.Errorf("after a rewrite got %d %q", n, got)
+	}
+	os.Remove(name)
+	if _, _, err := b.Next(); err == nil {
+		t.Error("a removed file must fail the request")
+	}
+}
+
+func TestRandomBody(t *testing.T) {
+	b, err := ParseBody("random:100k-200k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		n, _ := readBody(t, b)
+		if n < 100<<10 || n > 200<<10 {
+			t.Fatalf("random body of %d bytes outside the range", n)
+		}
+	}
+	b, _ = ParseBody("random:0")
+	if n, _ := readBody(t, b); n != 0 {
+		t.Errorf("random:0 gave %d bytes", n)
+	}
+}
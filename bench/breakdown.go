@@ -0,0 +1,142 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+//breakdownPercentiles are the latency percentiles of a breakdown row.
+var breakdownPercentiles = []float64{50, 90, 99, 100}
+
+//MaxBreakdownKeys bounds the rows of a Breakdown,later keys are counted as BreakdownOther.
+const MaxBreakdownKeys = 200
+
+//BreakdownOther is the key of the samples beyond MaxBreakdownKeys.
+const BreakdownOther = "(other)"
+
+//Breakdown splits the samples of a run by a key,eg the step of a session.
+//Each key has its own lock,so workers only contend when they record the same key.
+type Breakdown struct {
+	Title   string //the name of the key column
+	order   map[string]int
+	keys    int32
+	entries sync.Map //key -> *breakdownEntry
+}
+
+type breakdownEntry struct {
+	mu        sync.Mutex
+	latencies Histogram
+	errors    int
+}
+
+//NewBreakdown returns a Breakdown whose rows are listed in order,keys not in order
+//follow by descending count.
+func NewBreakdown(title string, order ...string) *Breakdown {
+	b := &Breakdown{Title: title, order: make(map[string]int)}
+	for i, k := range order {
+		b.order[k] = i + 1
+	}
+	return b
+}
+
+//Record adds a sample of key.
+func (b *Breakdown) Record(key string, latency time.Duration, failed bool) {
+	v, ok := b.entries.Load(key)
+	if !ok {
+		if atomic.AddInt32(&b.keys, 1) > MaxBreakdownKeys {
+			atomic.AddInt32(&b.keys, -1)
+			key = BreakdownOther
+		}
+		var loaded bool
+		if v, loaded = b.entries.LoadOrStore(key, &breakdownEntry{}); loaded && key != BreakdownOther {
+			atomic.AddInt32(&b.keys, -1)
+		}
+	}
+	e := v.(*breakdownEntry)
+	e.mu.Lock()
+	e.latencies.Add(latency)
+	if failed {
+		e.errors++
+	}
+	e.mu.Unlock()
+}
+
+//BreakdownRow is the summary of one key.
+type BreakdownRow struct {
+	Key       string
+	Count     int
+	Errors    int
+	Share     float64         //of all samples,0-100
+	Latencies []time.Duration //at the 50th,90th,99th percentile and the maximum
+}
+
+//Rows returns the summary of every key.
+func (b *Breakdown) Rows() []BreakdownRow {
+	var rows []BreakdownRow
+	total := 0
+	b.entries.Range(func(k, v interface{}) bool {
+		e := v.(*breakdownEntry)
+		e.mu.Lock()
+		row := BreakdownRow{
+			Key:       k.(string),
+			Count:     int(e.latencies.Count()),
+			Errors:    e.errors,
+			Latencies: e.latencies.Percentiles(breakdownPercentiles...),
+		}
+		e.mu.Unlock()
+		total += row.Count
+		rows = append(rows, row)
+		return true
+	})
+	for i := range rows {
+		if total > 0 {
+			rows[i].Share = 100 * float64(rows[i].Count) / float64(total)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		oi, oj := b.order[rows[i].Key], b.order[rows[j].Key]
+		switch {
+		case oi != 0 && oj != 0:
+			return oi < oj
+		case oi != 0 || oj != 0:
+			return oi != 0
+		case rows[i].Count != rows[j].Count:
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	return rows
+}
+
+//WriteText writes the rows as an aligned table.
+func (b *Breakdown) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tRequests\tErrors\tShare\tp50\tp90\tp99\tMax\n", b.Title)
+	for _, row := range b.Rows() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%", row.Key, row.Count, row.Errors, row.Share)
+		for _, d := range row.Latencies {
+			fmt.Fprintf(tw, "\t%s", formatDuration(d))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,56 @@
+package ibench
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBreakdownRows(t *testing.T) {
+	b := NewBreakdown("Step", "second", "first")
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				b.Record("first", time.Millisecond, false)
+				b.Record("second", 2*time.Millisecond, i%10 == 0)
+			}
+		}()
+	}
+	wg.Wait()
+	b.Record("extra", time.Second, false)
+	rows := b.Rows()
+	if len(rows) != 3 || rows[0].Key != "second" || rows[1].Key != "first" || rows[2].Key != "extra" {
+		t.Fatalf("rows = %+v", rows)
+	}
+	if rows[0].Count != 400 || rows[0].Errors != 40 || rows[0].Latencies[3] != 2*time.Millisecond {
+		t.Errorf("second = %+v", rows[0])
+	}
+	if rows[2].Share < 0.12 || rows[2].Share > 0.13 {
+		t.Errorf("share of extra = %g", rows[2].Share)
+	}
+	var buf bytes.Buffer
+	b.WriteText(&buf)
+	if !strings.HasPrefix(buf.String(), "Step ") || !strings.Contains(buf.String(), "1000.000ms") {
+		t.Errorf("text:\n%s", buf.String())
+	}
+}
+
+func TestBreakdownBoundsKeys(t *testing.T) {
+	b := NewBreakdown("URL")
+	for i := 0; i < MaxBreakdownKeys+50; i++ {
+		b.Record(fmt.Sprint("/item/", i), time.Millisecond, false)
+	}
+	rows := b.Rows()
+	if len(rows) != MaxBreakdownKeys+1 {
+		t.Fatalf("%d rows, want %d", len(rows), MaxBreakdownKeys+1)
+	}
+	if rows[0].Key != BreakdownOther || rows[0].Count != 50 {
+		t.Errorf("first row = %+v, want the 50 samples beyond the limit", rows[0])
+	}
+}
@@ -0,0 +1,159 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+//ErrDataExhausted is returned by a unique DataSource once every row was used.
+var ErrDataExhausted = errors.New("data file exhausted")
+
+//DataSource draws the rows of a data file for the {{data:COLUMN}} placeholders.
+type DataSource interface {
+	//Next returns the row of the next request.Rows must not be modified.
+	Next() (map[string]string, error)
+	HasColumn(name string) bool
+}
+
+//The modes a data file is read in.
+const (
+	DataSequential = "sequential" //rows in order,starting over after the last one
+	DataRandom     = "random"     //a random row per request
+	DataUnique     = "unique"     //every row once,then ErrDataExhausted
+)
+
+//OpenData loads the data file name.Files ending in .csv are read as csv with the
+//column names in the first line,anything else as json lines of flat objects.
+func OpenData(name, mode string) (DataSource, error) {
+	switch mode {
+	case DataSequential, DataRandom, DataUnique:
+	default:
+		return nil, fmt.Errorf("unknown data mode %q", mode)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := &dataRows{mode: mode, columns: make(map[string]bool)}
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		err = d.readCSV(f)
+	} else {
+		err = d.readJSONLines(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(d.rows) == 0 {
+		return nil, fmt.Errorf("%s: no rows", name)
+	}
+	return d, nil
+}
+
+type dataRows struct {
+	next    uint64
+	mode    string
+	rows    []map[string]string
+	columns map[string]bool
+}
+
+func (d *dataRows) readCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	names, err := cr.Read()
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		d.columns[n] = true
+	}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row := make(map[string]string, len(names))
+		for i, n := range names {
+			row[n] = record[i]
+		}
+		d.rows = append(d.rows, row)
+	}
+}
+
+func (d *dataRows) readJSONLines(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; s.Scan(); line++ {
+		text := bytes.TrimSpace(s.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.UseNumber()
+		var values map[string]interface{}
+		if err := dec.Decode(&values); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		row := make(map[string]string, len(values))
+		for k, v := range values {
+			switch v := v.(type) {
+			case string:
+				row[k] = v
+			case nil:
+				row[k] = ""
+			case json.Number, bool:
+				row[k] = fmt.Sprint(v)
+			default:
+				//nested values are passed on as json,eg to embed them in a json body.
+				b, _ := json.Marshal(v)
+				row[k] = string(b)
+			}
+			d.columns[k] = true
+		}
+		d.rows = append(d.rows, row)
+	}
+	return s.Err()
+}
+
+func (d *dataRows) Next() (map[string]string, error) {
+	switch d.mode {
+	case DataRandom:
+		return d.rows[mrand.Intn(len(d.rows))], nil
+	case DataUnique:
+		n := atomic.AddUint64(&d.next, 1) - 1
+		if n >= uint64(len(d.rows)) {
+			return nil, ErrDataExhausted
+		}
+		return d.rows[n], nil
+	}
+	n := atomic.AddUint64(&d.next, 1) - 1
+	return d.rows[n%uint64(len(d.rows))], nil
+}
+
+func (d *dataRows) HasColumn(name string) bool { return d.columns[name] }
@@ -0,0 +1,228 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+//Dialer makes the connections of the transports,so where the connections go is
+//decided in one place.The zero value dials the given address.
+type Dialer struct {
+	//UnixSocket sends every connection to the unix domain socket at this path.
+	//The address of the request still names the Host header and the tls server name.
+	UnixSocket string
+	//Timeout limits the connect,0 for none.
+	Timeout time.Duration
+	//LocalIP is the source address of the tcp connections,nil lets the kernel choose.
+	LocalIP net.IP
+	//Ports is the range of the source ports,nil lets the kernel choose.
+	Ports *PortRange
+	//Resolver picks the address of the tcp connections,nil leaves it to the system resolver.
+	Resolver *Resolver
+	//Network forces the address family of the tcp connections,"tcp4" or "tcp6",empty for either.
+	Network string
+	//FallbackDelay is how long a connection to a dual-stack host waits for the
+	//first family before racing the other one,0 for the default of 300ms,
+	//negative to try the addresses strictly one after the other.
+	FallbackDelay time.Duration
+}
+
+//maxPortAttempts bounds the ports of a PortRange tried by one dial.
+const maxPortAttempts = 32
+
+//Dial connects to addr on network,or to the unix socket of d.
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+//DialContext is Dial bounded by ctx.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	nd := net.Dialer{Timeout: d.Timeout, FallbackDelay: d.FallbackDelay}
+	if d.UnixSocket != "" {
+		return nd.DialContext(ctx, "unix", d.UnixSocket)
+	}
+	if d.Network != "" && network == "tcp" {
+		network = d.Network
+	}
+	if d.Resolver != nil {
+		var err error
+		if addr, err = d.Resolver.ResolveContext(ctx, network, addr); err != nil {
+			return nil, err
+		}
+	}
+	if d.Ports == nil {
+		if d.LocalIP != nil {
+			nd.LocalAddr = &net.TCPAddr{IP: d.LocalIP}
+		}
+		return nd.DialContext(ctx, network, addr)
+	}
+	//SO_REUSEADDR lets a port be bound again while its last connection is in TIME_WAIT,
+	//the connect still fails if the port is busy towards the same target,then the next port is tried.
+	nd.Control = reuseAddr
+	var err error
+	for i := 0; i < maxPortAttempts && i < d.Ports.Len(); i++ {
+		nd.LocalAddr = &net.TCPAddr{IP: d.LocalIP, Port: d.Ports.Next()}
+		var c net.Conn
+		c, err = nd.DialContext(ctx, network, addr)
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+			return c, err
+		}
+	}
+	return nil, err
+}
+
+func reuseAddr(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) { err = setReuseAddr(fd) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+//WithLocalIP returns a copy of d whose connections come from ip.
+func (d *Dialer) WithLocalIP(ip net.IP) *Dialer {
+	c := *d
+	c.LocalIP = ip
+	return &c
+}
+
+//AddrFamily returns "IPv4" or "IPv6" for the "ip:port" addr,empty if it's no ip address.
+func AddrFamily(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+//Family returns the network of "tcp","tcp4" or "tcp6" for -4 and -6.
+func Family(ipv4, ipv6 bool) (string, error) {
+	switch {
+	case ipv4 && ipv6:
+		return "", errors.New("-4 and -6 can't be combined")
+	case ipv4:
+		return "tcp4", nil
+	case ipv6:
+		return "tcp6", nil
+	}
+	return "tcp", nil
+}
+
+//PortRange hands out the source ports from Min to Max in turn,it's safe for
+//concurrent use so the dialers of all workers can share one.
+type PortRange struct {
+	next uint32 //first for the alignment of atomic operations
+	Min  int
+	Max  int
+}
+
+//ParsePortRange parses "MIN-MAX".
+func ParsePortRange(s string) (*PortRange, error) {
+	i := strings.Index(s, "-")
+	if i == -1 {
+		return nil, fmt.Errorf("invalid port range %q,want MIN-MAX", s)
+	}
+	min, err1 := strconv.Atoi(strings.TrimSpace(s[:i]))
+	max, err2 := strconv.Atoi(strings.TrimSpace(s[i+1:]))
+	if err1 != nil || err2 != nil || min < 1 || max > 65535 || min > max {
+		return nil, fmt.Errorf("invalid port range %q,want MIN-MAX within 1-65535", s)
+	}
+	return &PortRange{Min: min, Max: max}, nil
+}
+
+//Len returns the number of ports in r.
+func (r *PortRange) Len() int { return r.Max - r.Min + 1 }
+
+//Next returns the next port of r,starting over after Max.
+func (r *PortRange) Next() int {
+	n := atomic.AddUint32(&r.next, 1) - 1
+	return r.Min + int(n%uint32(r.Len()))
+}
+
+//ParseLocalIPs parses a comma separated list of source addresses and checks
+//that each of them belongs to an interface of this host.
+func ParseLocalIPs(list string) ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, s := range strings.Split(list, ",") {
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address %q", s)
+		}
+		local := false
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+				local = true
+			}
+		}
+		if !local {
+			return nil, fmt.Errorf("source address %s is not an address of this host", ip)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+//unixSchemes maps the schemes of unix socket targets to the scheme spoken over the socket.
+var unixSchemes = map[string]string{
+	"unix://":       "http",
+	"http+unix://":  "http",
+	"https+unix://": "https",
+}
+
+//UnixTarget splits a unix domain socket target into the url requested over the
+//socket and the path of the socket:
+//	unix:///run/app.sock                http://localhost/ over /run/app.sock
+//	http+unix:///run/app.sock:/v1/x     http://localhost/v1/x over /run/app.sock
+//	https+unix:///run/app.sock:/v1/x    https://localhost/v1/x,tls over /run/app.sock
+//Any other target is returned as it is with an empty socket.
+func UnixTarget(target string) (url, socket string, err error) {
+	for prefix, scheme := range unixSchemes {
+		if !strings.HasPrefix(target, prefix) {
+			continue
+		}
+		socket, path := target[len(prefix):], "/"
+		if i := strings.Index(socket, ":"); i != -1 {
+			socket, path = socket[:i], socket[i+1:]
+		}
+		if !strings.HasPrefix(socket, "/") {
+			return "", "", fmt.Errorf("%q: the socket path must be absolute,eg unix:///run/app.sock", target)
+		}
+		if !strings.HasPrefix(path, "/") {
+			return "", "", errors.New("the request path after the socket must start with /")
+		}
+		return scheme + "://localhost" + path, socket, nil
+	}
+	return target, "", nil
+}
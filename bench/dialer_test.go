@@ -0,0 +1,159 @@
+package ibench
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixTarget(t *testing.T) {
+	tests := []struct {
+		target, url, socket string
+		err                 bool
+	}{
+		{target: "http://h:1/a", url: "http://h:1/a"},
+		{target: "unix:///run/app.sock", url: "http://localhost/", socket: "/run/app.sock"},
+		{target: "http+unix:///run/app.sock:/v1/{{seq}}?a=1", url: "http://localhost/v1/{{seq}}?a=1", socket: "/run/app.sock"},
+		{target: "https+unix:///run/app.sock:/", url: "https://localhost/", socket: "/run/app.sock"},
+		{target: "unix://run/app.sock", err: true},
+		{target: "unix:///run/app.sock:v1", err: true},
+	}
+	for _, tt := range tests {
+		url, socket, err := UnixTarget(tt.target)
+		if (err != nil) != tt.err || url != tt.url || socket != tt.socket {
+			t.Errorf("UnixTarget(%q) = %q, %q, %v", tt.target, url, socket, err)
+		}
+	}
+}
+
+func TestTransportOverUnixSocket(t *testing.T) {
+	for _, tlsOn := range []bool{false, true} {
+		socket := filepath.Join(t.TempDir(), "app.sock")
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Host + r.URL.Path))
+		}))
+		srv.Listener = l
+		url := "http://localhost/a"
+		if tlsOn {
+			srv.StartTLS()
+			url = "https://localhost/a"
+		} else {
+			srv.Start()
+		}
+		client := &http.Client{Transport: &Transport{Dial: (&Dialer{UnixSocket: socket}).Dial, DisableKeepAlives: true}}
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("tls %v: %v", tlsOn, err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != "localhost/a" {
+			t.Errorf("tls %v: body = %q", tlsOn, b)
+		}
+		srv.Close()
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	r, err := ParsePortRange("40000-40002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for i := 0; i < 4; i++ {
+		got = append(got, r.Next())
+	}
+	if got[0] != 40000 || got[2] != 40002 || got[3] != 40000 {
+		t.Errorf("ports = %v", got)
+	}
+	for _, s := range []string{"1", "0-10", "10-5", "1-70000", "a-b"} {
+		if _, err := ParsePortRange(s); err == nil {
+			t.Errorf("ParsePortRange(%q) must fail", s)
+		}
+	}
+}
+
+func TestDialerSourceAddress(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	//a range of a single free port.
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+	d := &Dialer{LocalIP: net.ParseIP("127.0.0.1"), Ports: &PortRange{Min: port, Max: port}}
+	c, err := d.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Skipf("port %d was taken meanwhile: %v", port, err)
+	}
+	if a := c.LocalAddr().(*net.TCPAddr); a.Port != port || !a.IP.Equal(d.LocalIP) {
+		t.Errorf("local address = %v, want 127.0.0.1:%d", a, port)
+	}
+	//the only port of the range is now busy towards the target,the bind or the connect fails depending on the platform.
+	_, err = d.Dial("tcp", l.Addr().String())
+	c.Close()
+	if got := ClassifyError(err); got != "port exhaustion" && got != "source port in use" {
+		t.Errorf("dial with the port taken = %v (%q)", err, got)
+	}
+	if _, err := ParseLocalIPs("127.0.0.1"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseLocalIPs("203.0.113.254"); err == nil {
+		t.Error("a foreign source address must be rejected")
+	}
+}
+
+func TestDialerFamily(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	want := AddrFamily(l.Addr().String())
+	other := map[string]string{"IPv4": "tcp6", "IPv6": "tcp4"}[want]
+	r, _ := NewResolver("both:"+port+":127.0.0.1,both:"+port+":[::1]", ResolveSystem, false)
+	d := &Dialer{Network: map[string]string{"IPv4": "tcp4", "IPv6": "tcp6"}[want], Resolver: r}
+	c, err := d.Dial("tcp", "both:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := AddrFamily(c.RemoteAddr().String()); got != want {
+		t.Errorf("connected over %s,want %s", got, want)
+	}
+	c.Close()
+	d.Network = other
+	if c, err := d.Dial("tcp", l.Addr().String()); err == nil {
+		c.Close()
+		t.Errorf("%s dialed %s", other, l.Addr())
+	}
+	for addr, want := range map[string]string{"1.2.3.4:80": "IPv4", "[::1]:80": "IPv6", "::ffff:1.2.3.4": "IPv4", "host:80": ""} {
+		if got := AddrFamily(addr); got != want {
+			t.Errorf("AddrFamily(%q) = %q, want %q", addr, got, want)
+		}
+	}
+	if _, err := Family(true, true); err == nil {
+		t.Error("-4 and -6 together must fail")
+	}
+}
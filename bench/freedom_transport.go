@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
 )
@@ -45,6 +46,13 @@ func (t *Transport) dial(network, addr string) (c net.Conn, err error) {
 }
 
 func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	//a RoundTripper must close the body even when the request is never written,
+	//otherwise every failed dial leaks the file behind an uploaded body.
+	defer func() {
+		if err != nil && req.Body != nil {
+			req.Body.Close()
+		}
+	}()
 	if req.URL == nil {
 		return nil, errors.New("http: nil Request.URL")
 	}
@@ -54,15 +62,25 @@ func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error
 	if req.URL.Host == "" {
 		return nil, errors.New("http: no Host in request URL")
 	}
+	trace := httptrace.ContextClientTrace(req.Context())
 	cm := t.connectMethodForRequest(req)
-	conn, err := t.getConn(cm)
+	conn, err := t.getConn(cm, trace)
 	if err != nil {
 		return nil, err
 	}
 	if err := req.Write(*conn); err != nil {
 		return nil, err
 	}
-	resp, err = http.ReadResponse(bufio.NewReader(*conn), req)
+	if trace != nil && trace.WroteRequest != nil {
+		trace.WroteRequest(httptrace.WroteRequestInfo{})
+	}
+	br := bufio.NewReader(*conn)
+	if trace != nil && trace.GotFirstResponseByte != nil {
+		if _, err := br.Peek(1); err == nil {
+			trace.GotFirstResponseByte()
+		}
+	}
+	resp, err = http.ReadResponse(br, req)
 	if err != nil {
 		return nil, err
 	}
@@ -76,23 +94,26 @@ func (t *Transport) connectMethodForRequest(treq *http.Request) (cm connectMetho
 	return cm
 }
 
-func (t *Transport) getConn(cm connectMethod) (*net.Conn, error) {
+func (t *Transport) getConn(cm connectMethod, trace *httptrace.ClientTrace) (*net.Conn, error) {
 	if !t.DisableKeepAlives {
 		if t.Conn == nil {
-			conn, err := t.dialConn(cm)
+			conn, err := t.dialConn(cm, trace)
 			if err != nil {
 				return nil, err
 			}
 			t.Conn = *conn
 			return conn, nil
 		} else {
+			if trace != nil && trace.GotConn != nil {
+				trace.GotConn(httptrace.GotConnInfo{Conn: t.Conn, Reused: true})
+			}
 			return &t.Conn, nil
 		}
 	}
-	return t.dialConn(cm)
+	return t.dialConn(cm, trace)
 }
 
-func (t *Transport) dialConn(cm connectMethod) (*net.Conn, error) {
+func (t *Transport) dialConn(cm connectMethod, trace *httptrace.ClientTrace) (*net.Conn, error) {
 	var conn net.Conn
 	var err error
 	if cm.targetScheme == "https" {
@@ -102,21 +123,58 @@ func (t *Transport) dialConn(cm connectMethod) (*net.Conn, error) {
 				SessionTicketsDisabled: true,
 			}
 		}
-		conn, err = tls.Dial("tcp", cm.targetAddr, t.TLSClientConfig)
+		//split tls.Dial into the tcp connect and the handshake,so the trace can time both phases.
+		conn, err = t.traceDial("tcp", cm.targetAddr, trace)
+		if err != nil {
+			return nil, err
+		}
+		config := t.TLSClientConfig
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName = hostname(cm.targetAddr)
+		}
+		tlsConn := tls.Client(conn, config)
+		if trace != nil && trace.TLSHandshakeStart != nil {
+			trace.TLSHandshakeStart()
+		}
+		err = tlsConn.Handshake()
+		if trace != nil && trace.TLSHandshakeDone != nil {
+			trace.TLSHandshakeDone(tlsConn.ConnectionState(), err)
+		}
 		if err != nil {
+			conn.Close()
 			return nil, err
 		}
+		conn = tlsConn
+		if trace != nil && trace.GotConn != nil {
+			trace.GotConn(httptrace.GotConnInfo{Conn: conn})
+		}
 		return &conn, nil
 	} else if cm.targetScheme == "http" {
-		conn, err = t.dial("tcp", cm.addr())
+		conn, err = t.traceDial("tcp", cm.addr(), trace)
 		if err != nil {
 			return nil, err
 		}
+		if trace != nil && trace.GotConn != nil {
+			trace.GotConn(httptrace.GotConnInfo{Conn: conn})
+		}
 		return &conn, err
 	}
 	return nil, errors.New(fmt.Sprintf("Do not support the schema:%s", cm.targetAddr))
 }
 
+//traceDial wraps dial with the ConnectStart and ConnectDone hooks of trace.
+func (t *Transport) traceDial(network, addr string, trace *httptrace.ClientTrace) (net.Conn, error) {
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart(network, addr)
+	}
+	conn, err := t.dial(network, addr)
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone(network, addr, err)
+	}
+	return conn, err
+}
+
 //func (t *Transport) dialConn(cm connectMethod) (*net.Conn, error) {
 //	var conn net.Conn
 //	if cm.targetScheme == "https" {
@@ -149,6 +207,14 @@ func canonicalAddr(url *url.URL) string {
 	return addr
 }
 
+// hostname returns addr without its ":port" suffix and IPv6 brackets.
+func hostname(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
+
 func hasPort(s string) bool { return strings.LastIndex(s, ":") > strings.LastIndex(s, "]") }
 
 type connectMethod struct {
@@ -0,0 +1,34 @@
+package ibench
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type closeTracker struct {
+	*strings.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRoundTripClosesBodyOnError(t *testing.T) {
+	for _, url := range []string{"http://127.0.0.1:1/", "ftp://127.0.0.1/"} {
+		body := &closeTracker{Reader: strings.NewReader("payload")}
+		req, err := http.NewRequest("POST", url, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := &Transport{DisableKeepAlives: true}
+		if _, err := tr.RoundTrip(req); err == nil {
+			t.Fatalf("%s: RoundTrip succeeded", url)
+		}
+		if !body.closed {
+			t.Errorf("%s: body not closed after a failed RoundTrip", url)
+		}
+	}
+}
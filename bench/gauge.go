@@ -0,0 +1,136 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+//MaxGaugeRows bounds the rows of Gauge.WriteText,longer runs merge the seconds into intervals.
+const MaxGaugeRows = 20
+
+//Gauge follows a level over the run,eg the active streams of a multiplexed protocol.
+//Each second keeps the time weighted mean and the peak of the level,the seconds
+//are counted from the first change and end at the last one.
+type Gauge struct {
+	Title   string //the name of the level
+	mu      sync.Mutex
+	start   time.Time
+	last    time.Time //of the last change,the level is accounted up to it
+	level   int
+	seconds []gaugeSecond
+}
+
+type gaugeSecond struct {
+	area time.Duration //the level integrated over the second
+	peak int
+}
+
+//GaugeRow is the level during an interval of a Gauge.
+type GaugeRow struct {
+	From, To time.Duration //since the first change
+	Mean     float64
+	Peak     int
+}
+
+//NewGauge returns a Gauge of the level title.
+func NewGauge(title string) *Gauge {
+	return &Gauge{Title: title}
+}
+
+//Add changes the level by delta.
+func (g *Gauge) Add(delta int) {
+	g.change(time.Now(), delta)
+}
+
+func (g *Gauge) change(now time.Time, delta int) {
+	g.mu.Lock()
+	if g.start.IsZero() {
+		g.start, g.last = now, now
+	}
+	g.advance(now)
+	g.level += delta
+	s := &g.seconds[len(g.seconds)-1]
+	if g.level > s.peak {
+		s.peak = g.level
+	}
+	g.mu.Unlock()
+}
+
+//advance accounts the level from the last change to now.
+func (g *Gauge) advance(now time.Time) {
+	for {
+		sec := int(g.last.Sub(g.start) / time.Second)
+		for len(g.seconds) <= sec {
+			g.seconds = append(g.seconds, gaugeSecond{peak: g.level})
+		}
+		end := g.start.Add(time.Duration(sec+1) * time.Second)
+		if !now.After(end) {
+			g.seconds[sec].area += time.Duration(g.level) * now.Sub(g.last)
+			g.last = now
+			return
+		}
+		g.seconds[sec].area += time.Duration(g.level) * end.Sub(g.last)
+		g.last = end
+	}
+}
+
+//Rows returns the level by second up to the last change,or by intervals of
+//several seconds when that took more than max seconds.
+func (g *Gauge) Rows(max int) []GaugeRow {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.start.IsZero() {
+		return nil
+	}
+	elapsed := g.last.Sub(g.start)
+	step := 1
+	if max > 0 && len(g.seconds) > max {
+		step = (len(g.seconds) + max - 1) / max
+	}
+	var rows []GaugeRow
+	for i := 0; i < len(g.seconds); i += step {
+		row := GaugeRow{From: time.Duration(i) * time.Second, To: time.Duration(i+step) * time.Second}
+		if row.To > elapsed {
+			row.To = elapsed
+		}
+		var area time.Duration
+		for _, s := range g.seconds[i:min(i+step, len(g.seconds))] {
+			area += s.area
+			if s.peak > row.Peak {
+				row.Peak = s.peak
+			}
+		}
+		if span := row.To - row.From; span > 0 {
+			row.Mean = float64(area) / float64(span)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+//WriteText writes the level over time as an aligned table.
+func (g *Gauge) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tMean\tPeak\n", g.Title)
+	for _, row := range g.Rows(MaxGaugeRows) {
+		fmt.Fprintf(tw, "%.1fs-%.1fs\t%.1f\t%d\n", row.From.Seconds(), row.To.Seconds(), row.Mean, row.Peak)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,44 @@
+package ibench
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGaugeRows(t *testing.T) {
+	g := NewGauge("Streams")
+	t0 := time.Now()
+	//2 streams for the first half second,4 up to 1.5s,then none until 2.5s.
+	g.change(t0, 2)
+	g.change(t0.Add(500*time.Millisecond), 2)
+	g.change(t0.Add(1500*time.Millisecond), -4)
+	g.change(t0.Add(2500*time.Millisecond), 0)
+	rows := g.Rows(0)
+	want := []GaugeRow{
+		{From: 0, To: time.Second, Mean: 3, Peak: 4},
+		{From: time.Second, To: 2 * time.Second, Mean: 2, Peak: 4},
+		{From: 2 * time.Second, To: 2500 * time.Millisecond, Mean: 0, Peak: 0},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %+v", rows)
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+	merged := g.Rows(2)
+	if len(merged) != 2 || merged[0].To != 2*time.Second || merged[0].Mean != 2.5 || merged[0].Peak != 4 {
+		t.Errorf("merged = %+v", merged)
+	}
+	var buf bytes.Buffer
+	g.WriteText(&buf)
+	if !strings.HasPrefix(buf.String(), "Streams ") || !strings.Contains(buf.String(), "1.0s-2.0s") {
+		t.Errorf("text:\n%s", buf.String())
+	}
+	if rows := NewGauge("none").Rows(0); rows != nil {
+		t.Errorf("rows of an unchanged gauge = %+v", rows)
+	}
+}
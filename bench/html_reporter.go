@@ -0,0 +1,356 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+//the percentiles shown in the html report.
+var reportPercentiles = []float64{50, 75, 90, 95, 99, 99.9, 100}
+
+type htmlRow struct {
+	Name  string
+	Value string
+}
+
+type htmlPhase struct {
+	Name   string
+	Values []string
+}
+
+type htmlBreakdown struct {
+	Title string
+	Rows  []htmlPhase
+}
+
+type htmlGauge struct {
+	Title string
+	Chart template.HTML
+}
+
+type htmlReport struct {
+	Generated    string
+	Config       []ConfigItem
+	Summary      []htmlRow
+	Percentiles  []string
+	Latencies    []htmlRow
+	Phases       []htmlPhase
+	Status       []htmlRow
+	Errors       []htmlRow
+	Breakdowns   []htmlBreakdown
+	Gauges       []htmlGauge
+	Distribution template.HTML
+	RPSChart     template.HTML
+	LatencyChart template.HTML
+}
+
+//WriteHTMLFile renders the report as a single static html file at name.
+func (r *Reporter) WriteHTMLFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := r.WriteHTML(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//WriteHTML renders the report as a self-contained html page without any external assets.
+func (r *Reporter) WriteHTML(w io.Writer) error {
+	data := htmlReport{
+		Generated: time.Now().Format(time.RFC1123),
+		Config:    r.Config,
+		Summary:   r.summaryRows(),
+	}
+	for _, p := range reportPercentiles {
+		data.Percentiles = append(data.Percentiles, fmt.Sprintf("p%g", p))
+	}
+	if r.Stats != nil {
+		lat := formatDurations(r.Stats.Percentiles(reportPercentiles...))
+		for i, p := range data.Percentiles {
+			data.Latencies = append(data.Latencies, htmlRow{p, lat[i]})
+		}
+		connect, handshake, ttfb := r.Stats.Phases(reportPercentiles...)
+		data.Phases = []htmlPhase{
+			{"Connect", formatDurations(connect)},
+			{"TLS Handshake", formatDurations(handshake)},
+			{"Time To First Byte", formatDurations(ttfb)},
+		}
+		codes := r.Stats.StatusCodes()
+		keys := make([]int, 0, len(codes))
+		for k := range codes {
+			keys = append(keys, k)
+		}
+		sort.Ints(keys)
+		for _, k := range keys {
+			data.Status = append(data.Status, htmlRow{fmt.Sprint(k), fmt.Sprint(codes[k])})
+		}
+		data.Errors = sortedRows(r.Stats.Errors())
+
+		dist := make([]float64, 0, 100)
+		steps := make([]float64, 0, 100)
+		for p := 1.0; p <= 100; p++ {
+			steps = append(steps, p)
+		}
+		for _, d := range r.Stats.Percentiles(steps...) {
+			dist = append(dist, millis(d))
+		}
+		data.Distribution = svgChart("percentile", "ms", []svgSeries{{"latency", "#3366cc", dist}})
+
+		timeline := r.Stats.Timeline()
+		rps := make([]float64, len(timeline))
+		errs := make([]float64, len(timeline))
+		avg := make([]float64, len(timeline))
+		max := make([]float64, len(timeline))
+		for i, b := range timeline {
+			rps[i] = float64(b.Requests)
+			errs[i] = float64(b.Errors)
+			avg[i] = millis(b.AvgLatency())
+			max[i] = millis(b.MaxLatency)
+		}
+		data.RPSChart = svgChart("second", "req/s", []svgSeries{{"requests", "#3366cc", rps}, {"errors", "#dc3912", errs}})
+		data.LatencyChart = svgChart("second", "ms", []svgSeries{{"avg", "#3366cc", avg}, {"max", "#ff9900", max}})
+	}
+	for _, b := range r.Breakdowns {
+		hb := htmlBreakdown{Title: b.Title}
+		for _, row := range b.Rows() {
+			values := []string{fmt.Sprint(row.Count), fmt.Sprint(row.Errors), fmt.Sprintf("%.1f%%", row.Share)}
+			for _, d := range row.Latencies {
+				values = append(values, formatDuration(d))
+			}
+			hb.Rows = append(hb.Rows, htmlPhase{row.Key, values})
+		}
+		data.Breakdowns = append(data.Breakdowns, hb)
+	}
+	for _, g := range r.Gauges {
+		rows := g.Rows(0)
+		mean := make([]float64, len(rows))
+		peak := make([]float64, len(rows))
+		for i, row := range rows {
+			mean[i], peak[i] = row.Mean, float64(row.Peak)
+		}
+		chart := svgChart("second", g.Title, []svgSeries{{"mean", "#3366cc", mean}, {"peak", "#ff9900", peak}})
+		data.Gauges = append(data.Gauges, htmlGauge{g.Title, chart})
+	}
+	return htmlTemplate.Execute(w, data)
+}
+
+func (r *Reporter) summaryRows() []htmlRow {
+	rows := []htmlRow{
+		{"Server Software", r.Server},
+		{"Server Hostname", r.Hostname},
+		{"Server Port", r.Port},
+		{"Document Path", r.Path},
+		{"Document Length", fmt.Sprint(r.ContentLength)},
+		{"Concurrency", fmt.Sprint(r.Concurrency)},
+		{"Time Duration", fmt.Sprintf("%dms", r.TimeDur)},
+		{"Avg Time Taken", fmt.Sprintf("%dms", r.avgTimeTaken())},
+		{"Complete Requests", fmt.Sprint(r.TotalRequest)},
+		{"Failed Request", fmt.Sprint(r.FailedRequest)},
+		{"Request Per Second", fmt.Sprint(r.RequestPerSecond)},
+		{"Connections Per Second", fmt.Sprint(r.ConnectionPerSecond)},
+		{"Non2XXCode", fmt.Sprint(r.Non2XXCode)},
+	}
+	for _, d := range r.Details {
+		rows = append(rows, htmlRow{d.Name, d.Value})
+	}
+	return rows
+}
+
+func sortedRows(m map[string]int) []htmlRow {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rows := make([]htmlRow, 0, len(keys))
+	for _, k := range keys {
+		rows = append(rows, htmlRow{k, fmt.Sprint(m[k])})
+	}
+	return rows
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3fms", millis(d))
+}
+
+//formatDurations formats the percentiles of a measurement,"n/a" for each one if
+//nothing was measured rather than pretending the phase took no time.
+func formatDurations(ds []time.Duration) []string {
+	if ds == nil {
+		s := make([]string, len(reportPercentiles))
+		for i := range s {
+			s[i] = "n/a"
+		}
+		return s
+	}
+	s := make([]string, len(ds))
+	for i, d := range ds {
+		s[i] = formatDuration(d)
+	}
+	return s
+}
+
+type svgSeries struct {
+	Name   string
+	Color  string
+	Values []float64
+}
+
+const (
+	svgWidth   = 720
+	svgHeight  = 240
+	svgPadding = 48
+)
+
+//svgChart draws the series as an inline svg line chart.
+func svgChart(xLabel, yLabel string, series []svgSeries) template.HTML {
+	var maxY float64
+	n := 0
+	for _, s := range series {
+		if len(s.Values) > n {
+			n = len(s.Values)
+		}
+		for _, v := range s.Values {
+			if v > maxY {
+				maxY = v
+			}
+		}
+	}
+	if n == 0 {
+		return template.HTML("<p>no data</p>")
+	}
+	if maxY == 0 {
+		maxY = 1
+	}
+	plotW := float64(svgWidth - 2*svgPadding)
+	plotH := float64(svgHeight - 2*svgPadding)
+	x := func(i int) float64 {
+		if n == 1 {
+			return svgPadding + plotW/2
+		}
+		return svgPadding + plotW*float64(i)/float64(n-1)
+	}
+	y := func(v float64) float64 { return svgPadding + plotH - plotH*v/maxY }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, svgWidth, svgHeight, svgWidth, svgHeight)
+	fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%g" height="%g" fill="none" stroke="#ccc"/>`, svgPadding, svgPadding, plotW, plotH)
+	for i := 0; i <= 4; i++ {
+		v := maxY * float64(i) / 4
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%g" y2="%.1f" stroke="#eee"/>`, svgPadding, y(v), svgPadding+plotW, y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" font-size="10" text-anchor="end">%.4g</text>`, svgPadding-4, y(v)+3, v)
+	}
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="10">0</text>`, svgPadding, svgHeight-svgPadding+14)
+	fmt.Fprintf(&b, `<text x="%g" y="%d" font-size="10" text-anchor="end">%d</text>`, svgPadding+plotW, svgHeight-svgPadding+14, n)
+	fmt.Fprintf(&b, `<text x="%g" y="%d" font-size="11" text-anchor="middle">%s</text>`, svgPadding+plotW/2, svgHeight-12, template.HTMLEscapeString(xLabel))
+	fmt.Fprintf(&b, `<text x="12" y="%d" font-size="11">%s</text>`, svgPadding-12, template.HTMLEscapeString(yLabel))
+	for i, s := range series {
+		points := make([]string, len(s.Values))
+		for j, v := range s.Values {
+			points[j] = fmt.Sprintf("%.1f,%.1f", x(j), y(v))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`, s.Color, strings.Join(points, " "))
+		fmt.Fprintf(&b, `<text x="%g" y="%d" font-size="11" fill="%s">%s</text>`, svgPadding+plotW-float64(len(series)-i)*90, svgPadding-12, s.Color, template.HTMLEscapeString(s.Name))
+	}
+	b.WriteString("</svg>")
+	return template.HTML(b.String())
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>iBenchmark Report</title>
+<style>
+body{font-family:Helvetica,Arial,sans-serif;margin:24px;color:#222}
+h1{font-size:22px}h2{font-size:17px;margin-top:28px;border-bottom:1px solid #ddd}
+table{border-collapse:collapse;margin:8px 0}
+td,th{border:1px solid #ddd;padding:4px 10px;text-align:left;font-size:13px}
+th{background:#f4f4f4}
+</style>
+</head>
+<body>
+<h1>iBenchmark Report</h1>
+<p>Generated {{.Generated}}</p>
+
+<h2>Configuration</h2>
+<table>
+{{range .Config}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+
+<h2>Summary</h2>
+<table>
+{{range .Summary}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+
+<h2>Latency Distribution</h2>
+<table>
+<tr>{{range .Latencies}}<th>{{.Name}}</th>{{end}}</tr>
+<tr>{{range .Latencies}}<td>{{.Value}}</td>{{end}}</tr>
+</table>
+{{.Distribution}}
+
+<h2>Requests Over Time</h2>
+{{.RPSChart}}
+
+<h2>Latency Over Time</h2>
+{{.LatencyChart}}
+
+<h2>Status Codes</h2>
+<table>
+<tr><th>Status</th><th>Responses</th></tr>
+{{range .Status}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+
+<h2>Errors</h2>
+<table>
+<tr><th>Error</th><th>Count</th></tr>
+{{range .Errors}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{else}}<tr><td colspan="2">none</td></tr>
+{{end}}</table>
+
+<h2>Phase Timings</h2>
+<table>
+<tr><th>Phase</th>{{range .Percentiles}}<th>{{.}}</th>{{end}}</tr>
+{{range .Phases}}<tr><th>{{.Name}}</th>{{range .Values}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{range .Breakdowns}}
+<h2>By {{.Title}}</h2>
+<table>
+<tr><th>{{.Title}}</th><th>Requests</th><th>Errors</th><th>Share</th><th>p50</th><th>p90</th><th>p99</th><th>Max</th></tr>
+{{range .Rows}}<tr><th>{{.Name}}</th>{{range .Values}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+{{range .Gauges}}
+<h2>{{.Title}} Over Time</h2>
+{{.Chart}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,404 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//H2Config configures an H2Pool.
+type H2Config struct {
+	//Conns is the number of connections the workers share,at least 1.
+	Conns int
+	//Streams caps the concurrent streams of a connection,0 leaves it to the
+	//MAX_CONCURRENT_STREAMS of the server.
+	Streams int
+	//TLSConfig is used for https urls,which must negotiate h2 by alpn.
+	//http urls speak h2c with prior knowledge.
+	TLSConfig *tls.Config
+	//Dial makes the connections of the connection number conn,nil for a net.Dialer.
+	Dial func(ctx context.Context, conn int, network, addr string) (net.Conn, error)
+}
+
+//H2Pool sends the requests of all workers over a fixed number of HTTP/2
+//connections,each worker sticks to one of them.
+type H2Pool struct {
+	conns []*h2Conn
+	Stats *H2Stats
+	//Conns breaks the requests down by connection.
+	Conns *Breakdown
+}
+
+//h2Conn is one connection of an H2Pool,its transport redials it after a GOAWAY.
+//The streams are capped by the gate rather than by the transport,whose strict
+//mode can stall once the requests outnumber MAX_CONCURRENT_STREAMS,and without
+//it the transport would open more connections.
+type h2Conn struct {
+	name      string
+	pool      *H2Pool
+	transport *http.Transport
+	gate      *streamGate
+}
+
+//NewH2Pool returns the pool of config.
+func NewH2Pool(config H2Config) *H2Pool {
+	if config.Conns < 1 {
+		config.Conns = 1
+	}
+	p := &H2Pool{Stats: &H2Stats{settings: make(map[uint16]uint32)}}
+	order := make([]string, config.Conns)
+	for i := range order {
+		order[i] = fmt.Sprintf("conn %d", i+1)
+	}
+	p.Conns = NewBreakdown("HTTP/2 Connection", order...)
+	for i := 0; i < config.Conns; i++ {
+		i := i
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if config.Dial == nil {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			}
+			return config.Dial(ctx, i, network, addr)
+		}
+		c := &h2Conn{name: order[i], pool: p, gate: newStreamGate(config.Streams)}
+		protocols := new(http.Protocols)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		c.transport = &http.Transport{
+			Protocols: protocols,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return c.observe(conn), nil
+			},
+			DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dial(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				config := config.TLSConfig.Clone()
+				if config == nil {
+					config = &tls.Config{}
+				}
+				config.NextProtos = []string{"h2"}
+				if config.ServerName == "" {
+					config.ServerName = hostname(addr)
+				}
+				tlsConn := tls.Client(conn, config)
+				if err := tlsConn.HandshakeContext(ctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto != "h2" {
+					conn.Close()
+					return nil, fmt.Errorf("http2: the server negotiated %q instead of h2", proto)
+				}
+				return &h2TLSConn{h2ObservedConn: c.observe(tlsConn), tls: tlsConn}, nil
+			},
+		}
+		p.conns = append(p.conns, c)
+	}
+	return p
+}
+
+//Transport returns the RoundTripper of worker.
+func (p *H2Pool) Transport(worker int) http.RoundTripper {
+	return p.conns[worker%len(p.conns)]
+}
+
+//StreamsPerConn returns the most streams a connection had open at once and the
+//mean number of open streams a request shared its connection with,itself included.
+func (p *H2Pool) StreamsPerConn() (peak int, mean float64) {
+	gates := make([]*streamGate, len(p.conns))
+	for i, c := range p.conns {
+		gates[i] = c.gate
+	}
+	return meanStreams(gates)
+}
+
+//RoundTrip implements http.RoundTripper,the stream is held until the body of
+//the response is closed.
+func (c *h2Conn) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := c.gate.acquire(); err != nil {
+		c.pool.Conns.Record(c.name, 0, true)
+		return nil, fmt.Errorf("http2: %v", err)
+	}
+	start := time.Now()
+	resp, err := c.transport.RoundTrip(req)
+	if err == nil && resp.ProtoMajor != 2 {
+		resp.Body.Close()
+		resp, err = nil, fmt.Errorf("http2: the response came over %s", resp.Proto)
+	}
+	if err != nil {
+		c.finish(start, true)
+		return nil, err
+	}
+	c.gate.settle()
+	resp.Body = &h2Body{ReadCloser: resp.Body, conn: c, start: start, failed: resp.StatusCode >= 400}
+	return resp, nil
+}
+
+//finish releases the stream of a request which started at start.
+func (c *h2Conn) finish(start time.Time, failed bool) {
+	c.pool.Conns.Record(c.name, time.Since(start), failed)
+	c.gate.release()
+}
+
+//observe follows the frames of a new connection of c.
+func (c *h2Conn) observe(conn net.Conn) *h2ObservedConn {
+	c.gate.reset()
+	return c.pool.Stats.observe(conn, c.settings)
+}
+
+//settings takes the MAX_CONCURRENT_STREAMS of a SETTINGS frame of the server,a frame
+//without it keeps the last value.
+func (c *h2Conn) settings(maxStreams uint32, ok bool) {
+	if ok {
+		c.gate.setServer(int(maxStreams))
+	}
+}
+
+//h2Body releases the stream of a response when it's closed.
+type h2Body struct {
+	io.ReadCloser
+	conn   *h2Conn
+	start  time.Time
+	failed bool
+	once   sync.Once
+}
+
+func (b *h2Body) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.conn.finish(b.start, b.failed) })
+	return err
+}
+
+//HTTP/2 frame types,RFC 7540 section 6.
+const (
+	h2FrameRSTStream = 0x3
+	h2FrameSettings  = 0x4
+	h2FrameGoAway    = 0x7
+	h2FlagACK        = 0x1
+
+	h2SettingMaxConcurrentStreams = 0x3
+)
+
+//h2ClientPreface starts the client side of a connection,before its first frame.
+const h2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+var h2SettingNames = map[uint16]string{
+	0x1: "HEADER_TABLE_SIZE",
+	0x2: "ENABLE_PUSH",
+	0x3: "MAX_CONCURRENT_STREAMS",
+	0x4: "INITIAL_WINDOW_SIZE",
+	0x5: "MAX_FRAME_SIZE",
+	0x6: "MAX_HEADER_LIST_SIZE",
+	0x8: "ENABLE_CONNECT_PROTOCOL",
+	0x9: "NO_RFC7540_PRIORITIES",
+}
+
+//H2Stats counts the control frames of the HTTP/2 connections.
+type H2Stats struct {
+	conns      int64 //first for the alignment of atomic operations
+	goaways    int64
+	rstsIn     int64
+	rstsOut    int64
+	mu         sync.Mutex
+	settings   map[uint16]uint32
+	goawayCode uint32
+}
+
+//Connections returns the number of connections made,redials after a GOAWAY included.
+func (s *H2Stats) Connections() int64 { return atomic.LoadInt64(&s.conns) }
+
+//Frames returns the GOAWAY frames received and the RST_STREAM frames received and sent.
+func (s *H2Stats) Frames() (goaways, rstsIn, rstsOut int64) {
+	return atomic.LoadInt64(&s.goaways), atomic.LoadInt64(&s.rstsIn), atomic.LoadInt64(&s.rstsOut)
+}
+
+//Settings returns the SETTINGS the servers advertised,the latest value of each.
+func (s *H2Stats) Settings() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]int, 0, len(s.settings))
+	for id := range s.settings {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		name, ok := h2SettingNames[uint16(id)]
+		if !ok {
+			name = fmt.Sprintf("0x%x", id)
+		}
+		parts[i] = fmt.Sprintf("%s=%d", name, s.settings[uint16(id)])
+	}
+	return strings.Join(parts, " ")
+}
+
+//GoAwayCode returns the error code of the last GOAWAY.
+func (s *H2Stats) GoAwayCode() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.goawayCode
+}
+
+//observe counts the frames of conn,settings gets the MAX_CONCURRENT_STREAMS of
+//every SETTINGS frame of the server and whether it was set.
+func (s *H2Stats) observe(conn net.Conn, settings func(maxStreams uint32, ok bool)) *h2ObservedConn {
+	atomic.AddInt64(&s.conns, 1)
+	c := &h2ObservedConn{Conn: conn}
+	c.in.frame = func(typ, flags byte, payload []byte) {
+		s.serverFrame(typ, flags, payload)
+		if typ == h2FrameSettings && flags&h2FlagACK == 0 {
+			maxStreams, ok := h2Setting(payload, h2SettingMaxConcurrentStreams)
+			settings(maxStreams, ok)
+		}
+	}
+	c.out.frame = s.clientFrame
+	c.out.skip = len(h2ClientPreface)
+	return c
+}
+
+//h2Setting returns the value of the setting id in the payload of a SETTINGS frame.
+func h2Setting(payload []byte, id uint16) (uint32, bool) {
+	var v uint32
+	found := false
+	for i := 0; i+6 <= len(payload); i += 6 {
+		if binary.BigEndian.Uint16(payload[i:]) == id {
+			v, found = binary.BigEndian.Uint32(payload[i+2:]), true
+		}
+	}
+	return v, found
+}
+
+func (s *H2Stats) serverFrame(typ, flags byte, payload []byte) {
+	switch typ {
+	case h2FrameRSTStream:
+		atomic.AddInt64(&s.rstsIn, 1)
+	case h2FrameGoAway:
+		atomic.AddInt64(&s.goaways, 1)
+		if len(payload) >= 8 {
+			s.mu.Lock()
+			s.goawayCode = binary.BigEndian.Uint32(payload[4:8])
+			s.mu.Unlock()
+		}
+	case h2FrameSettings:
+		if flags&h2FlagACK != 0 {
+			return
+		}
+		s.mu.Lock()
+		for i := 0; i+6 <= len(payload); i += 6 {
+			s.settings[binary.BigEndian.Uint16(payload[i:])] = binary.BigEndian.Uint32(payload[i+2:])
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *H2Stats) clientFrame(typ, flags byte, payload []byte) {
+	if typ == h2FrameRSTStream {
+		atomic.AddInt64(&s.rstsOut, 1)
+	}
+}
+
+//h2ObservedConn follows the frames read from and written to a connection.
+type h2ObservedConn struct {
+	net.Conn
+	in  h2Framer
+	out h2Framer
+}
+
+func (c *h2ObservedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.in.feed(b[:n])
+	return n, err
+}
+
+func (c *h2ObservedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.out.feed(b[:n])
+	return n, err
+}
+
+//h2TLSConn keeps the tls state of an observed connection visible to net/http,
+//which only speaks h2 over a connection with a ConnectionState.
+type h2TLSConn struct {
+	*h2ObservedConn
+	tls *tls.Conn
+}
+
+func (c *h2TLSConn) ConnectionState() tls.ConnectionState { return c.tls.ConnectionState() }
+
+//h2MaxKeptPayload bounds the payload kept of a counted frame,the debug data of a GOAWAY may be long.
+const h2MaxKeptPayload = 1024
+
+//h2Framer cuts one direction of a connection into frames.Only the payloads of
+//RST_STREAM,SETTINGS and GOAWAY are kept,the others are skipped.
+type h2Framer struct {
+	skip    int //bytes of the preface still to skip
+	head    [9]byte
+	n       int //bytes of head read
+	left    int //payload bytes of the frame still to come
+	payload []byte
+	frame   func(typ, flags byte, payload []byte)
+}
+
+func (f *h2Framer) feed(b []byte) {
+	for len(b) > 0 || f.n == len(f.head) {
+		if f.skip > 0 {
+			n := min(f.skip, len(b))
+			f.skip -= n
+			b = b[n:]
+			continue
+		}
+		if f.n < len(f.head) {
+			n := copy(f.head[f.n:], b)
+			f.n += n
+			b = b[n:]
+			if f.n < len(f.head) {
+				return
+			}
+			f.left = int(f.head[0])<<16 | int(f.head[1])<<8 | int(f.head[2])
+			f.payload = f.payload[:0]
+		}
+		n := min(f.left, len(b))
+		switch f.head[3] {
+		case h2FrameRSTStream, h2FrameSettings, h2FrameGoAway:
+			if keep := min(n, h2MaxKeptPayload-len(f.payload)); keep > 0 {
+				f.payload = append(f.payload, b[:keep]...)
+			}
+		}
+		f.left -= n
+		b = b[n:]
+		if f.left > 0 {
+			return
+		}
+		f.frame(f.head[3], f.head[4], f.payload)
+		f.n = 0
+	}
+}
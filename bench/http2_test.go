@@ -0,0 +1,186 @@
+package ibench
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func h2Frame(typ, flags byte, payload []byte) []byte {
+	b := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), typ, flags, 0, 0, 0, 1}
+	return append(b, payload...)
+}
+
+func TestH2Framer(t *testing.T) {
+	settings := make([]byte, 6)
+	binary.BigEndian.PutUint16(settings, h2SettingMaxConcurrentStreams)
+	binary.BigEndian.PutUint32(settings[2:], 9)
+	stream := []byte(h2ClientPreface)
+	stream = append(stream, h2Frame(h2FrameSettings, 0, settings)...)
+	stream = append(stream, h2Frame(0x0, 0, []byte("data"))...)
+	stream = append(stream, h2Frame(h2FrameSettings, h2FlagACK, nil)...)
+	var got []string
+	f := &h2Framer{skip: len(h2ClientPreface), frame: func(typ, flags byte, payload []byte) {
+		v, ok := h2Setting(payload, h2SettingMaxConcurrentStreams)
+		got = append(got, fmt.Sprintf("%d %d %d %v", typ, flags, v, ok))
+	}}
+	//byte by byte,the frames must not depend on how the reads are cut.
+	for i := range stream {
+		f.feed(stream[i : i+1])
+	}
+	want := []string{"4 0 9 true", "0 0 0 false", "4 1 0 false"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("frames = %q, want %q", got, want)
+	}
+}
+
+func runH2Pool(t *testing.T, pool *H2Pool, url string, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			client := &http.Client{Transport: pool.Transport(id)}
+			for j := 0; j < 5; j++ {
+				resp, err := client.Get(url)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				b, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if string(b) != "HTTP/2.0" {
+					t.Errorf("body = %q", b)
+				}
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("requests stalled")
+	}
+}
+
+func TestH2Pool(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+		w.Write([]byte(r.Proto))
+	})
+	tests := []struct {
+		name           string
+		tls            bool
+		conns, streams int
+		serverStreams  uint32
+		peak           int
+	}{
+		{name: "tls", tls: true, conns: 1, serverStreams: 3, peak: 3},
+		{name: "h2c", conns: 2, serverStreams: 4, peak: 4},
+		{name: "cap", conns: 1, streams: 2, serverStreams: 4, peak: 2},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewUnstartedServer(handler)
+		srv.Config.HTTP2 = &http.HTTP2Config{MaxConcurrentStreams: int(tt.serverStreams)}
+		config := H2Config{Conns: tt.conns, Streams: tt.streams}
+		if tt.tls {
+			srv.EnableHTTP2 = true
+			srv.StartTLS()
+			config.TLSConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+		} else {
+			srv.Config.Protocols = new(http.Protocols)
+			srv.Config.Protocols.SetHTTP1(true)
+			srv.Config.Protocols.SetUnencryptedHTTP2(true)
+			srv.Start()
+		}
+		pool := NewH2Pool(config)
+		//more workers than streams,the extra ones must wait rather than stall.
+		runH2Pool(t, pool, srv.URL, 4*tt.peak*tt.conns)
+		peak, mean := pool.StreamsPerConn()
+		if peak != tt.peak || mean <= 1 {
+			t.Errorf("%s: streams per conn peak %d mean %.1f, want peak %d", tt.name, peak, mean, tt.peak)
+		}
+		if n := pool.Stats.Connections(); n != int64(tt.conns) {
+			t.Errorf("%s: %d connections dialed, want %d", tt.name, n, tt.conns)
+		}
+		if s := pool.Stats.Settings(); !strings.Contains(s, fmt.Sprintf("MAX_CONCURRENT_STREAMS=%d", tt.serverStreams)) {
+			t.Errorf("%s: settings = %q", tt.name, s)
+		}
+		for _, row := range pool.Conns.Rows() {
+			if row.Count == 0 {
+				t.Errorf("%s: %s sent no requests", tt.name, row.Key)
+			}
+		}
+		srv.Close()
+	}
+}
+
+func TestH2PoolRejectsHTTP1(t *testing.T) {
+	//the server offers only http/1.1 by alpn.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	pool := NewH2Pool(H2Config{TLSConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig})
+	if _, err := (&http.Client{Transport: pool.Transport(0)}).Get(srv.URL); err == nil {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestH2PoolDialContext(t *testing.T) {
+	//the listener accepts but never answers,the tls handshake hangs until the request gives up.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	dialed := make(chan context.Context, 1)
+	pool := NewH2Pool(H2Config{
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		Dial: func(ctx context.Context, conn int, network, addr string) (net.Conn, error) {
+			dialed <- ctx
+			return (&Dialer{}).DialContext(ctx, network, addr)
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://"+ln.Addr().String(), nil)
+	start := time.Now()
+	if _, err := pool.Transport(0).RoundTrip(req); err == nil {
+		t.Fatal("round trip to a silent server succeeded")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("round trip gave up after %v,want about the 100ms of the request", d)
+	}
+	select {
+	case c := <-dialed:
+		if c.Done() == nil {
+			t.Error("the dial can't be cancelled")
+		}
+	default:
+		t.Error("Dial was not called")
+	}
+}
@@ -0,0 +1,325 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+//FaultConfig describes the faults a Proxy injects.The zero value forwards traffic untouched.
+type FaultConfig struct {
+	//Latency delays every chunk forwarded from the target to the client.
+	//A spread distribution (uniform,normal) adds jitter.
+	Latency Distribution
+	//Bandwidth limits each direction of a connection to the bytes per second,0 unlimited.
+	Bandwidth int64
+	//ResetRate is the fraction of connections reset (TCP RST) instead of receiving a response.
+	ResetRate float64
+	//PartialRate is the fraction of connections closed after a random part of the first response.
+	PartialRate float64
+	//StallRate is the fraction of connections whose handshake is held for Stall.
+	//In passthrough mode the first client flight (the TLS ClientHello) is held.
+	StallRate float64
+	Stall     time.Duration
+}
+
+//ProxyConfig configures a fault-injection Proxy.
+type ProxyConfig struct {
+	ListenAddr string
+	Target     string
+	//ListenTLS terminates TLS on the listener if non-nil.
+	ListenTLS *tls.Config
+	//TargetTLS dials the target with TLS if non-nil.
+	TargetTLS *tls.Config
+	Faults    FaultConfig
+}
+
+//ProxyStats counts the connections a Proxy handled and the faults it injected.
+type ProxyStats struct {
+	Connections int64
+	Resets      int64
+	Partials    int64
+	Stalls      int64
+	DialErrors  int64
+}
+
+//Proxy forwards tcp connections to a target and injects faults on the way.
+type Proxy struct {
+	config   ProxyConfig
+	listener net.Listener
+	stats    ProxyStats
+}
+
+//NewProxy returns a Proxy for config.
+func NewProxy(config ProxyConfig) (*Proxy, error) {
+	f := config.Faults
+	for _, rate := range []float64{f.ResetRate, f.PartialRate, f.StallRate} {
+		if rate < 0 || rate > 1 {
+			return nil, errors.New("fault rates must be between 0 and 1")
+		}
+	}
+	if f.Bandwidth < 0 {
+		return nil, errors.New("bandwidth must not be negative")
+	}
+	if config.Target == "" {
+		return nil, errors.New("no proxy target")
+	}
+	return &Proxy{config: config}, nil
+}
+
+//Start begins accepting connections in the background.
+func (p *Proxy) Start() error {
+	l, err := net.Listen("tcp", p.config.ListenAddr)
+	if err != nil {
+		return err
+	}
+	p.listener = l
+	go p.accept()
+	return nil
+}
+
+//Addr returns the listening address,nil before Start.
+func (p *Proxy) Addr() net.Addr {
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Addr()
+}
+
+//Close stops accepting connections.Open connections run until either side closes.
+func (p *Proxy) Close() error {
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Close()
+}
+
+//Stats returns a snapshot of the proxy counters.
+func (p *Proxy) Stats() ProxyStats {
+	return ProxyStats{
+		Connections: atomic.LoadInt64(&p.stats.Connections),
+		Resets:      atomic.LoadInt64(&p.stats.Resets),
+		Partials:    atomic.LoadInt64(&p.stats.Partials),
+		Stalls:      atomic.LoadInt64(&p.stats.Stalls),
+		DialErrors:  atomic.LoadInt64(&p.stats.DialErrors),
+	}
+}
+
+func (p *Proxy) accept() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&p.stats.Connections, 1)
+		go p.handle(conn)
+	}
+}
+
+func chance(rate float64) bool {
+	return rate > 0 && mrand.Float64() < rate
+}
+
+func (p *Proxy) handle(client net.Conn) {
+	defer client.Close()
+	f := p.config.Faults
+	stall := chance(f.StallRate)
+	if stall {
+		atomic.AddInt64(&p.stats.Stalls, 1)
+	}
+	var first []byte
+	if p.config.ListenTLS != nil {
+		if stall {
+			time.Sleep(f.Stall)
+		}
+		tlsConn := tls.Server(client, p.config.ListenTLS)
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		client = tlsConn
+	} else if stall {
+		//hold the first client flight,which is the ClientHello for TLS targets.
+		buf := make([]byte, 32*1024)
+		n, err := client.Read(buf)
+		if err != nil {
+			return
+		}
+		first = buf[:n]
+		time.Sleep(f.Stall)
+	}
+
+	var target net.Conn
+	var err error
+	if p.config.TargetTLS != nil {
+		target, err = tls.Dial("tcp", p.config.Target, p.config.TargetTLS)
+	} else {
+		target, err = net.Dial("tcp", p.config.Target)
+	}
+	if err != nil {
+		atomic.AddInt64(&p.stats.DialErrors, 1)
+		return
+	}
+	defer target.Close()
+	if len(first) > 0 {
+		if _, err := target.Write(first); err != nil {
+			return
+		}
+	}
+
+	down := pipeFaults{latency: f.Latency, bandwidth: f.Bandwidth}
+	switch {
+	case chance(f.ResetRate):
+		atomic.AddInt64(&p.stats.Resets, 1)
+		down.reset = true
+	case chance(f.PartialRate):
+		atomic.AddInt64(&p.stats.Partials, 1)
+		down.partial = true
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		pipe(target, client, pipeFaults{bandwidth: f.Bandwidth})
+		done <- struct{}{}
+	}()
+	go func() {
+		pipe(client, target, down)
+		done <- struct{}{}
+	}()
+	//a failing pipe closes both connections,which ends the other one as well.
+	<-done
+	<-done
+}
+
+//resetConn closes c with a TCP RST instead of a FIN.
+func resetConn(c net.Conn) {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		c = tlsConn.NetConn()
+	}
+	if tcpConn, ok := c.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	c.Close()
+}
+
+type pipeFaults struct {
+	latency   Distribution
+	bandwidth int64
+	reset     bool //drop the first chunk and reset the connection
+	partial   bool //forward a random prefix of the first chunk and close
+}
+
+type chunk struct {
+	data []byte
+	due  time.Time
+}
+
+//pipe copies src to dst applying faults.Latency is applied as a delay line,
+//so a large response is shifted in time rather than slowed down.
+//A clean end of src is passed on as a half-close of dst,anything else closes both.
+func pipe(dst, src net.Conn, faults pipeFaults) {
+	chunks := make(chan chunk, 64)
+	var readErr error
+	go func() {
+		defer close(chunks)
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := src.Read(buf)
+			if n > 0 {
+				c := chunk{data: buf[:n], due: time.Now()}
+				if faults.latency != nil {
+					c.due = c.due.Add(faults.latency.Next())
+				}
+				chunks <- c
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+		}
+	}()
+	first, clean := true, true
+	for c := range chunks {
+		if d := time.Until(c.due); d > 0 {
+			time.Sleep(d)
+		}
+		if first {
+			first = false
+			if faults.reset {
+				clean = false
+				break
+			}
+			if faults.partial {
+				writeLimited(dst, c.data[:mrand.Intn(len(c.data))], faults.bandwidth)
+				clean = false
+				break
+			}
+		}
+		if err := writeLimited(dst, c.data, faults.bandwidth); err != nil {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		//chunks is closed here,so readErr is set.
+		if readErr == io.EOF {
+			if cw, ok := dst.(interface{ CloseWrite() error }); ok && cw.CloseWrite() == nil {
+				return
+			}
+		}
+	}
+	//unblock the reader and the other direction.
+	src.Close()
+	if faults.reset {
+		resetConn(dst)
+	} else {
+		dst.Close()
+	}
+	for range chunks {
+	}
+}
+
+//writeLimited writes b to w at no more than bandwidth bytes per second.
+func writeLimited(w io.Writer, b []byte, bandwidth int64) error {
+	if bandwidth <= 0 {
+		_, err := w.Write(b)
+		return err
+	}
+	//write in slices of about 10ms worth of bandwidth.
+	slice := int(bandwidth / 100)
+	if slice < 1 {
+		slice = 1
+	}
+	for len(b) > 0 {
+		n := slice
+		if n > len(b) {
+			n = len(b)
+		}
+		start := time.Now()
+		if _, err := w.Write(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+		wait := time.Duration(int64(n)*int64(time.Second)/bandwidth) - time.Since(start)
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return nil
+}
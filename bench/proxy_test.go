@@ -0,0 +1,95 @@
+package ibench
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWriteLimited(t *testing.T) {
+	tests := []struct {
+		size      int
+		bandwidth int64
+		min, max  time.Duration
+	}{
+		{size: 1 << 20, bandwidth: 0, min: 0, max: 100 * time.Millisecond},
+		{size: 20000, bandwidth: 100000, min: 180 * time.Millisecond, max: 400 * time.Millisecond},
+		{size: 5000, bandwidth: 50000, min: 90 * time.Millisecond, max: 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		start := time.Now()
+		if err := writeLimited(&buf, make([]byte, tt.size), tt.bandwidth); err != nil {
+			t.Fatal(err)
+		}
+		elapsed := time.Since(start)
+		if buf.Len() != tt.size {
+			t.Errorf("wrote %d bytes, want %d", buf.Len(), tt.size)
+		}
+		if elapsed < tt.min || elapsed > tt.max {
+			t.Errorf("%d bytes at %d B/s took %v, want between %v and %v", tt.size, tt.bandwidth, elapsed, tt.min, tt.max)
+		}
+	}
+}
+
+func TestProxyBeforeStart(t *testing.T) {
+	p, err := NewProxy(ProxyConfig{Target: "127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Addr() != nil {
+		t.Error("Addr before Start must be nil")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Close before Start: %v", err)
+	}
+}
+
+func TestProxyForwards(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		c, err := target.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(c, c)
+		c.Close()
+	}()
+	p, err := NewProxy(ProxyConfig{ListenAddr: "127.0.0.1:0", Target: target.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	c, err := net.Dial("tcp", p.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte("ping"))
+	c.(*net.TCPConn).CloseWrite()
+	got, _ := ioutil.ReadAll(c)
+	c.Close()
+	if string(got) != "ping" {
+		t.Errorf("echo through the proxy = %q", got)
+	}
+	if st := p.Stats(); st.Connections != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestNewProxyRejectsBadFaults(t *testing.T) {
+	for _, f := range []FaultConfig{{ResetRate: 2}, {PartialRate: -1}, {Bandwidth: -1}} {
+		if _, err := NewProxy(ProxyConfig{Target: "x:1", Faults: f}); err == nil {
+			t.Errorf("NewProxy accepted %+v", f)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Stage ramps the request rate linearly to Rate over Duration.
+type Stage struct {
+	Duration time.Duration
+	Rate     float64 //requests per second at the end of the stage
+}
+
+//ParseStages parses comma separated DURATION:RATE stages,eg 30s:100,1m:100,30s:0.
+func ParseStages(spec string) ([]Stage, error) {
+	var stages []Stage
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		i := strings.LastIndex(item, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("stage %q is not DURATION:RATE", item)
+		}
+		d, err := time.ParseDuration(item[:i])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("stage %q:invalid duration %q", item, item[:i])
+		}
+		rate, err := strconv.ParseFloat(item[i+1:], 64)
+		if err != nil || rate < 0 || math.IsInf(rate, 0) {
+			return nil, fmt.Errorf("stage %q:invalid rate %q", item, item[i+1:])
+		}
+		stages = append(stages, Stage{Duration: d, Rate: rate})
+	}
+	return stages, nil
+}
+
+//Pacer spaces the requests of all workers to a rate.Without stages the rate is
+//constant,with them it starts at the given rate and follows the stages,after
+//which no more requests are due.It's safe for concurrent use.
+type Pacer struct {
+	mu     sync.Mutex
+	rate   float64
+	stages []Stage
+	start  time.Time
+	due    float64 //requests handed out so far
+}
+
+//NewPacer returns a Pacer of rate requests per second followed by stages.
+func NewPacer(rate float64, stages []Stage) *Pacer {
+	return &Pacer{rate: rate, stages: stages}
+}
+
+//Start sets the time the first request is due,it defaults to the first call of Next.
+func (p *Pacer) Start(t time.Time) {
+	p.mu.Lock()
+	p.start = t
+	p.mu.Unlock()
+}
+
+//Duration returns the length of the stages,0 without stages.
+func (p *Pacer) Duration() time.Duration {
+	var d time.Duration
+	for _, s := range p.stages {
+		d += s.Duration
+	}
+	return d
+}
+
+//Next returns the time the next request is due,ok is false once the stages are over.
+func (p *Pacer) Next() (due time.Time, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+	offset, ok := p.offset(p.due)
+	if ok {
+		p.due++
+	}
+	return p.start.Add(time.Duration(offset * float64(time.Second))), ok
+}
+
+//Wait sleeps until the next request is due,it returns false once the stages are over.
+func (p *Pacer) Wait() bool {
+	due, ok := p.Next()
+	if !ok {
+		return false
+	}
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+	return true
+}
+
+//offset gives the seconds from the start at which n requests were due.
+func (p *Pacer) offset(n float64) (float64, bool) {
+	if len(p.stages) == 0 {
+		return n / p.rate, p.rate > 0
+	}
+	var elapsed float64
+	rate := p.rate
+	for _, s := range p.stages {
+		d := s.Duration.Seconds()
+		count := (rate + s.Rate) / 2 * d
+		if n < count {
+			//solve rate*x+(s.Rate-rate)/(2d)*x*x = n for x in the stage.
+			a := (s.Rate - rate) / (2 * d)
+			if n == 0 {
+				return elapsed, true
+			}
+			return elapsed + 2*n/(rate+math.Sqrt(rate*rate+4*a*n)), true
+		}
+		n -= count
+		elapsed += d
+		rate = s.Rate
+	}
+	return elapsed, false
+}
+
+//String describes the rate of p,eg "100/s" or "0/s then 30s:100,1m:100".
+func (p *Pacer) String() string {
+	rate := strconv.FormatFloat(p.rate, 'f', -1, 64) + "/s"
+	if len(p.stages) == 0 {
+		return rate
+	}
+	stages := make([]string, len(p.stages))
+	for i, s := range p.stages {
+		stages[i] = s.Duration.String() + ":" + strconv.FormatFloat(s.Rate, 'f', -1, 64)
+	}
+	return rate + " then " + strings.Join(stages, ",")
+}
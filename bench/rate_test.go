@@ -0,0 +1,110 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStages(t *testing.T) {
+	stages, err := ParseStages("30s:100, 1m:100,500ms:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Stage{{30 * time.Second, 100}, {time.Minute, 100}, {500 * time.Millisecond, 0}}
+	if len(stages) != len(want) {
+		t.Fatalf("stages = %v", stages)
+	}
+	for i := range want {
+		if stages[i] != want[i] {
+			t.Errorf("stage %d = %v,want %v", i, stages[i], want[i])
+		}
+	}
+	for _, spec := range []string{"", "30s", "x:1", "0s:1", "1s:-1", "1s:x"} {
+		if _, err := ParseStages(spec); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
+
+//dueOffsets returns when the first n requests of p are due,from its start.
+func dueOffsets(p *Pacer, n int) (offsets []time.Duration, over bool) {
+	start := time.Now()
+	p.Start(start)
+	for i := 0; i < n; i++ {
+		due, ok := p.Next()
+		if !ok {
+			return offsets, true
+		}
+		offsets = append(offsets, due.Sub(start))
+	}
+	return offsets, false
+}
+
+func TestPacer(t *testing.T) {
+	offsets, over := dueOffsets(NewPacer(4, nil), 5)
+	for i, d := range offsets {
+		if want := time.Duration(i) * 250 * time.Millisecond; d != want {
+			t.Errorf("constant rate:request %d due at %v,want %v", i, d, want)
+		}
+	}
+	if over || NewPacer(4, nil).Duration() != 0 {
+		t.Error("a constant rate never ends")
+	}
+
+	//from 0 to 20/s over 1s is 10 requests,holding 20/s for 1s is 20 more.
+	p := NewPacer(0, []Stage{{time.Second, 20}, {time.Second, 20}})
+	if p.Duration() != 2*time.Second {
+		t.Errorf("duration %v", p.Duration())
+	}
+	offsets, over = dueOffsets(p, 100)
+	if !over || len(offsets) != 30 {
+		t.Fatalf("%d requests due,over %v,want 30", len(offsets), over)
+	}
+	//the n-th request of the ramp is due when 10t² = n.
+	for n, want := range map[int]time.Duration{0: 0, 1: 316227766, 5: 707106781, 9: 948683298, 10: time.Second, 15: 1250 * time.Millisecond} {
+		if d := offsets[n] - want; d < -time.Microsecond || d > time.Microsecond {
+			t.Errorf("request %d due at %v,want %v", n, offsets[n], want)
+		}
+	}
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i] < offsets[i-1] {
+			t.Errorf("request %d due before the one before it", i)
+		}
+	}
+
+	//a ramp down from 10/s to 0 over 2s is 10 requests,the last ones spaced out.
+	offsets, _ = dueOffsets(NewPacer(10, []Stage{{2 * time.Second, 0}}), 100)
+	if len(offsets) != 10 || offsets[9]-offsets[8] <= offsets[1]-offsets[0] {
+		t.Errorf("ramp down:%v", offsets)
+	}
+
+	if s := NewPacer(0, []Stage{{30 * time.Second, 100}}).String(); s != "0/s then 30s:100" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestPacerWait(t *testing.T) {
+	p := NewPacer(100, []Stage{{50 * time.Millisecond, 100}})
+	start := time.Now()
+	n := 0
+	for p.Wait() {
+		n++
+	}
+	if elapsed := time.Since(start); n != 5 || elapsed < 40*time.Millisecond {
+		t.Errorf("%d requests in %v,want 5 in 50ms", n, elapsed)
+	}
+}
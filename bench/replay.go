@@ -0,0 +1,319 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//ReplayEntry is one recorded request.
+type ReplayEntry struct {
+	Offset time.Duration //since the first recorded request
+	Method string
+	URI    string //the path and query
+	Header http.Header
+	Body   string
+}
+
+//Replay sends recorded requests to the target,either with their original
+//spacing scaled by Speed or as fast as the workers manage.
+type Replay struct {
+	next    uint64 //first for the alignment of atomic operations
+	maxLag  int64
+	entries []ReplayEntry
+	target  *url.URL
+	start   time.Time
+	//Speed scales the recorded timing,2 replays twice as fast,0 ignores the timing.
+	Speed float64
+	//Header is added to every request,over the recorded headers.
+	Header []HeaderTemplate
+	//Patterns breaks the results down by method and url pattern.
+	Patterns *Breakdown
+}
+
+//NewReplay loads the combined format access log or HAR file name,whose requests
+//are sent to the scheme and host of target.
+func NewReplay(name, target string, speed float64) (*Replay, error) {
+	if speed < 0 {
+		return nil, errors.New("negative replay speed")
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ReplayEntry
+	if strings.EqualFold(filepath.Ext(name), ".har") || bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		entries, err = parseHAR(data)
+	} else {
+		entries, err = parseAccessLog(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s: no requests", name)
+	}
+	return &Replay{
+		entries:  entries,
+		target:   &url.URL{Scheme: u.Scheme, Host: u.Host},
+		Speed:    speed,
+		Patterns: NewBreakdown("URL Pattern"),
+		start:    time.Now(),
+	}, nil
+}
+
+//Len returns the number of recorded requests.
+func (r *Replay) Len() int { return len(r.entries) }
+
+//Start sets the time the first request is due.
+func (r *Replay) Start(t time.Time) { r.start = t }
+
+//MaxLag returns how late the most delayed request was sent,because all workers were busy.
+func (r *Replay) MaxLag() time.Duration { return time.Duration(atomic.LoadInt64(&r.maxLag)) }
+
+//Next returns the next request once it's due,false after the last one.
+func (r *Replay) Next() (*ReplayEntry, bool) {
+	n := atomic.AddUint64(&r.next, 1) - 1
+	if n >= uint64(len(r.entries)) {
+		return nil, false
+	}
+	e := &r.entries[n]
+	if r.Speed > 0 {
+		due := r.start.Add(time.Duration(float64(e.Offset) / r.Speed))
+		if wait := time.Until(due); wait > 0 {
+			time.Sleep(wait)
+		} else if lag := int64(-wait); lag > atomic.LoadInt64(&r.maxLag) {
+			//a lost race only understates the lag by the difference of two late requests.
+			atomic.StoreInt64(&r.maxLag, lag)
+		}
+	}
+	return e, true
+}
+
+//NewRequest builds the request of e for ctx.
+func (r *Replay) NewRequest(e *ReplayEntry, ctx *TemplateContext) (*http.Request, error) {
+	u, err := url.Parse(e.URI)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme, u.Host = r.target.Scheme, r.target.Host
+	var body io.Reader
+	if e.Body != "" {
+		body = strings.NewReader(e.Body)
+	}
+	req, err := http.NewRequest(e.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range e.Header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	for _, h := range r.Header {
+		v, err := h.Value.Execute(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(h.Name, v)
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	return req, nil
+}
+
+//Pattern returns the path of uri with the segments which look like ids replaced
+//by ":id":numbers,uuids,hex hashes and tokens of 8 or more characters mixing
+//letters and digits.The query is dropped.
+func Pattern(uri string) string {
+	if i := strings.IndexAny(uri, "?#"); i != -1 {
+		uri = uri[:i]
+	}
+	segments := strings.Split(uri, "/")
+	for i, s := range segments {
+		if isID(s) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isID(s string) bool {
+	if s == "" {
+		return false
+	}
+	digits, letters, hex := 0, 0, true
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			letters++
+		case c >= 'g' && c <= 'z', c >= 'G' && c <= 'Z':
+			letters++
+			hex = false
+		case c == '-' || c == '_':
+			hex = hex && c == '-'
+		default:
+			return false
+		}
+	}
+	switch {
+	case digits == len(s):
+		return true
+	case hex && len(s) >= 12 && digits > 0:
+		return true
+	}
+	return len(s) >= 8 && digits > 0 && letters > 0
+}
+
+//hopHeaders are not replayed,the transport sets its own.
+var hopHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"Te":                true,
+}
+
+//accessLogLine matches the combined log format,the referer and user agent are optional:
+//	127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326 "http://x/" "Mozilla/4.08"
+var accessLogLine = regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "(\S+) (\S+)(?: [^"]*)?" \d{3} \S+(?: "([^"]*)" "([^"]*)")?`)
+
+func parseAccessLog(r io.Reader) ([]ReplayEntry, error) {
+	var entries []ReplayEntry
+	var times []time.Time
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	skipped := 0
+	for s.Scan() {
+		m := accessLogLine.FindStringSubmatch(s.Text())
+		if m == nil {
+			if strings.TrimSpace(s.Text()) != "" {
+				skipped++
+			}
+			continue
+		}
+		t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[1])
+		if err != nil {
+			skipped++
+			continue
+		}
+		e := ReplayEntry{Method: m[2], URI: requestURI(m[3]), Header: make(http.Header)}
+		if m[4] != "" && m[4] != "-" {
+			e.Header.Set("Referer", m[4])
+		}
+		if m[5] != "" && m[5] != "-" {
+			e.Header.Set("User-Agent", m[5])
+		}
+		entries = append(entries, e)
+		times = append(times, t)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 && skipped > 0 {
+		return nil, errors.New("not a combined format access log")
+	}
+	return sortEntries(entries, times), nil
+}
+
+//requestURI returns the path and query of a request target,which may be an absolute url.
+func requestURI(target string) string {
+	if u, err := url.Parse(target); err == nil && u.IsAbs() {
+		return u.RequestURI()
+	}
+	return target
+}
+
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Started time.Time `json:"startedDateTime"`
+			Request struct {
+				Method  string `json:"method"`
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+				PostData *struct {
+					Text string `json:"text"`
+				} `json:"postData"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+func parseHAR(data []byte) ([]ReplayEntry, error) {
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, err
+	}
+	var entries []ReplayEntry
+	var times []time.Time
+	for _, he := range har.Log.Entries {
+		req := he.Request
+		e := ReplayEntry{Method: req.Method, URI: requestURI(req.URL), Header: make(http.Header)}
+		for _, h := range req.Headers {
+			name := http.CanonicalHeaderKey(h.Name)
+			//http/2 pseudo headers like :authority are not headers of a request.
+			if strings.HasPrefix(h.Name, ":") || hopHeaders[name] {
+				continue
+			}
+			e.Header.Add(name, h.Value)
+		}
+		if req.PostData != nil {
+			e.Body = req.PostData.Text
+		}
+		entries = append(entries, e)
+		times = append(times, he.Started)
+	}
+	return sortEntries(entries, times), nil
+}
+
+//sortEntries orders entries by their time and sets their offsets from the first one.
+func sortEntries(entries []ReplayEntry, times []time.Time) []ReplayEntry {
+	index := make([]int, len(entries))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(i, j int) bool { return times[index[i]].Before(times[index[j]]) })
+	sorted := make([]ReplayEntry, len(entries))
+	for i, j := range index {
+		sorted[i] = entries[j]
+		sorted[i].Offset = times[j].Sub(times[index[0]])
+	}
+	return sorted
+}
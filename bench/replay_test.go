@@ -0,0 +1,116 @@
+package ibench
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPattern(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"/", "/"},
+		{"/users/42/orders?page=2", "/users/:id/orders"},
+		{"/v1/items/550e8400-e29b-41d4-a716-446655440000", "/v1/items/:id"},
+		{"/blob/9f86d081884c7d65", "/blob/:id"},
+		{"/s/a1b2c3d4e5", "/s/:id"},
+		{"/static/app.js", "/static/app.js"},
+		{"/api2/index", "/api2/index"},
+	}
+	for _, tt := range tests {
+		if got := Pattern(tt.in); got != tt.want {
+			t.Errorf("Pattern(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func writeReplay(t *testing.T, name, content string) string {
+	t.Helper()
+	name = filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestReplayAccessLog(t *testing.T) {
+	log := `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a?x=1 HTTP/1.1" 200 2326 "http://ref/" "agent/1.0"
+not a log line
+10.0.0.2 - frank [10/Oct/2000:13:55:35 -0700] "POST http://old.example.com/b HTTP/1.0" 201 - "-" "-"
+10.0.0.3 - - [10/Oct/2000:13:55:38 -0700] "HEAD /c HTTP/1.1" 200 0
+`
+	r, err := NewReplay(writeReplay(t, "access.log", log), "https://target:8443/ignored", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 3 {
+		t.Fatalf("%d requests, want 3", r.Len())
+	}
+	var got []string
+	var offsets []time.Duration
+	for {
+		e, ok := r.Next()
+		if !ok {
+			break
+		}
+		req, err := r.NewRequest(e, &TemplateContext{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, req.Method+" "+req.URL.String()+" "+req.Header.Get("User-Agent"))
+		offsets = append(offsets, e.Offset)
+	}
+	want := []string{
+		"POST https://target:8443/b ",
+		"GET https://target:8443/a?x=1 agent/1.0",
+		"HEAD https://target:8443/c ",
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("request %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if offsets[1] != time.Second || offsets[2] != 3*time.Second {
+		t.Errorf("offsets = %v", offsets)
+	}
+
+	if _, err := NewReplay(writeReplay(t, "bad.log", "garbage\n"), "http://t", 1); err == nil {
+		t.Error("a file without log lines must be rejected")
+	}
+}
+
+func TestReplayHAR(t *testing.T) {
+	har := `{"log":{"entries":[
+		{"startedDateTime":"2020-01-01T00:00:00.100Z","request":{"method":"POST","url":"https://site/api/7?q=1",
+		 "headers":[{"name":":authority","value":"site"},{"name":"content-length","value":"2"},{"name":"x-a","value":"1"}],
+		 "postData":{"mimeType":"application/json","text":"{}"}}},
+		{"startedDateTime":"2020-01-01T00:00:00.000Z","request":{"method":"GET","url":"https://site/","headers":[]}}
+	]}}`
+	r, err := NewReplay(writeReplay(t, "trace.har", har), "http://127.0.0.1:1", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, _ := ParseTemplate("override")
+	r.Header = []HeaderTemplate{{Name: "X-A", Value: host}}
+	start := time.Now()
+	r.Start(start)
+	first, _ := r.Next()
+	second, _ := r.Next()
+	if second.Offset != 100*time.Millisecond || time.Since(start) < time.Millisecond {
+		t.Errorf("the second request must wait for its scaled offset, offset %v, waited %v", second.Offset, time.Since(start))
+	}
+	if first.Method != "GET" {
+		t.Errorf("entries must be sorted by time, first is %s", first.Method)
+	}
+	req, err := r.NewRequest(second, &TemplateContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(req.Body)
+	if req.URL.String() != "http://127.0.0.1:1/api/7?q=1" || string(b) != "{}" || req.ContentLength != 2 {
+		t.Errorf("request = %s %q (%d)", req.URL, b, req.ContentLength)
+	}
+	if req.Header.Get("X-A") != "override" || len(req.Header) != 1 {
+		t.Errorf("header = %v", req.Header)
+	}
+}
@@ -16,6 +16,7 @@ package ibench
 
 import (
 	"fmt"
+	"os"
 	"time"
 )
 
@@ -34,17 +35,56 @@ type Reporter struct {
 	RequestPerSecond    int
 	ConnectionPerSecond int
 	Non2XXCode          int
+	Config              []ConfigItem
+	Stats               *Statistics
+	Breakdowns          []*Breakdown
+	//Gauges are levels followed over the run,eg the active streams of -spdy-sessions.
+	Gauges []*Gauge
+	//Details are summary lines of optional features,eg the stickiness of -affinity.
+	Details []ConfigItem
 }
 
-func (r *Reporter) Print() {
-	var avgT int64
+//ConfigItem is one option of the run shown in the reports.
+type ConfigItem struct {
+	Name  string
+	Value string
+}
+
+func (r *Reporter) avgTimeTaken() int64 {
 	if r.TotalRequest == 0 {
-		avgT = 0
-	} else {
-		avgT = r.TimeTaken / 1000 / int64(r.TotalRequest)
+		return 0
 	}
+	return r.TimeTaken / 1000 / int64(r.TotalRequest)
+}
+
+func (r *Reporter) Print() {
+	avgT := r.avgTimeTaken()
 	report := fmt.Sprintf("Server Software:%s\nServer Hostname:%s\nServer Port:%s\n\nRequest Headers:\n%s\n\nDocument Path:%s\nDocument Length:%d\n\nConcurrency:%d\nTime Duration:%dms\nAvg Time Taken:%dms\n\nComplete Requests:%d\nFailed Request:%d\n\nRequest Per Second:%d\nConnections Per Second:%d\n\nNon2XXCode:%d\n\n", r.Server, r.Hostname, r.Port, r.Headers, r.Path, r.ContentLength, r.Concurrency, r.TimeDur, avgT, r.TotalRequest, r.FailedRequest, r.RequestPerSecond, r.ConnectionPerSecond, r.Non2XXCode)
 	fmt.Println(report)
+	if r.Stats != nil {
+		//the failures by class,eg port exhaustion of short connections apart from refused ones.
+		if errs := sortedRows(r.Stats.Errors()); len(errs) > 0 {
+			fmt.Println("Errors:")
+			for _, e := range errs {
+				fmt.Printf("%s:%s\n", e.Name, e.Value)
+			}
+			fmt.Println()
+		}
+	}
+	for _, d := range r.Details {
+		fmt.Printf("%s:%s\n", d.Name, d.Value)
+	}
+	if len(r.Details) > 0 {
+		fmt.Println()
+	}
+	for _, b := range r.Breakdowns {
+		b.WriteText(os.Stdout)
+		fmt.Println()
+	}
+	for _, g := range r.Gauges {
+		g.WriteText(os.Stdout)
+		fmt.Println()
+	}
 }
 
 func (r *Reporter) report(dur int) {
@@ -0,0 +1,144 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//Resolve modes of a Resolver.
+const (
+	ResolveSystem = "system" //dial the hosts without an override by name
+	ResolveOnce   = "once"   //look a host up on its first connection and keep the addresses
+	ResolveConn   = "conn"   //look a host up for every connection
+)
+
+//Resolver picks the address every connection of a Dialer goes to,so a test
+//can aim at chosen backends or a whole pool while the Host header and the tls
+//server name stay those of the url.
+type Resolver struct {
+	next uint64 //first for the alignment of atomic operations
+	mode string
+	//Spread takes the addresses of a host in turn,otherwise the first one is used.
+	Spread bool
+	//static holds the -resolve overrides by "host:port".
+	static map[string][]string
+	mu     sync.Mutex
+	cache  map[string][]string
+	//lookup is net.DefaultResolver.LookupHost,replaced in the tests.
+	lookup func(ctx context.Context, host string) ([]string, error)
+}
+
+//NewResolver returns a Resolver in mode with the overrides of spec,a comma
+//separated list of "host:port:addr",one entry per address:
+//	api.example.com:443:10.0.0.1,api.example.com:443:10.0.0.2,api.example.com:443:[fd00::1]
+func NewResolver(spec, mode string, spread bool) (*Resolver, error) {
+	switch mode {
+	case ResolveSystem, ResolveOnce, ResolveConn:
+	default:
+		return nil, fmt.Errorf("unknown resolve mode %q,want %s,%s or %s", mode, ResolveSystem, ResolveOnce, ResolveConn)
+	}
+	r := &Resolver{
+		mode:   mode,
+		Spread: spread,
+		static: make(map[string][]string),
+		cache:  make(map[string][]string),
+		lookup: net.DefaultResolver.LookupHost,
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid resolve entry %q,want host:port:addr", entry)
+		}
+		ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]"))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q in resolve entry %q", parts[2], entry)
+		}
+		key := net.JoinHostPort(parts[0], parts[1])
+		r.static[key] = append(r.static[key], ip.String())
+	}
+	return r, nil
+}
+
+//Resolve returns the "ip:port" a connection to addr on network goes to,
+//only the addresses of the family of "tcp4" and "tcp6" are taken.
+func (r *Resolver) Resolve(network, addr string) (string, error) {
+	return r.ResolveContext(context.Background(), network, addr)
+}
+
+//ResolveContext is Resolve with the lookup bounded by ctx.
+func (r *Resolver) ResolveContext(ctx context.Context, network, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	ips, ok := r.static[addr]
+	if !ok {
+		if r.mode == ResolveSystem || net.ParseIP(host) != nil {
+			return addr, nil
+		}
+		if ips, err = r.lookupHost(ctx, host); err != nil {
+			return "", err
+		}
+	}
+	if ips = filterFamily(network, ips); len(ips) == 0 {
+		return "", &net.DNSError{Err: "no " + network + " address", Name: host}
+	}
+	ip := ips[0]
+	if r.Spread {
+		ip = ips[(atomic.AddUint64(&r.next, 1)-1)%uint64(len(ips))]
+	}
+	return net.JoinHostPort(ip, port), nil
+}
+
+func (r *Resolver) lookupHost(ctx context.Context, host string) ([]string, error) {
+	if r.mode == ResolveConn {
+		return r.lookup(ctx, host)
+	}
+	//the lock is held over the lookup,so the workers starting together look the host up once.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ips, ok := r.cache[host]; ok {
+		return ips, nil
+	}
+	ips, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	r.cache[host] = ips
+	return ips, nil
+}
+
+func filterFamily(network string, ips []string) []string {
+	want := map[string]string{"tcp4": "IPv4", "tcp6": "IPv6"}[network]
+	if want == "" {
+		return ips
+	}
+	var matched []string
+	for _, ip := range ips {
+		if AddrFamily(ip) == want {
+			matched = append(matched, ip)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,110 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestResolver(t *testing.T) {
+	r, err := NewResolver("api:443:10.0.0.1,api:443:[fd00::1]", ResolveOnce, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups := 0
+	r.lookup = func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		if host == "pool" {
+			return []string{"10.1.0.1", "10.1.0.2", "10.1.0.3"}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var got []string
+	for i := 0; i < 2; i++ {
+		addr, err := r.Resolve("tcp", "api:443")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, addr)
+	}
+	if got[0] != "10.0.0.1:443" || got[1] != "[fd00::1]:443" || lookups != 0 {
+		t.Errorf("overridden addresses = %v,%d lookups", got, lookups)
+	}
+	//other ports of an overridden host are looked up.
+	if _, err := r.Resolve("tcp", "api:80"); ClassifyError(err) != "dns" {
+		t.Errorf("api:80 = %v", err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 6; i++ {
+		addr, err := r.Resolve("tcp", "pool:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[addr] = true
+	}
+	if len(seen) != 3 || lookups != 2 {
+		t.Errorf("spread over %v with %d lookups,want 3 addresses and 2 lookups", seen, lookups)
+	}
+	if addr, _ := r.Resolve("tcp", "127.0.0.1:80"); addr != "127.0.0.1:80" {
+		t.Errorf("an ip is dialed as it is,got %s", addr)
+	}
+
+	r.mode, r.Spread = ResolveConn, false
+	for i := 0; i < 2; i++ {
+		if addr, _ := r.Resolve("tcp", "pool:80"); addr != "10.1.0.1:80" {
+			t.Errorf("without spread the first address is used,got %s", addr)
+		}
+	}
+	if lookups != 4 {
+		t.Errorf("%d lookups,want one per connection", lookups)
+	}
+
+	r.mode = ResolveOnce
+	if addr, _ := r.Resolve("tcp6", "api:443"); addr != "[fd00::1]:443" {
+		t.Errorf("tcp6 takes the ipv6 address,got %s", addr)
+	}
+	if _, err := r.Resolve("tcp6", "pool:80"); ClassifyError(err) != "dns" {
+		t.Errorf("a host without ipv6 addresses = %v", err)
+	}
+
+	r.mode = ResolveSystem
+	if addr, _ := r.Resolve("tcp", "pool:80"); addr != "pool:80" {
+		t.Errorf("system mode dials by name,got %s", addr)
+	}
+
+	for _, spec := range []string{"api:443", "api::10.0.0.1", "api:443:nope"} {
+		if _, err := NewResolver(spec, ResolveOnce, false); err == nil {
+			t.Errorf("NewResolver(%q) must fail", spec)
+		}
+	}
+	if _, err := NewResolver("", "always", false); err == nil {
+		t.Error("an unknown mode must fail")
+	}
+}
+
+func TestTracerAddr(t *testing.T) {
+	tracer := &Tracer{}
+	err := &net.OpError{Op: "dial", Net: "tcp", Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}, Err: errors.New("refused")}
+	if got := tracer.Addr(err); got != "10.0.0.1:80" {
+		t.Errorf("Addr of a failed dial = %q", got)
+	}
+	tracer.RemoteAddr = "10.0.0.2:80"
+	if got := tracer.Addr(err); got != "10.0.0.2:80" {
+		t.Errorf("Addr = %q", got)
+	}
+}
@@ -0,0 +1,361 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/albus01/ibenchmark/gospdy"
+)
+
+//Distribution draws artificial latencies.A nil Distribution adds no latency.
+type Distribution interface {
+	Next() time.Duration
+	String() string
+}
+
+type constantDistribution time.Duration
+
+func (d constantDistribution) Next() time.Duration { return time.Duration(d) }
+func (d constantDistribution) String() string      { return time.Duration(d).String() }
+
+type uniformDistribution struct{ min, max time.Duration }
+
+func (d uniformDistribution) Next() time.Duration {
+	return d.min + time.Duration(mrand.Int63n(int64(d.max-d.min)+1))
+}
+func (d uniformDistribution) String() string { return fmt.Sprintf("uniform:%s,%s", d.min, d.max) }
+
+type normalDistribution struct{ mean, stddev time.Duration }
+
+func (d normalDistribution) Next() time.Duration {
+	v := time.Duration(mrand.NormFloat64()*float64(d.stddev)) + d.mean
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+func (d normalDistribution) String() string { return fmt.Sprintf("normal:%s,%s", d.mean, d.stddev) }
+
+type exponentialDistribution time.Duration
+
+func (d exponentialDistribution) Next() time.Duration {
+	return time.Duration(mrand.ExpFloat64() * float64(d))
+}
+func (d exponentialDistribution) String() string { return "exp:" + time.Duration(d).String() }
+
+//ParseDistribution parses a latency distribution:
+//	"10ms"               constant
+//	"uniform:5ms,20ms"   uniform between min and max
+//	"normal:20ms,5ms"    normal with mean and standard deviation
+//	"exp:10ms"           exponential with mean
+//An empty string returns a nil Distribution.
+func ParseDistribution(s string) (Distribution, error) {
+	if s == "" {
+		return nil, nil
+	}
+	kind, args := "const", s
+	if i := strings.Index(s, ":"); i != -1 {
+		kind, args = s[:i], s[i+1:]
+	}
+	var ds []time.Duration
+	for _, a := range strings.Split(args, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(a))
+		if err != nil {
+			return nil, err
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("negative latency %s", d)
+		}
+		ds = append(ds, d)
+	}
+	switch {
+	case kind == "const" && len(ds) == 1:
+		return constantDistribution(ds[0]), nil
+	case kind == "uniform" && len(ds) == 2 && ds[0] <= ds[1]:
+		return uniformDistribution{ds[0], ds[1]}, nil
+	case kind == "normal" && len(ds) == 2:
+		return normalDistribution{ds[0], ds[1]}, nil
+	case kind == "exp" && len(ds) == 1:
+		return exponentialDistribution(ds[0]), nil
+	}
+	return nil, fmt.Errorf("invalid latency distribution %q", s)
+}
+
+//ServerConfig configures the built-in target server.An empty address disables the listener.
+type ServerConfig struct {
+	HTTPAddr  string
+	HTTPSAddr string
+	SPDYAddr  string
+
+	//CertFile and KeyFile are used by the https and spdy listeners.
+	//If both are empty,a self-signed certificate is generated.
+	CertFile string
+	KeyFile  string
+
+	ResponseSize int
+	Status       int
+	Latency      Distribution
+	ErrorRate    float64 //fraction of requests answered with ErrorStatus,0-1
+	ErrorStatus  int
+}
+
+//Server is a target for iBench which answers every request with a configurable response.
+//The query parameters size,status and delay override the configuration per request.
+type Server struct {
+	config ServerConfig
+	served int64
+	failed int64
+
+	mu        sync.Mutex
+	servers   []*http.Server
+	listeners []net.Listener
+}
+
+//NewServer returns a Server for config.
+func NewServer(config ServerConfig) (*Server, error) {
+	if config.Status == 0 {
+		config.Status = http.StatusOK
+	}
+	if config.ErrorStatus == 0 {
+		config.ErrorStatus = http.StatusInternalServerError
+	}
+	if config.ErrorRate < 0 || config.ErrorRate > 1 {
+		return nil, errors.New("error rate must be between 0 and 1")
+	}
+	if config.ResponseSize < 0 || config.ResponseSize > MaxResponseSize {
+		return nil, fmt.Errorf("response size must be between 0 and %d", MaxResponseSize)
+	}
+	if config.HTTPAddr == "" && config.HTTPSAddr == "" && config.SPDYAddr == "" {
+		return nil, errors.New("no listen address")
+	}
+	return &Server{config: config}, nil
+}
+
+//MaxResponseSize caps the response size of the server,including the size query parameter.
+const MaxResponseSize = 1 << 30
+
+//payloadBlock is the body every response is cut from,so serving allocates nothing per request.
+var payloadBlock = func() []byte {
+	b := make([]byte, 32*1024)
+	for i := range b {
+		b[i] = 'a' + byte(i%26)
+	}
+	return b
+}()
+
+//writePayload writes n bytes of payloadBlock to w.
+func writePayload(w io.Writer, n int) error {
+	for n > 0 {
+		b := payloadBlock
+		if n < len(b) {
+			b = b[:n]
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		n -= len(b)
+	}
+	return nil
+}
+
+//Served returns the number of requests answered so far.
+func (s *Server) Served() int64 { return atomic.LoadInt64(&s.served) }
+
+//Failed returns the number of requests answered with an injected error.
+func (s *Server) Failed() int64 { return atomic.LoadInt64(&s.failed) }
+
+//ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&s.served, 1)
+	status, size := s.config.Status, s.config.ResponseSize
+	q := req.URL.Query()
+	if v := q.Get("size"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			size = n
+			if size > MaxResponseSize {
+				size = MaxResponseSize
+			}
+		}
+	}
+	if v := q.Get("status"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 100 && n < 600 {
+			status = n
+		}
+	}
+	if v := q.Get("delay"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			time.Sleep(d)
+		}
+	} else if s.config.Latency != nil {
+		time.Sleep(s.config.Latency.Next())
+	}
+	if s.config.ErrorRate > 0 && mrand.Float64() < s.config.ErrorRate {
+		atomic.AddInt64(&s.failed, 1)
+		status = s.config.ErrorStatus
+	}
+	if req.Body != nil {
+		//drain uploads so the request cost includes the body.
+		io.Copy(ioutil.Discard, req.Body)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	w.Header().Set("Server", "iBench")
+	w.WriteHeader(status)
+	if req.Method != "HEAD" {
+		writePayload(w, size)
+	}
+}
+
+//Start begins listening on the configured addresses and serves in the background.
+func (s *Server) Start() error {
+	var certs []tls.Certificate
+	if s.config.HTTPSAddr != "" || s.config.SPDYAddr != "" {
+		var cert tls.Certificate
+		var err error
+		if s.config.CertFile != "" || s.config.KeyFile != "" {
+			cert, err = tls.LoadX509KeyPair(s.config.CertFile, s.config.KeyFile)
+		} else {
+			cert, err = SelfSignedCertificate("localhost", "127.0.0.1", "::1")
+		}
+		if err != nil {
+			return err
+		}
+		certs = []tls.Certificate{cert}
+	}
+	if s.config.HTTPAddr != "" {
+		if err := s.listen(s.config.HTTPAddr, &http.Server{Handler: s}, false); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	if s.config.HTTPSAddr != "" {
+		srv := &http.Server{
+			Handler:   s,
+			TLSConfig: &tls.Config{Certificates: certs, NextProtos: []string{"http/1.1"}},
+			//keep https on http/1.1 so it measures the same protocol as the client.
+			TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+		}
+		if err := s.listen(s.config.HTTPSAddr, srv, true); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	if s.config.SPDYAddr != "" {
+		srv := &http.Server{
+			Handler:   s,
+			TLSConfig: &tls.Config{Certificates: certs},
+		}
+		spdy.AddSPDY(srv)
+		if err := s.listen(s.config.SPDYAddr, srv, true); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) listen(addr string, srv *http.Server, secure bool) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if secure {
+		l = tls.NewListener(l, srv.TLSConfig)
+	}
+	s.mu.Lock()
+	s.servers = append(s.servers, srv)
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+	go srv.Serve(l)
+	return nil
+}
+
+//Addrs returns the addresses the server is listening on,in the order http,https,spdy.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]net.Addr, len(s.listeners))
+	for i, l := range s.listeners {
+		addrs[i] = l.Addr()
+	}
+	return addrs
+}
+
+//Close stops all listeners.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for _, srv := range s.servers {
+		if e := srv.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.servers, s.listeners = nil, nil
+	return err
+}
+
+//SelfSignedCertificate generates an RSA certificate valid for the given host names and IPs.
+//RSA keeps both the RSA and the ECDHE_RSA cipher suites usable.
+func SelfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"iBenchmark"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
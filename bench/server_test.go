@@ -0,0 +1,148 @@
+package ibench
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	spdy "github.com/albus01/ibenchmark/gospdy"
+)
+
+func TestParseDistribution(t *testing.T) {
+	tests := []struct {
+		spec     string
+		want     string //String() of the result,"" for nil
+		min, max time.Duration
+		err      bool
+	}{
+		{spec: ""},
+		{spec: "10ms", want: "10ms", min: 10 * time.Millisecond, max: 10 * time.Millisecond},
+		{spec: "uniform:5ms,20ms", want: "uniform:5ms,20ms", min: 5 * time.Millisecond, max: 20 * time.Millisecond},
+		{spec: "normal:20ms,5ms", want: "normal:20ms,5ms", min: 0, max: time.Hour},
+		{spec: "exp:10ms", want: "exp:10ms", min: 0, max: time.Hour},
+		{spec: "uniform:20ms,5ms", err: true},
+		{spec: "uniform:5ms", err: true},
+		{spec: "exp:-1ms", err: true},
+		{spec: "poisson:1ms", err: true},
+		{spec: "fast", err: true},
+	}
+	for _, tt := range tests {
+		d, err := ParseDistribution(tt.spec)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseDistribution(%q) succeeded, want error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDistribution(%q): %v", tt.spec, err)
+			continue
+		}
+		if d == nil {
+			if tt.want != "" {
+				t.Errorf("ParseDistribution(%q) = nil", tt.spec)
+			}
+			continue
+		}
+		if d.String() != tt.want {
+			t.Errorf("ParseDistribution(%q) = %s, want %s", tt.spec, d, tt.want)
+		}
+		for i := 0; i < 100; i++ {
+			if v := d.Next(); v < tt.min || v > tt.max {
+				t.Fatalf("%s drew %v outside [%v,%v]", d, v, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestServerResponseSize(t *testing.T) {
+	s, err := NewServer(ServerConfig{HTTPAddr: ":0", ResponseSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method, query string
+		length, body  int
+	}{
+		{"GET", "", 10, 10},
+		{"GET", "?size=100000", 100000, 100000},
+		{"HEAD", "?size=" + strconv.Itoa(MaxResponseSize+1), MaxResponseSize, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tt.method, "/"+tt.query, nil))
+		if got := w.Header().Get("Content-Length"); got != strconv.Itoa(tt.length) {
+			t.Errorf("%s %s: Content-Length %s, want %d", tt.method, tt.query, got, tt.length)
+		}
+		if w.Body.Len() != tt.body {
+			t.Errorf("%s %s: body of %d bytes, want %d", tt.method, tt.query, w.Body.Len(), tt.body)
+		}
+	}
+	if _, err := NewServer(ServerConfig{HTTPAddr: ":0", ResponseSize: MaxResponseSize + 1}); err == nil {
+		t.Error("a response size above the cap must be rejected")
+	}
+}
+
+func TestServerSPDYByALPN(t *testing.T) {
+	s, err := NewServer(ServerConfig{HTTPSAddr: "127.0.0.1:0", SPDYAddr: "127.0.0.1:0", ResponseSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	addrs := s.Addrs()
+	//the https listener stays on http/1.1,the spdy one must negotiate spdy.
+	for i, want := range []string{"http/1.1", "spdy/3.1"} {
+		var mu sync.Mutex
+		negotiated := make(map[string]int)
+		url := "https://" + addrs[i].String() + "/"
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				//a transport per goroutine like the workers of iBench.
+				tr := &spdy.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+					Negotiated: func(proto string) {
+						mu.Lock()
+						negotiated[proto]++
+						mu.Unlock()
+					},
+				}
+				for j := 0; j < 10; j++ {
+					resp, err := (&http.Client{Transport: tr}).Get(url)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					b, _ := ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+					if len(b) != 3 {
+						t.Errorf("%s: body of %d bytes", want, len(b))
+					}
+				}
+			}()
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: requests stalled", want)
+		}
+		if len(negotiated) != 1 || negotiated[want] == 0 {
+			t.Errorf("negotiated %v, want %s", negotiated, want)
+		}
+	}
+}
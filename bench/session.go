@@ -0,0 +1,333 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//Session is an ordered flow of requests,eg login,fetch a list,fetch an item,
+//which every virtual user runs from the start to the end.Values extracted from
+//a response are available to the later steps as {{var:NAME}}.
+type Session struct {
+	seq          uint64 //first for the alignment of atomic operations
+	Steps        []*SessionStep
+	StepStats    *Breakdown //by step name
+	SessionStats *Breakdown //the complete sessions,think time included
+}
+
+//SessionStep is one request of a Session.
+type SessionStep struct {
+	Name    string
+	Request *RequestTemplate
+	Extract []Extractor
+	//Think is the pause after the step,nil for none.
+	Think Distribution
+}
+
+//Extractor takes a value from a response into the variable Var.
+//Exactly one of JSON,Regex,Header and Cookie is set:
+//	JSON     a dotted path into a json body,eg "data.items.0.id"
+//	Regex    the first group of a regular expression matched on the body,or the whole match
+//	Header   the value of a response header
+//	Cookie   the value of a cookie set by the response
+type Extractor struct {
+	Var    string `json:"var"`
+	JSON   string `json:"json,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+	Header string `json:"header,omitempty"`
+	Cookie string `json:"cookie,omitempty"`
+	re     *regexp.Regexp
+}
+
+//SessionConfig holds the settings of the command line a session file builds on.
+type SessionConfig struct {
+	//BaseURL is prepended to the step urls starting with "/".
+	BaseURL string
+	//Header is sent with every step,the headers of a step override it.
+	Header []HeaderTemplate
+	Data   DataSource
+}
+
+//sessionFile is the json format of a session:
+//	{
+//	  "think": "uniform:500ms,2s",
+//	  "steps": [
+//	    {"name": "login", "method": "POST", "url": "/login", "body": "{\"user\":\"{{data:user}}\"}",
+//	     "headers": ["Content-Type: application/json"], "extract": [{"var": "token", "json": "token"}]},
+//	    {"name": "item", "url": "/items/{{int:1,100}}", "headers": ["Authorization: Bearer {{var:token}}"]}
+//	  ]
+//	}
+//The think time of the session applies after every step without its own.
+type sessionFile struct {
+	Think string `json:"think"`
+	Steps []struct {
+		Name    string      `json:"name"`
+		Method  string      `json:"method"`
+		URL     string      `json:"url"`
+		Headers []string    `json:"headers"`
+		Body    string      `json:"body"`
+		Extract []Extractor `json:"extract"`
+		Think   string      `json:"think"`
+	} `json:"steps"`
+}
+
+//LoadSession reads the session file name.
+func LoadSession(name string, config SessionConfig) (*Session, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parseSession(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return s, nil
+}
+
+func parseSession(data []byte, config SessionConfig) (*Session, error) {
+	var f sessionFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+	if len(f.Steps) == 0 {
+		return nil, errors.New("no steps")
+	}
+	think, err := ParseDistribution(f.Think)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{}
+	vars := make(map[string]bool)
+	names := make(map[string]bool)
+	var order []string
+	for i, fs := range f.Steps {
+		step := &SessionStep{Name: fs.Name, Extract: fs.Extract, Think: think}
+		if step.Name == "" {
+			step.Name = "step " + strconv.Itoa(i+1)
+		}
+		if names[step.Name] {
+			return nil, fmt.Errorf("duplicate step %q", step.Name)
+		}
+		names[step.Name] = true
+		order = append(order, step.Name)
+		if step.Request, err = newStepRequest(fs.Method, fs.URL, fs.Headers, fs.Body, config); err == nil {
+			step.Request.SetVars(copyVars(vars))
+			err = step.Request.Check()
+		}
+		if err == nil && fs.Think != "" {
+			step.Think, err = ParseDistribution(fs.Think)
+		}
+		for j := range step.Extract {
+			if err == nil {
+				err = step.Extract[j].compile()
+			}
+			vars[step.Extract[j].Var] = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("step %q: %v", step.Name, err)
+		}
+		s.Steps = append(s.Steps, step)
+	}
+	s.StepStats = NewBreakdown("Step", order...)
+	s.SessionStats = NewBreakdown("Session")
+	return s, nil
+}
+
+func copyVars(vars map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(vars))
+	for k := range vars {
+		c[k] = true
+	}
+	return c
+}
+
+func newStepRequest(method, url string, headers []string, body string, config SessionConfig) (*RequestTemplate, error) {
+	if method == "" {
+		method = "GET"
+	}
+	if strings.HasPrefix(url, "/") {
+		url = strings.TrimSuffix(config.BaseURL, "/") + url
+	}
+	u, err := ParseTemplate(url)
+	if err != nil {
+		return nil, err
+	}
+	t := &RequestTemplate{Method: method, URL: u, Data: config.Data}
+	t.Header = append(t.Header, config.Header...)
+	for _, h := range headers {
+		i := strings.Index(h, ":")
+		if i == -1 {
+			return nil, fmt.Errorf("header %q has no value", h)
+		}
+		if err := t.SetHeader(h[:i], strings.TrimSpace(h[i+1:])); err != nil {
+			return nil, err
+		}
+	}
+	if t.Body, err = ParseBody(body); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (e *Extractor) compile() error {
+	if e.Var == "" {
+		return errors.New("extract without a var")
+	}
+	n := 0
+	for _, s := range []string{e.JSON, e.Regex, e.Header, e.Cookie} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("extract %q needs exactly one of json,regex,header and cookie", e.Var)
+	}
+	if e.Regex != "" {
+		re, err := regexp.Compile(e.Regex)
+		if err != nil {
+			return err
+		}
+		e.re = re
+	}
+	return nil
+}
+
+//needsBody reports whether the extractor reads the response body.
+func (e *Extractor) needsBody() bool { return e.JSON != "" || e.Regex != "" }
+
+//extract returns the value of e in the response.
+func (e *Extractor) extract(resp *http.Response, body []byte) (string, error) {
+	switch {
+	case e.Header != "":
+		if v := resp.Header.Get(e.Header); v != "" {
+			return v, nil
+		}
+	case e.Cookie != "":
+		for _, c := range resp.Cookies() {
+			if c.Name == e.Cookie {
+				return c.Value, nil
+			}
+		}
+	case e.re != nil:
+		if m := e.re.FindSubmatch(body); m != nil {
+			return string(m[len(m)-1]), nil
+		}
+	default:
+		return jsonPath(body, e.JSON)
+	}
+	return "", fmt.Errorf("nothing to extract into %q", e.Var)
+}
+
+//jsonPath returns the value at the dotted path of the json document data.
+//Strings are returned unquoted,other values as json.
+func jsonPath(data []byte, path string) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return "", fmt.Errorf("json path %q: %v", path, err)
+	}
+	for _, key := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				v = nil
+			} else {
+				v = node[i]
+			}
+		default:
+			v = nil
+		}
+		if v == nil {
+			return "", fmt.Errorf("json path %q not found", path)
+		}
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+//SessionDo sends a request of a session.If readBody is set the body of a
+//response is returned,otherwise it's discarded.
+type SessionDo func(req *http.Request, readBody bool) (*http.Response, []byte, error)
+
+//Run runs the session once as the virtual user worker.The session stops at the
+//first failed step,a response with a status of 400 or above fails the step.
+func (s *Session) Run(worker int, do SessionDo) error {
+	ctx := &TemplateContext{
+		Worker: worker,
+		Seq:    atomic.AddUint64(&s.seq, 1),
+		Vars:   make(map[string]string),
+	}
+	start := time.Now()
+	var err error
+	for i, step := range s.Steps {
+		if err = s.runStep(step, ctx, do); err != nil {
+			err = fmt.Errorf("%s: %v", step.Name, err)
+			break
+		}
+		if step.Think != nil && i < len(s.Steps)-1 {
+			time.Sleep(step.Think.Next())
+		}
+	}
+	s.SessionStats.Record("session", time.Since(start), err != nil)
+	return err
+}
+
+func (s *Session) runStep(step *SessionStep, ctx *TemplateContext, do SessionDo) error {
+	//the data file of the steps is shared,so a session draws one row for all of them.
+	ctx.data = step.Request.Data
+	req, err := step.Request.NewRequest(ctx)
+	if err != nil {
+		s.StepStats.Record(step.Name, 0, true)
+		return err
+	}
+	readBody := false
+	for _, e := range step.Extract {
+		readBody = readBody || e.needsBody()
+	}
+	start := time.Now()
+	resp, body, err := do(req, readBody)
+	latency := time.Since(start)
+	if err == nil && resp.StatusCode >= 400 {
+		err = fmt.Errorf("status %d", resp.StatusCode)
+	}
+	for i := 0; err == nil && i < len(step.Extract); i++ {
+		var v string
+		if v, err = step.Extract[i].extract(resp, body); err == nil {
+			ctx.Vars[step.Extract[i].Var] = v
+		}
+	}
+	s.StepStats.Record(step.Name, latency, err != nil)
+	return err
+}
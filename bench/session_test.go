@@ -0,0 +1,115 @@
+package ibench
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	doc := []byte(`{"data":{"token":"abc","items":[{"id":7},{"id":8,"tags":["x"]}],"n":1.5}}`)
+	tests := []struct {
+		path, want string
+		err        bool
+	}{
+		{path: "data.token", want: "abc"},
+		{path: "$.data.token", want: "abc"},
+		{path: "data.items.1.id", want: "8"},
+		{path: "data.items.1.tags", want: `["x"]`},
+		{path: "data.n", want: "1.5"},
+		{path: "data.items.2.id", err: true},
+		{path: "data.missing", err: true},
+		{path: "data.token.x", err: true},
+	}
+	for _, tt := range tests {
+		got, err := jsonPath(doc, tt.path)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("jsonPath(%q) = %q, %v", tt.path, got, err)
+		}
+	}
+}
+
+func TestParseSessionErrors(t *testing.T) {
+	for _, content := range []string{
+		`{"steps":[]}`,
+		`{"steps":[{"url":"/a","unknown":1}]}`,
+		`{"steps":[{"name":"a","url":"/a"},{"name":"a","url":"/b"}]}`,
+		`{"steps":[{"url":"/a/{{var:token}}"}]}`,
+		`{"steps":[{"url":"/a","extract":[{"var":"x"}]}]}`,
+		`{"steps":[{"url":"/a","extract":[{"var":"x","json":"a","header":"b"}]}]}`,
+		`{"steps":[{"url":"/a","extract":[{"var":"x","regex":"("}]}]}`,
+		`{"think":"soon","steps":[{"url":"/a"}]}`,
+	} {
+		if _, err := parseSession([]byte(content), SessionConfig{BaseURL: "http://h"}); err == nil {
+			t.Errorf("%s must be rejected", content)
+		}
+	}
+}
+
+func TestSessionRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/login":
+			b, _ := ioutil.ReadAll(r.Body)
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "s1"})
+			w.Header().Set("X-Region", "eu")
+			fmt.Fprintf(w, `{"token":"t-%s"}`, b)
+		case r.Header.Get("Authorization") != "Bearer t-ann":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/items":
+			fmt.Fprint(w, `<a href="/items/42">item</a>`)
+		case r.URL.Path == "/items/42":
+			fmt.Fprintf(w, "%s %s", r.URL.Query().Get("sid"), r.URL.Query().Get("region"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	s, err := parseSession([]byte(`{"steps":[
+		{"name":"login","method":"POST","url":"/login","body":"ann",
+		 "extract":[{"var":"token","json":"token"},{"var":"sid","cookie":"sid"},{"var":"region","header":"X-Region"}]},
+		{"name":"list","url":"/items","headers":["Authorization: Bearer {{var:token}}"],
+		 "extract":[{"var":"item","regex":"/items/(\\d+)"}]},
+		{"name":"item","url":"/items/{{var:item}}?sid={{var:sid}}&region={{var:region}}","headers":["Authorization: Bearer {{var:token}}"],
+		 "extract":[{"var":"check","regex":"s1 eu"}]}
+	]}`), SessionConfig{BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(req *http.Request, readBody bool) (*http.Response, []byte, error) {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return resp, b, err
+	}
+	for i := 0; i < 3; i++ {
+		if err := s.Run(0, do); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows := s.StepStats.Rows()
+	if len(rows) != 3 || rows[0].Key != "login" || rows[2].Key != "item" || rows[2].Count != 3 || rows[2].Errors != 0 {
+		t.Errorf("step rows = %+v", rows)
+	}
+
+	//a failing step ends the session.
+	s.Steps[0].Request.Body, _ = ParseBody("bob")
+	err = s.Run(0, do)
+	if err == nil || !strings.Contains(err.Error(), "list: status 401") {
+		t.Errorf("session error = %v", err)
+	}
+	rows = s.StepStats.Rows()
+	if rows[1].Errors != 1 || rows[2].Count != 3 {
+		t.Errorf("step rows after failure = %+v", rows)
+	}
+	if sessions := s.SessionStats.Rows(); len(sessions) != 1 || sessions[0].Count != 4 || sessions[0].Errors != 1 {
+		t.Errorf("session rows = %+v", sessions)
+	}
+}
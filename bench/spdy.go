@@ -0,0 +1,417 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	spdy "github.com/albus01/ibenchmark/gospdy"
+	"github.com/albus01/ibenchmark/gospdy/common"
+	"github.com/albus01/ibenchmark/gospdy/spdy3"
+)
+
+//SPDYConfig configures a SPDYPool.
+type SPDYConfig struct {
+	//Sessions is the number of sessions the workers share,at least 1.
+	Sessions int
+	//Streams caps the concurrent streams of a session,0 leaves it to the
+	//MAX_CONCURRENT_STREAMS of the server.
+	Streams int
+	//Balance picks the session of each stream,see spdy.Transport.
+	Balance   spdy.Balance
+	TLSConfig *tls.Config
+	//Dial makes the connection of the session number session,counting the
+	//sessions dialed again,nil for net.Dial.
+	Dial func(session int, network, addr string) (net.Conn, error)
+	//Negotiated,if not nil,gets the protocol every connection negotiated by alpn.
+	Negotiated func(proto string)
+	//HeaderTimeout,IdleTimeout and Timeout are the stream timeouts of the
+	//sessions,see spdy.Transport.
+	HeaderTimeout time.Duration
+	IdleTimeout   time.Duration
+	Timeout       time.Duration
+	//PingInterval,PingTimeout and Pinged are the keepalive pings of the
+	//sessions,see spdy.Transport.
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+	Pinged       func(rtt time.Duration, err error)
+	//FlowControl,if not nil,gives the flow control of every new session,see
+	//ParseFlowControl.
+	FlowControl func() common.FlowControl
+	//Settings are sent by every new session,see ParseSPDYSettings.
+	Settings common.Settings
+	//FrameObserver,if not nil,gives the observer of the frames of every new
+	//session,see spdy.Transport.
+	FrameObserver func(conn net.Conn) common.FrameObserver
+}
+
+//SPDYPool sends the requests of all workers as concurrent streams over the
+//session pool of a spdy.Transport,which keeps Sessions sessions to the
+//target and caps their streams.Only https urls can be multiplexed,spdy is
+//negotiated by alpn.
+type SPDYPool struct {
+	transport *spdy.Transport
+	mu        sync.Mutex
+	sessions  map[net.Conn]*spdySession
+	dials     int
+	//Sessions breaks the streams down by session.
+	Sessions *Breakdown
+	//Active follows the open streams of all sessions.
+	Active *Gauge
+}
+
+//spdySession follows the streams of a session of a SPDYPool,the transport caps
+//them,so its gate only counts them as the transport hands them out.
+type spdySession struct {
+	name  string
+	proto string //negotiated by the connection
+	gate  *streamGate
+}
+
+//NewSPDYPool returns the pool of config.
+func NewSPDYPool(config SPDYConfig) *SPDYPool {
+	if config.Sessions < 1 {
+		config.Sessions = 1
+	}
+	p := &SPDYPool{sessions: make(map[net.Conn]*spdySession), Active: NewGauge("SPDY Active Streams")}
+	order := make([]string, config.Sessions)
+	for i := range order {
+		order[i] = fmt.Sprintf("session %d", i+1)
+	}
+	p.Sessions = NewBreakdown("SPDY Session", order...)
+	tlsConfig := config.TLSConfig.Clone()
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	p.transport = &spdy.Transport{
+		TLSClientConfig:       tlsConfig,
+		SessionsPerHost:       config.Sessions,
+		MaxStreamsPerSession:  config.Streams,
+		Balance:               config.Balance,
+		ResponseHeaderTimeout: config.HeaderTimeout,
+		IdleTimeout:           config.IdleTimeout,
+		Timeout:               config.Timeout,
+		PingInterval:          config.PingInterval,
+		PingTimeout:           config.PingTimeout,
+		Pinged:                config.Pinged,
+		FrameObserver:         config.FrameObserver,
+		FlowControl:           config.FlowControl,
+		Settings:              config.Settings,
+		Negotiated:            config.Negotiated,
+		Streams: func(conn net.Conn, delta int) {
+			s := p.session(conn)
+			if delta > 0 {
+				s.gate.acquire()
+			} else {
+				s.gate.release()
+			}
+			p.Active.Add(delta)
+		},
+	}
+	if config.Dial != nil {
+		p.transport.Dial = func(network, addr string) (net.Conn, error) {
+			//the transport dials with its lock held,one at a time.
+			p.mu.Lock()
+			session := p.dials
+			p.dials++
+			p.mu.Unlock()
+			return config.Dial(session, network, addr)
+		}
+	}
+	return p
+}
+
+//StreamsPerSession returns the most concurrent streams a session had and the
+//mean a request saw on its session.
+func (p *SPDYPool) StreamsPerSession() (peak int, mean float64) {
+	p.mu.Lock()
+	gates := make([]*streamGate, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		gates = append(gates, s.gate)
+	}
+	p.mu.Unlock()
+	return meanStreams(gates)
+}
+
+//Goaways returns the GOAWAYs the sessions received and the requests they sent
+//again on a new session.
+func (p *SPDYPool) Goaways() (goaways, retries int) {
+	return p.transport.Goaways()
+}
+
+//Stats sums the activity on the sessions.
+func (p *SPDYPool) Stats() common.ConnStats {
+	return p.transport.Stats()
+}
+
+//session returns the session over conn,the sessions are numbered as they come.
+func (p *SPDYPool) session(conn net.Conn) *spdySession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.sessions[conn]
+	if s == nil {
+		s = &spdySession{name: fmt.Sprintf("session %d", len(p.sessions)+1), gate: newStreamGate(0)}
+		s.gate.settle()
+		if c, ok := conn.(*tls.Conn); ok {
+			s.proto = c.ConnectionState().NegotiatedProtocol
+		}
+		p.sessions[conn] = s
+	}
+	return s
+}
+
+//RoundTrip implements http.RoundTripper.The spdy transport buffers the whole
+//response,so the stream is over when it returns.
+func (p *SPDYPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	//the stream starts once the transport gave it a session,it may have to
+	//wait for one below its stream limit.A request sent again after a GOAWAY
+	//gets another session.
+	var s *spdySession
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			s = p.session(info.Conn)
+			start = time.Now()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := p.transport.RoundTrip(req)
+	latency := time.Since(start)
+	if s == nil {
+		p.Sessions.Record(spdyNoSession, latency, true)
+		return resp, err
+	}
+	if err == nil && !strings.HasPrefix(s.proto, "spdy/") {
+		resp.Body.Close()
+		resp, err = nil, fmt.Errorf("spdy: the server negotiated %q instead of spdy", s.proto)
+	}
+	p.Sessions.Record(s.name, latency, err != nil || resp.StatusCode >= 400)
+	return resp, err
+}
+
+//spdyNoSession is the breakdown key of the requests which got no session.
+const spdyNoSession = "no session"
+
+//ParseBalance parses the balance of the streams over the sessions of a
+//SPDYPool,least-streams or round-robin.
+func ParseBalance(name string) (spdy.Balance, error) {
+	switch name {
+	case "least-streams":
+		return spdy.LeastStreams, nil
+	case "round-robin":
+		return spdy.RoundRobin, nil
+	}
+	return 0, fmt.Errorf("unknown spdy balance %q,want least-streams or round-robin", name)
+}
+
+//ParseFlowControl parses a flow control policy of the spdy sessions,it returns
+//the flow control of each new session,nil for the default one:
+//	default           the window of gospdy,regrown once half of it is used
+//	fixed:SIZE        a window of SIZE,regrown after every DATA frame
+//	starved:SIZE      a window of SIZE,regrown only once it's used up
+//	auto[:MIN[-MAX]]  twice the bandwidth-delay product measured by the keepalive
+//	                  pings,64k to the largest window by default
+func ParseFlowControl(spec string) (func() common.FlowControl, error) {
+	kind, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+	window := func(s string) (uint32, error) {
+		n, err := ParseSize(s)
+		if err != nil {
+			return 0, err
+		}
+		if n < 1 || n >= common.MAX_TRANSFER_WINDOW_SIZE {
+			return 0, fmt.Errorf("window %s out of range", s)
+		}
+		return uint32(n), nil
+	}
+	switch kind {
+	case "", "default":
+		if arg != "" {
+			break
+		}
+		return nil, nil
+	case "fixed", "starved":
+		size, err := window(arg)
+		if err != nil {
+			return nil, fmt.Errorf("flow control %q: %v", spec, err)
+		}
+		if kind == "fixed" {
+			return func() common.FlowControl { return spdy3.FixedFlowControl(size) }, nil
+		}
+		return func() common.FlowControl { return spdy3.StarvedFlowControl(size) }, nil
+	case "auto":
+		min, max := uint32(common.DEFAULT_INITIAL_WINDOW_SIZE), uint32(0)
+		if arg != "" {
+			bounds := strings.SplitN(arg, "-", 2)
+			var err error
+			if min, err = window(bounds[0]); err == nil && len(bounds) == 2 {
+				max, err = window(bounds[1])
+			}
+			if err == nil && max != 0 && max < min {
+				err = errors.New("max below min")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("flow control %q: %v", spec, err)
+			}
+		}
+		return func() common.FlowControl { return spdy3.NewAutoFlowControl(min, max) }, nil
+	}
+	return nil, fmt.Errorf("unknown flow control %q,want default,fixed:SIZE,starved:SIZE or auto[:MIN[-MAX]]", spec)
+}
+
+//ParseSPDYSettings parses comma separated spdy SETTINGS,NAME=VALUE[:FLAG]
+//where NAME is a setting such as MAX_CONCURRENT_STREAMS or its numeric id,and
+//FLAG is persist to ask the server to persist it or persisted to send it as a
+//value the server persisted.
+func ParseSPDYSettings(spec string) (common.Settings, error) {
+	settings := make(common.Settings)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.Index(item, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("spdy setting %q is not NAME=VALUE", item)
+		}
+		name, value := strings.ToUpper(strings.TrimSpace(item[:i])), strings.TrimSpace(item[i+1:])
+		id, ok := common.SettingID(strings.TrimPrefix(name, "SETTINGS_"))
+		if !ok {
+			n, err := strconv.ParseUint(name, 10, 24)
+			if err != nil {
+				return nil, fmt.Errorf("unknown spdy setting %q", name)
+			}
+			id = uint32(n)
+		}
+		setting := &common.Setting{ID: id}
+		if j := strings.Index(value, ":"); j >= 0 {
+			switch value[j+1:] {
+			case "persist":
+				setting.Flags = common.FLAG_SETTINGS_PERSIST_VALUE
+			case "persisted":
+				setting.Flags = common.FLAG_SETTINGS_PERSISTED
+			default:
+				return nil, fmt.Errorf("spdy setting %q:unknown flag %q,want persist or persisted", item, value[j+1:])
+			}
+			value = value[:j]
+		}
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("spdy setting %q:invalid value %q", item, value)
+		}
+		setting.Value = uint32(n)
+		settings[id] = setting
+	}
+	return settings, nil
+}
+
+//PingStats sums the round-trip times of spdy pings,Observe fits
+//spdy.Transport.Pinged.
+type PingStats struct {
+	mu       sync.Mutex
+	count    int
+	failed   int
+	min, max time.Duration
+	total    time.Duration
+}
+
+//Observe adds a ping which took rtt or failed with err.
+func (s *PingStats) Observe(rtt time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failed++
+		return
+	}
+	if s.count == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+	s.count++
+	s.total += rtt
+}
+
+//String gives the min,mean and max rtt and the failed pings.
+func (s *PingStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count == 0 {
+		return fmt.Sprintf("no reply,%d failed", s.failed)
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return fmt.Sprintf("min %.2fms,mean %.2fms,max %.2fms over %d pings,%d failed",
+		ms(s.min), ms(s.total)/float64(s.count), ms(s.max), s.count, s.failed)
+}
+
+//SPDYStatsDetails summarizes the activity on spdy sessions for the report,the
+//pings are left to PingStats.
+func SPDYStatsDetails(stats common.ConnStats) []ConfigItem {
+	rsts := func(counts map[common.StatusCode]int) map[string]int {
+		out := make(map[string]int, len(counts))
+		for status, n := range counts {
+			out[status.String()] += n
+		}
+		return out
+	}
+	ratio := func(compressed, plain int64) float64 {
+		if plain == 0 {
+			return 0
+		}
+		return 100 * float64(compressed) / float64(plain)
+	}
+	return []ConfigItem{
+		{Name: "SPDY Frames Sent", Value: frameCounts(stats.FramesSent)},
+		{Name: "SPDY Frames Received", Value: frameCounts(stats.FramesReceived)},
+		{Name: "SPDY Header Bytes", Value: fmt.Sprintf("%d sent,compressed to %d (%.1f%%),%d received,compressed from %d (%.1f%%)",
+			stats.HeaderBytesSent, stats.CompressedHeaderBytesSent, ratio(stats.CompressedHeaderBytesSent, stats.HeaderBytesSent),
+			stats.HeaderBytesReceived, stats.CompressedHeaderBytesReceived, ratio(stats.CompressedHeaderBytesReceived, stats.HeaderBytesReceived))},
+		{Name: "SPDY DATA Bytes", Value: fmt.Sprintf("%d sent,%d received", stats.DataBytesSent, stats.DataBytesReceived)},
+		{Name: "SPDY WINDOW_UPDATE", Value: fmt.Sprintf("%d sent,%d received", stats.WindowUpdatesSent, stats.WindowUpdatesReceived)},
+		{Name: "SPDY Flow Control Stall", Value: stats.FlowControlStall.String()},
+		{Name: "SPDY RST_STREAM Sent", Value: frameCounts(rsts(stats.RSTStreamSent))},
+		{Name: "SPDY RST_STREAM Received", Value: frameCounts(rsts(stats.RSTStreamReceived))},
+		{Name: "SPDY Peak Concurrent Streams", Value: strconv.Itoa(stats.PeakStreams)},
+	}
+}
+
+//frameCounts formats counts by name as "NAME=n" in name order,"none" if empty.
+func frameCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s=%d", name, counts[name])
+	}
+	return strings.Join(names, ",")
+}
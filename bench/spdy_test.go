@@ -0,0 +1,1202 @@
+package ibench
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	spdy "github.com/albus01/ibenchmark/gospdy"
+	"github.com/albus01/ibenchmark/gospdy/common"
+	"github.com/albus01/ibenchmark/gospdy/spdy3"
+	"github.com/albus01/ibenchmark/gospdy/spdy3/frames"
+)
+
+//startSPDYServer starts handler,or a Server answering 3 bytes after 1ms if it's
+//nil,behind a spdy and an https listener.Both are closed when the test ends.
+func startSPDYServer(t *testing.T, handler http.Handler) (spdySrv, httpsSrv *httptest.Server) {
+	if handler == nil {
+		//the address is never listened on,the Server only answers for the test servers.
+		s, err := NewServer(ServerConfig{HTTPAddr: "127.0.0.1:0", ResponseSize: 3, Latency: constantDistribution(time.Millisecond)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler = s
+	}
+	spdySrv = httptest.NewUnstartedServer(handler)
+	spdy.AddSPDY(spdySrv.Config)
+	spdySrv.TLS = spdySrv.Config.TLSConfig
+	spdySrv.StartTLS()
+	t.Cleanup(spdySrv.Close)
+	httpsSrv = httptest.NewTLSServer(handler)
+	t.Cleanup(httpsSrv.Close)
+	return spdySrv, httpsSrv
+}
+
+func TestSPDYPool(t *testing.T) {
+	srv, _ := startSPDYServer(t, nil)
+	url := srv.URL + "/"
+	var mu sync.Mutex
+	negotiated := make(map[string]int)
+	pool := NewSPDYPool(SPDYConfig{
+		Sessions:  2,
+		Streams:   3,
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		Negotiated: func(proto string) {
+			mu.Lock()
+			negotiated[proto]++
+			mu.Unlock()
+		},
+	})
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			client := &http.Client{Transport: pool}
+			for j := 0; j < 5; j++ {
+				resp, err := client.Get(url)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				b, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if len(b) != 3 {
+					t.Errorf("body of %d bytes", len(b))
+				}
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("requests stalled")
+	}
+	if peak, mean := pool.StreamsPerSession(); peak != 3 || mean <= 1 {
+		t.Errorf("streams per session peak %d mean %.1f, want peak 3", peak, mean)
+	}
+	if len(negotiated) != 1 || negotiated["spdy/3.1"] != 2 {
+		t.Errorf("negotiated %v, want 2 spdy/3.1 sessions", negotiated)
+	}
+	rows := pool.Sessions.Rows()
+	if len(rows) != 2 || rows[0].Count+rows[1].Count != 80 {
+		t.Errorf("sessions = %+v, want 80 requests over 2", rows)
+	}
+	for _, row := range rows {
+		if row.Count == 0 || row.Errors != 0 {
+			t.Errorf("%s: %+v", row.Key, row)
+		}
+	}
+	if active := pool.Active.Rows(0); len(active) == 0 || active[0].Peak > 6 || active[0].Peak < 4 {
+		t.Errorf("active streams = %+v", active)
+	}
+}
+
+func TestSPDYPoolRejectsHTTP1(t *testing.T) {
+	_, srv := startSPDYServer(t, nil)
+	pool := NewSPDYPool(SPDYConfig{TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+	_, err := (&http.Client{Transport: pool}).Get(srv.URL)
+	if err == nil || !strings.Contains(err.Error(), `negotiated "http/1.1"`) {
+		t.Errorf("err = %v", err)
+	}
+	if rows := pool.Sessions.Rows(); len(rows) != 1 || rows[0].Errors != 1 {
+		t.Errorf("sessions = %+v", rows)
+	}
+}
+
+//listenSPDYPeer accepts the tls connections which negotiate spdy/3.1 and
+//hands each to serve,the listener is closed when the test ends.
+func listenSPDYPeer(t *testing.T, serve func(conn net.Conn)) net.Listener {
+	cert, err := SelfSignedCertificate("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"spdy/3.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return l
+}
+
+//silentSPDYPeer negotiates spdy/3.1 but never answers,it counts the
+//RST_STREAM CANCEL frames it receives.
+type silentSPDYPeer struct {
+	net.Listener
+	mu      sync.Mutex
+	cancels int
+}
+
+func (p *silentSPDYPeer) serve(conn net.Conn) {
+	var b []byte
+	buf := make([]byte, 4096)
+	counted := 0
+	for {
+		n, err := conn.Read(buf)
+		b = append(b, buf[:n]...)
+		found := countSPDYCancels(b)
+		p.mu.Lock()
+		p.cancels += found - counted
+		p.mu.Unlock()
+		counted = found
+		if err != nil {
+			return
+		}
+	}
+}
+
+//countSPDYCancels counts the version 3 RST_STREAM frames with status 5,CANCEL,in b.
+func countSPDYCancels(b []byte) int {
+	rst := []byte{0x80, 3, 0, 3, 0, 0, 0, 8}
+	n := 0
+	for i := bytes.Index(b, rst); i >= 0 && i+16 <= len(b); {
+		if binary.BigEndian.Uint32(b[i+12:]) == 5 {
+			n++
+		}
+		j := bytes.Index(b[i+1:], rst)
+		if j < 0 {
+			break
+		}
+		i += j + 1
+	}
+	return n
+}
+
+func TestSPDYTransportTimeouts(t *testing.T) {
+	peer := &silentSPDYPeer{}
+	peer.Listener = listenSPDYPeer(t, peer.serve)
+	tests := []struct {
+		header, idle, timeout time.Duration
+		err                   string
+	}{
+		{header: 100 * time.Millisecond, err: "timeout awaiting response headers"},
+		{idle: 100 * time.Millisecond, err: "timeout stream idle"},
+		{timeout: 150 * time.Millisecond, err: "timeout request total"},
+	}
+	for _, tt := range tests {
+		tr := &spdy.Transport{
+			TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+			ResponseHeaderTimeout: tt.header,
+			IdleTimeout:           tt.idle,
+			Timeout:               tt.timeout,
+		}
+		_, err := tr.RoundTrip(httptest.NewRequest("GET", "https://"+peer.Addr().String()+"/", nil))
+		var netErr net.Error
+		if err == nil || !strings.Contains(err.Error(), tt.err) || !errors.As(err, &netErr) || ClassifyError(err) != "timeout" {
+			t.Errorf("err = %v, want %q", err, tt.err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		peer.mu.Lock()
+		cancels := peer.cancels
+		peer.mu.Unlock()
+		if cancels == len(tests) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the peer got %d RST_STREAM CANCEL,want %d", cancels, len(tests))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	//a stream which keeps within the timeouts completes.
+	srv, _ := startSPDYServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 8; i++ {
+			w.Write([]byte("x"))
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	tr := &spdy.Transport{
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+		ResponseHeaderTimeout: 200 * time.Millisecond,
+		IdleTimeout:           200 * time.Millisecond,
+		Timeout:               5 * time.Second,
+	}
+	resp, err := tr.RoundTrip(httptest.NewRequest("GET", srv.URL+"/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(resp.Body); len(b) != 8 {
+		t.Errorf("body %q", b)
+	}
+}
+
+//slowWriteConn takes delay over every write of more than 4k,which leaves the
+//small frames and the tls handshake alone.
+type slowWriteConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c *slowWriteConn) Write(b []byte) (int, error) {
+	if len(b) > 4<<10 {
+		time.Sleep(c.delay)
+	}
+	return c.Conn.Write(b)
+}
+
+func TestSPDYTransportHeaderTimeoutAfterBody(t *testing.T) {
+	srv, _ := startSPDYServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprint(w, len(b))
+	}))
+	//every frame of the body takes longer than the header timeout to write,
+	//the timeout only starts once the last one is sent.
+	tr := &spdy.Transport{
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+		ResponseHeaderTimeout: 100 * time.Millisecond,
+		Dial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &slowWriteConn{Conn: conn, delay: 150 * time.Millisecond}, nil
+		},
+	}
+	body := strings.Repeat("x", 48<<10)
+	start := time.Now()
+	resp, err := tr.RoundTrip(httptest.NewRequest("POST", srv.URL+"/", strings.NewReader(body)))
+	if err != nil {
+		t.Fatalf("err = %v after %v", err, time.Since(start))
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != fmt.Sprint(len(body)) {
+		t.Errorf("the server read %s bytes,want %d", b, len(body))
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("the body was sent in %v,too fast to test the timeout", d)
+	}
+}
+
+func TestSPDYTransportHandshakeTimeout(t *testing.T) {
+	//a server which accepts but never answers the tls handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	tr := &spdy.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err = tr.RoundTrip(httptest.NewRequest("GET", "https://"+l.Addr().String()+"/", nil))
+	if ClassifyError(err) != "timeout" || time.Since(start) > 2*time.Second {
+		t.Errorf("err = %v after %v", err, time.Since(start))
+	}
+}
+
+//goawaySPDYPeer answers every stream of its later sessions.On the first
+//session it holds the streams until it has hold of them,then it sends GOAWAY
+//with the first one as the last good stream and answers only that one.
+type goawaySPDYPeer struct {
+	net.Listener
+	hold     int
+	first    chan struct{} //closed on the first stream
+	mu       sync.Mutex
+	sessions int
+}
+
+func (p *goawaySPDYPeer) serve(conn net.Conn) {
+	p.mu.Lock()
+	p.sessions++
+	first := p.sessions == 1
+	p.mu.Unlock()
+	r := bufio.NewReader(conn)
+	comp := common.NewCompressor(3)
+	defer comp.Close()
+	reply := func(id common.StreamID) error {
+		syn := &frames.SYN_REPLY{Flags: common.FLAG_FIN, StreamID: id, Header: http.Header{":status": {"200"}, ":version": {"HTTP/1.1"}}}
+		if err := syn.Compress(comp); err != nil {
+			return err
+		}
+		_, err := syn.WriteTo(conn)
+		return err
+	}
+	var held []common.StreamID
+	for {
+		frame, err := frames.ReadFrame(r, 1)
+		if err != nil {
+			return
+		}
+		syn, ok := frame.(*frames.SYN_STREAMV3_1)
+		if !ok {
+			continue
+		}
+		if !first {
+			if reply(syn.StreamID) != nil {
+				return
+			}
+			continue
+		}
+		if held = append(held, syn.StreamID); len(held) == 1 {
+			close(p.first)
+		}
+		if len(held) == p.hold {
+			goaway := &frames.GOAWAY{LastGoodStreamID: held[0]}
+			if _, err := goaway.WriteTo(conn); err != nil || reply(held[0]) != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestSPDYTransportGoaway(t *testing.T) {
+	peer := &goawaySPDYPeer{hold: 4, first: make(chan struct{})}
+	peer.Listener = listenSPDYPeer(t, peer.serve)
+	url := "https://" + peer.Addr().String() + "/"
+	tr := &spdy.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	roundTrip := func(method string) error {
+		var body io.Reader
+		if method == "POST" {
+			body = strings.NewReader("x")
+		}
+		req, err := http.NewRequest(method, url, body)
+		if err != nil {
+			return err
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return nil
+	}
+
+	//the first stream is the last good one,the three after it are refused.
+	methods := []string{"GET", "GET", "HEAD", "POST"}
+	errs := make([]error, len(methods))
+	var wg sync.WaitGroup
+	for i, method := range methods {
+		wg.Add(1)
+		go func(i int, method string) {
+			defer wg.Done()
+			errs[i] = roundTrip(method)
+		}(i, method)
+		if i == 0 {
+			select {
+			case <-peer.first:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the first stream never came")
+			}
+		}
+	}
+	wg.Wait()
+	for i, err := range errs[:3] {
+		if err != nil {
+			t.Errorf("%s %d: %v", methods[i], i, err)
+		}
+	}
+	//a refused POST isn't sent again,it may have side effects.
+	if errs[3] != common.ErrRefused {
+		t.Errorf("POST: err = %v, want %v", errs[3], common.ErrRefused)
+	}
+	if goaways, retries := tr.Goaways(); goaways != 1 || retries != 2 {
+		t.Errorf("%d goaways,%d retries, want 1,2", goaways, retries)
+	}
+
+	//the new session serves the later requests.
+	if err := roundTrip("GET"); err != nil {
+		t.Error(err)
+	}
+	peer.mu.Lock()
+	if peer.sessions != 2 {
+		t.Errorf("%d sessions, want 2", peer.sessions)
+	}
+	peer.mu.Unlock()
+}
+
+func TestSPDYTransportSessions(t *testing.T) {
+	var mu sync.Mutex
+	active := make(map[string]int) //open streams by session,told apart by the client address
+	peak := make(map[string]int)
+	srv, _ := startSPDYServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active[r.RemoteAddr]++
+		if active[r.RemoteAddr] > peak[r.RemoteAddr] {
+			peak[r.RemoteAddr] = active[r.RemoteAddr]
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active[r.RemoteAddr]--
+		mu.Unlock()
+		w.Write([]byte(r.RemoteAddr))
+	}))
+
+	for _, tt := range []struct {
+		name     string
+		balance  spdy.Balance
+		sessions int //used by three requests in a row
+	}{
+		{name: "least streams", balance: spdy.LeastStreams, sessions: 1},
+		{name: "round robin", balance: spdy.RoundRobin, sessions: 3},
+	} {
+		mu.Lock()
+		peak = make(map[string]int)
+		mu.Unlock()
+		tr := &spdy.Transport{
+			TLSClientConfig:      &tls.Config{InsecureSkipVerify: true},
+			SessionsPerHost:      3,
+			MaxStreamsPerSession: 2,
+			Balance:              tt.balance,
+		}
+		get := func() (string, error) {
+			resp, err := tr.RoundTrip(httptest.NewRequest("GET", srv.URL+"/", nil))
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			b, err := ioutil.ReadAll(resp.Body)
+			return string(b), err
+		}
+
+		//more requests than the sessions take,the extra ones wait for a stream.
+		var wg sync.WaitGroup
+		for i := 0; i < 12; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := get(); err != nil {
+					t.Errorf("%s: %v", tt.name, err)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := tr.Sessions(srv.Listener.Addr().String()); n != 3 {
+			t.Errorf("%s: %d sessions, want 3", tt.name, n)
+		}
+		mu.Lock()
+		if len(peak) != 3 {
+			t.Errorf("%s: the server saw %d sessions, want 3", tt.name, len(peak))
+		}
+		for addr, n := range peak {
+			if n > 2 {
+				t.Errorf("%s: %s had %d concurrent streams, want at most 2", tt.name, addr, n)
+			}
+		}
+		mu.Unlock()
+
+		//one request at a time leaves all sessions idle.
+		seen := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			addr, err := get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen[addr] = true
+		}
+		if len(seen) != tt.sessions {
+			t.Errorf("%s: three requests in a row used %d sessions, want %d", tt.name, len(seen), tt.sessions)
+		}
+	}
+}
+
+func TestPingStats(t *testing.T) {
+	s := &PingStats{}
+	if got := s.String(); got != "no reply,0 failed" {
+		t.Errorf("empty = %q", got)
+	}
+	s.Observe(2*time.Millisecond, nil)
+	s.Observe(4*time.Millisecond, nil)
+	s.Observe(0, common.ErrPingTimeout)
+	want := "min 2.00ms,mean 3.00ms,max 4.00ms over 2 pings,1 failed"
+	if got := s.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSPDYTransportPing(t *testing.T) {
+	srv, _ := startSPDYServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	pings := make(chan error, 16)
+	tr := &spdy.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		PingInterval:    20 * time.Millisecond,
+		PingTimeout:     time.Second,
+		Pinged: func(rtt time.Duration, err error) {
+			if err == nil && rtt <= 0 {
+				err = fmt.Errorf("rtt %v", rtt)
+			}
+			select {
+			case pings <- err:
+			default:
+			}
+		},
+	}
+	resp, err := tr.RoundTrip(httptest.NewRequest("GET", srv.URL+"/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-pings:
+			if err != nil {
+				t.Errorf("keepalive: %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no keepalive ping")
+		}
+	}
+	ping, err := spdy.PingServer(http.Client{Transport: tr}, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok := <-ping; !ok {
+		t.Error("PingServer got no reply")
+	}
+
+	//the silent peer never answers,its session is closed as dead.
+	peer := &silentSPDYPeer{}
+	peer.Listener = listenSPDYPeer(t, peer.serve)
+	dead := make(chan error, 1)
+	tr = &spdy.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		Timeout:         5 * time.Second,
+		PingInterval:    50 * time.Millisecond,
+		Pinged: func(rtt time.Duration, err error) {
+			dead <- err
+		},
+	}
+	start := time.Now()
+	_, err = tr.RoundTrip(httptest.NewRequest("GET", "https://"+peer.Addr().String()+"/", nil))
+	if err == nil || time.Since(start) > 2*time.Second {
+		t.Errorf("err = %v after %v", err, time.Since(start))
+	}
+	if err := <-dead; err != common.ErrPingTimeout {
+		t.Errorf("ping err = %v, want %v", err, common.ErrPingTimeout)
+	}
+	if n := tr.Sessions(peer.Addr().String()); n != 0 {
+		t.Errorf("%d sessions left open", n)
+	}
+}
+
+func TestSPDYStatsDetails(t *testing.T) {
+	a := common.ConnStats{
+		FramesSent:                map[string]int{"SYN_STREAM": 2, "DATA": 1},
+		HeaderBytesSent:           200,
+		RSTStreamSent:             map[common.StatusCode]int{common.RST_STREAM_CANCEL: 1},
+		PeakStreams:               3,
+		CompressedHeaderBytesSent: 50,
+	}
+	b := common.ConnStats{
+		FramesSent:                map[string]int{"SYN_STREAM": 1},
+		DataBytesSent:             10,
+		FlowControlStall:          1500 * time.Millisecond,
+		PeakStreams:               2,
+		HeaderBytesSent:           200,
+		CompressedHeaderBytesSent: 50,
+	}
+	a.Add(b)
+	got := make(map[string]string)
+	for _, item := range SPDYStatsDetails(a) {
+		got[item.Name] = item.Value
+	}
+	want := map[string]string{
+		"SPDY Frames Sent":             "DATA=1,SYN_STREAM=3",
+		"SPDY Frames Received":         "none",
+		"SPDY Header Bytes":            "400 sent,compressed to 100 (25.0%),0 received,compressed from 0 (0.0%)",
+		"SPDY DATA Bytes":              "10 sent,0 received",
+		"SPDY Flow Control Stall":      "1.5s",
+		"SPDY RST_STREAM Sent":         "CANCEL=1",
+		"SPDY RST_STREAM Received":     "none",
+		"SPDY Peak Concurrent Streams": "3",
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s = %q, want %q", name, got[name], value)
+		}
+	}
+}
+
+func TestSPDYTransportStats(t *testing.T) {
+	body := strings.Repeat("x", 4096)
+	srv, _ := startSPDYServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(body))
+	}))
+	pinged := make(chan struct{}, 1)
+	tr := &spdy.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		PingInterval:    20 * time.Millisecond,
+		PingTimeout:     time.Second,
+		Pinged: func(rtt time.Duration, err error) {
+			select {
+			case pinged <- struct{}{}:
+			default:
+			}
+		},
+	}
+	//the first request opens the session,the others share it.
+	get := func(method string, payload io.Reader) {
+		resp, err := tr.RoundTrip(httptest.NewRequest(method, srv.URL+"/", payload))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	}
+	get("POST", strings.NewReader("hello"))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get("GET", nil)
+		}()
+	}
+	wg.Wait()
+	select {
+	case <-pinged:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no keepalive ping")
+	}
+	stats := tr.Stats()
+	if n := stats.FramesSent["SYN_STREAM"]; n != 5 {
+		t.Errorf("SYN_STREAM sent = %d, want 5", n)
+	}
+	if n := stats.FramesReceived["SYN_REPLY"]; n != 5 {
+		t.Errorf("SYN_REPLY received = %d, want 5", n)
+	}
+	if stats.DataBytesSent != 5 || stats.DataBytesReceived != 5*int64(len(body)) {
+		t.Errorf("DATA bytes %d sent,%d received", stats.DataBytesSent, stats.DataBytesReceived)
+	}
+	if stats.HeaderBytesSent == 0 || stats.CompressedHeaderBytesSent >= stats.HeaderBytesSent {
+		t.Errorf("headers sent %d,compressed to %d", stats.HeaderBytesSent, stats.CompressedHeaderBytesSent)
+	}
+	if stats.HeaderBytesReceived == 0 || stats.CompressedHeaderBytesReceived == 0 {
+		t.Errorf("headers received %d,compressed from %d", stats.HeaderBytesReceived, stats.CompressedHeaderBytesReceived)
+	}
+	if stats.PeakStreams < 2 || stats.PeakStreams > 4 {
+		t.Errorf("peak streams = %d", stats.PeakStreams)
+	}
+	if stats.Pings == 0 || stats.MeanPingRTT() <= 0 || stats.FramesSent["PING"] < stats.Pings {
+		t.Errorf("%d pings,mean %v,%d PING frames", stats.Pings, stats.MeanPingRTT(), stats.FramesSent["PING"])
+	}
+}
+
+func TestSPDYFrameTrace(t *testing.T) {
+	srv, _ := startSPDYServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("traced"))
+	}))
+	var out bytes.Buffer
+	trace := common.NewFrameWriter(&out)
+	var session string
+	tr := &spdy.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		FrameObserver: func(conn net.Conn) common.FrameObserver {
+			session = conn.LocalAddr().String()
+			return trace.Session(session)
+		},
+	}
+	resp, err := tr.RoundTrip(httptest.NewRequest("GET", srv.URL+"/traced", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := trace.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	var reply []byte
+	dec := json.NewDecoder(&out)
+	for {
+		var r common.FrameRecord
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if r.Session != session || r.Time.IsZero() {
+			t.Errorf("record %+v of session %q", r, session)
+		}
+		got = append(got, r.Dir.String()+" "+r.Type)
+		switch r.Type {
+		case "SYN_STREAM":
+			var syn struct{ Header http.Header }
+			if err := json.Unmarshal(r.Frame, &syn); err != nil || syn.Header.Get(":path") != "/traced" {
+				t.Errorf("SYN_STREAM %s: %v", r.Frame, err)
+			}
+		case "DATA":
+			var data struct{ Data []byte }
+			json.Unmarshal(r.Frame, &data)
+			reply = append(reply, data.Data...)
+		}
+	}
+	trail := strings.Join(got, ",")
+	for _, want := range []string{"out SETTINGS", "out SYN_STREAM", "in SYN_REPLY", "in DATA"} {
+		if !strings.Contains(trail, want) {
+			t.Errorf("trace %s lacks %s", trail, want)
+		}
+	}
+	if string(reply) != "traced" {
+		t.Errorf("DATA = %q", reply)
+	}
+}
+
+func TestSPDYTransportFlowControl(t *testing.T) {
+	var body bytes.Buffer
+	for i := 0; body.Len() < 64<<10; i++ {
+		fmt.Fprintf(&body, "%08d", i)
+	}
+	srv, _ := startSPDYServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//several writes,the windows hold back more than one of them.
+		for b := body.Bytes(); len(b) > 0; b = b[min(len(b), 3000):] {
+			w.Write(b[:min(len(b), 3000)])
+		}
+	}))
+	tests := []struct {
+		spec    string
+		updates int //the fewest WINDOW_UPDATEs sent
+	}{
+		{spec: "default", updates: 0},
+		{spec: "starved:1k", updates: 64},
+		{spec: "fixed:4k", updates: 16},
+		{spec: "auto:2k-1m", updates: 32},
+	}
+	for _, tt := range tests {
+		flow, err := ParseFlowControl(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var settings []string
+		tr := &spdy.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			FlowControl:     flow,
+			Timeout:         5 * time.Second,
+			FrameObserver: func(net.Conn) common.FrameObserver {
+				return frameFunc(func(dir common.Direction, frame common.Frame) {
+					if s, ok := frame.(*frames.SETTINGS); ok && dir == common.Outbound {
+						if w := s.Settings[common.SETTINGS_INITIAL_WINDOW_SIZE]; w != nil {
+							settings = append(settings, fmt.Sprint(w.Value))
+						}
+					}
+				})
+			},
+		}
+		for i := 0; i < 2; i++ {
+			resp, err := tr.RoundTrip(httptest.NewRequest("GET", srv.URL+"/", nil))
+			if err != nil {
+				t.Fatalf("%s: %v", tt.spec, err)
+			}
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if !bytes.Equal(b, body.Bytes()) {
+				t.Errorf("%s: body of %d bytes differs", tt.spec, len(b))
+			}
+		}
+		stats := tr.Stats()
+		if stats.WindowUpdatesSent < 2*tt.updates {
+			t.Errorf("%s: %d window updates sent,want at least %d", tt.spec, stats.WindowUpdatesSent, 2*tt.updates)
+		}
+		//both requests share a session,the server must not have closed it.
+		if n := tr.Sessions(strings.TrimPrefix(srv.URL, "https://")); n != 1 {
+			t.Errorf("%s: %d sessions", tt.spec, n)
+		}
+		want := fmt.Sprint(common.DEFAULT_INITIAL_CLIENT_WINDOW_SIZE)
+		if tt.spec != "default" {
+			want = fmt.Sprint(flow().InitialWindowSize())
+		}
+		if strings.Join(settings, ",") != want {
+			t.Errorf("%s: advertised windows %v,want %s", tt.spec, settings, want)
+		}
+	}
+}
+
+func TestSPDYTransportSettingsWindow(t *testing.T) {
+	var body bytes.Buffer
+	for i := 0; body.Len() < 256<<10; i++ {
+		fmt.Fprintf(&body, "%08d", i)
+	}
+	srv, _ := startSPDYServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for b := body.Bytes(); len(b) > 0; b = b[min(len(b), 3000):] {
+			w.Write(b[:min(len(b), 3000)])
+		}
+	}))
+	//the SETTINGS window is below or above that of the flow control,the session
+	//must grow the windows it advertised,not those of the flow control.
+	tests := []struct {
+		spec   string
+		window uint32
+	}{
+		{spec: "default", window: 4 << 10},
+		{spec: "fixed:4k", window: 16 << 10},
+		{spec: "starved:1k", window: 1 << 20},
+		{spec: "default", window: 1 << 20},
+	}
+	for _, tt := range tests {
+		flow, err := ParseFlowControl(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := &spdy.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			FlowControl:     flow,
+			Settings: common.Settings{
+				common.SETTINGS_INITIAL_WINDOW_SIZE: {ID: common.SETTINGS_INITIAL_WINDOW_SIZE, Value: tt.window},
+			},
+			Timeout: 5 * time.Second,
+		}
+		//the streams share the session window.
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := tr.RoundTrip(httptest.NewRequest("GET", srv.URL+"/", nil))
+				if err != nil {
+					t.Errorf("%s,window %d: %v", tt.spec, tt.window, err)
+					return
+				}
+				b, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if !bytes.Equal(b, body.Bytes()) {
+					t.Errorf("%s,window %d: body of %d bytes differs", tt.spec, tt.window, len(b))
+				}
+			}()
+		}
+		wg.Wait()
+		if n := tr.Sessions(strings.TrimPrefix(srv.URL, "https://")); n != 1 {
+			t.Errorf("%s,window %d: %d sessions", tt.spec, tt.window, n)
+		}
+		if stats := tr.Stats(); tt.window < 256<<10 && stats.WindowUpdatesSent == 0 {
+			t.Errorf("%s,window %d: no window updates sent", tt.spec, tt.window)
+		}
+	}
+}
+
+//frameFunc adapts a func to common.FrameObserver.
+type frameFunc func(dir common.Direction, frame common.Frame)
+
+func (f frameFunc) ObserveFrame(_ time.Time, dir common.Direction, frame common.Frame) {
+	f(dir, frame)
+}
+
+func TestParseBalance(t *testing.T) {
+	for name, want := range map[string]spdy.Balance{"least-streams": spdy.LeastStreams, "round-robin": spdy.RoundRobin} {
+		if b, err := ParseBalance(name); err != nil || b != want {
+			t.Errorf("%s: %v,%v", name, b, err)
+		}
+	}
+	if _, err := ParseBalance("random"); err == nil {
+		t.Error("random: no error")
+	}
+}
+
+func TestParseFlowControl(t *testing.T) {
+	tests := []struct {
+		spec   string
+		window uint32 //0 for the default flow control
+		err    bool
+	}{
+		{spec: "default"},
+		{spec: "fixed:1m", window: 1 << 20},
+		{spec: "starved:512", window: 512},
+		{spec: "auto", window: common.DEFAULT_INITIAL_WINDOW_SIZE},
+		{spec: "auto:8k-64k", window: 8 << 10},
+		{spec: "auto:64k-8k", err: true},
+		{spec: "fixed", err: true},
+		{spec: "starved:0", err: true},
+		{spec: "fixed:2g", err: true},
+		{spec: "default:1k", err: true},
+		{spec: "bogus", err: true},
+	}
+	for _, tt := range tests {
+		flow, err := ParseFlowControl(tt.spec)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v", tt.spec, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var window uint32
+		if flow != nil {
+			window = flow().InitialWindowSize()
+		}
+		if window != tt.window {
+			t.Errorf("%s: window %d,want %d", tt.spec, window, tt.window)
+		}
+	}
+}
+
+func TestFlowControlPolicies(t *testing.T) {
+	//the window regrown by each policy after the data left newWindow of a 1000 byte window.
+	tests := []struct {
+		flow      common.FlowControl
+		newWindow int64
+		delta     uint32
+	}{
+		{spdy3.FixedFlowControl(1000), 999, 1},
+		{spdy3.FixedFlowControl(1000), 1000, 0},
+		{spdy3.StarvedFlowControl(1000), 1, 0},
+		{spdy3.StarvedFlowControl(1000), 0, 1000},
+		{spdy3.DefaultFlowControl(1000), 600, 0},
+		{spdy3.DefaultFlowControl(1000), 400, 600},
+	}
+	for _, tt := range tests {
+		if delta := tt.flow.ReceiveData(1, 1000, tt.newWindow); delta != tt.delta {
+			t.Errorf("%T at %d: delta %d,want %d", tt.flow, tt.newWindow, delta, tt.delta)
+		}
+	}
+
+	auto := spdy3.NewAutoFlowControl(64<<10, 128<<10)
+	if d := auto.ReceiveData(1, 64<<10, 16<<10); d != 48<<10 {
+		t.Errorf("auto delta %d before tuning", d)
+	}
+	auto.Sample(100*time.Millisecond, 0)
+	time.Sleep(20 * time.Millisecond)
+	//a saturated window,the estimate doubles it up to the max.
+	auto.Sample(100*time.Millisecond, 1<<20)
+	if w := auto.Window(); w != 128<<10 {
+		t.Errorf("tuned window %d,want %d", w, 128<<10)
+	}
+	if d := auto.ReceiveData(1, 64<<10, 16<<10); d != 112<<10 {
+		t.Errorf("auto delta %d after tuning", d)
+	}
+	//idle,back to the initial window.
+	auto.Sample(100*time.Millisecond, 1<<20)
+	if w := auto.Window(); w != 64<<10 {
+		t.Errorf("idle window %d,want %d", w, 64<<10)
+	}
+}
+
+func TestParseSPDYSettings(t *testing.T) {
+	settings, err := ParseSPDYSettings("max_concurrent_streams=100, ROUND_TRIP_TIME=20:persist,SETTINGS_UPLOAD_BANDWIDTH=5:persisted,9=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := common.Settings{
+		common.SETTINGS_MAX_CONCURRENT_STREAMS: {ID: common.SETTINGS_MAX_CONCURRENT_STREAMS, Value: 100},
+		common.SETTINGS_ROUND_TRIP_TIME:        {Flags: common.FLAG_SETTINGS_PERSIST_VALUE, ID: common.SETTINGS_ROUND_TRIP_TIME, Value: 20},
+		common.SETTINGS_UPLOAD_BANDWIDTH:       {Flags: common.FLAG_SETTINGS_PERSISTED, ID: common.SETTINGS_UPLOAD_BANDWIDTH, Value: 5},
+		9:                                      {ID: 9, Value: 1},
+	}
+	if len(settings) != len(want) {
+		t.Fatalf("settings %v,want %v", settings, want)
+	}
+	for id, w := range want {
+		if s := settings[id]; s == nil || *s != *w {
+			t.Errorf("setting %d = %v,want %v", id, s, w)
+		}
+	}
+	for _, spec := range []string{"BOGUS=1", "ROUND_TRIP_TIME", "ROUND_TRIP_TIME=x", "ROUND_TRIP_TIME=1:keep", "ROUND_TRIP_TIME=-1"} {
+		if _, err := ParseSPDYSettings(spec); err == nil {
+			t.Errorf("%s: no error", spec)
+		}
+	}
+}
+
+//settingsSPDYPeer sends its SETTINGS for each session in turn and passes on
+//the client's SETTINGS.It answers every stream,sending GOAWAY and a PING
+//after the first one if goaway is set for the session.
+type settingsSPDYPeer struct {
+	net.Listener
+	settings []*frames.SETTINGS
+	goaway   []bool
+	received chan common.Settings
+	sessions int32
+}
+
+func (p *settingsSPDYPeer) serve(conn net.Conn) {
+	i := int(atomic.AddInt32(&p.sessions, 1)) - 1
+	if i >= len(p.settings) {
+		return
+	}
+	if _, err := p.settings[i].WriteTo(conn); err != nil {
+		return
+	}
+	r := bufio.NewReader(conn)
+	comp := common.NewCompressor(3)
+	defer comp.Close()
+	for {
+		frame, err := frames.ReadFrame(r, 1)
+		if err != nil {
+			return
+		}
+		switch frame := frame.(type) {
+		case *frames.SETTINGS:
+			p.received <- frame.Settings
+		case *frames.SYN_STREAMV3_1:
+			syn := &frames.SYN_REPLY{Flags: common.FLAG_FIN, StreamID: frame.StreamID, Header: http.Header{":status": {"200"}, ":version": {"HTTP/1.1"}}}
+			if syn.Compress(comp) != nil {
+				return
+			}
+			if _, err := syn.WriteTo(conn); err != nil {
+				return
+			}
+			if p.goaway[i] {
+				goaway := &frames.GOAWAY{LastGoodStreamID: frame.StreamID}
+				if _, err := goaway.WriteTo(conn); err != nil {
+					return
+				}
+				if _, err := (&frames.PING{PingID: 2}).WriteTo(conn); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func TestSPDYPoolNoStreams(t *testing.T) {
+	none := &frames.SETTINGS{Settings: make(common.Settings)}
+	none.Add(0, common.SETTINGS_MAX_CONCURRENT_STREAMS, 0)
+	peer := &settingsSPDYPeer{settings: []*frames.SETTINGS{none}, goaway: []bool{false}, received: make(chan common.Settings, 1)}
+	peer.Listener = listenSPDYPeer(t, peer.serve)
+	var syns int32
+	pool := NewSPDYPool(SPDYConfig{
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		FrameObserver: func(net.Conn) common.FrameObserver {
+			return frameFunc(func(dir common.Direction, frame common.Frame) {
+				if dir == common.Outbound && frame.Name() == "SYN_STREAM" {
+					atomic.AddInt32(&syns, 1)
+				}
+			})
+		},
+	})
+	client := &http.Client{Transport: pool}
+	//the first request may go out before the SETTINGS came,the later ones
+	//fail without a stream.
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get("https://" + peer.Addr().String() + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		if i > 0 && !errors.Is(err, common.ErrNoStreams) {
+			t.Errorf("request %d: err = %v, want %v", i, err, common.ErrNoStreams)
+		}
+	}
+	if n := atomic.LoadInt32(&syns); n > 1 {
+		t.Errorf("%d SYN_STREAMs sent to a server allowing none", n)
+	}
+	for _, row := range pool.Sessions.Rows() {
+		if row.Key == spdyNoSession && row.Errors != 3 {
+			t.Errorf("sessions = %+v", row)
+		}
+	}
+}
+
+func TestSPDYTransportSettings(t *testing.T) {
+	persist := &frames.SETTINGS{Settings: make(common.Settings)}
+	persist.Add(common.FLAG_SETTINGS_PERSIST_VALUE, common.SETTINGS_ROUND_TRIP_TIME, 33)
+	persist.Add(common.FLAG_SETTINGS_PERSIST_VALUE, common.SETTINGS_UPLOAD_BANDWIDTH, 5)
+	persist.Add(0, common.SETTINGS_DOWNLOAD_BANDWIDTH, 7)
+	clear := &frames.SETTINGS{Flags: common.FLAG_SETTINGS_CLEAR_SETTINGS, Settings: make(common.Settings)}
+	peer := &settingsSPDYPeer{settings: []*frames.SETTINGS{persist, clear}, goaway: []bool{true, false}, received: make(chan common.Settings, 2)}
+	peer.Listener = listenSPDYPeer(t, peer.serve)
+	host := peer.Addr().String()
+
+	tr := spdy.NewTransport(true)
+	tr.Settings = common.Settings{
+		common.SETTINGS_UPLOAD_BANDWIDTH: {ID: common.SETTINGS_UPLOAD_BANDWIDTH, Value: 9},
+		common.SETTINGS_CURRENT_CWND:     {Flags: common.FLAG_SETTINGS_PERSIST_VALUE, ID: common.SETTINGS_CURRENT_CWND, Value: 12},
+	}
+	//the PING after GOAWAY is seen once the GOAWAY has been processed.
+	goaway := make(chan struct{}, 1)
+	tr.FrameObserver = func(net.Conn) common.FrameObserver {
+		return frameFunc(func(dir common.Direction, frame common.Frame) {
+			if _, ok := frame.(*frames.PING); ok && dir == common.Inbound {
+				select {
+				case goaway <- struct{}{}:
+				default:
+				}
+			}
+		})
+	}
+	get := func() {
+		resp, err := tr.RoundTrip(httptest.NewRequest("GET", "https://"+host+"/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	sent := func() common.Settings {
+		select {
+		case s := <-peer.received:
+			return s
+		case <-time.After(2 * time.Second):
+			t.Fatal("no SETTINGS from the client")
+		}
+		return nil
+	}
+	check := func(s common.Settings, id uint32, flags common.Flags, value uint32) {
+		if got := s[id]; got == nil || got.Flags != flags || got.Value != value {
+			t.Errorf("setting %d = %v,want %d flagged %d", id, got, value, flags)
+		}
+	}
+
+	get()
+	first := sent()
+	check(first, common.SETTINGS_UPLOAD_BANDWIDTH, 0, 9)
+	check(first, common.SETTINGS_CURRENT_CWND, common.FLAG_SETTINGS_PERSIST_VALUE, 12)
+	check(first, common.SETTINGS_INITIAL_WINDOW_SIZE, 0, common.DEFAULT_INITIAL_CLIENT_WINDOW_SIZE)
+	if first[common.SETTINGS_ROUND_TRIP_TIME] != nil {
+		t.Errorf("persisted SETTINGS sent before the server asked for them")
+	}
+	persisted := tr.PersistedSettings(host)
+	check(persisted, common.SETTINGS_ROUND_TRIP_TIME, common.FLAG_SETTINGS_PERSISTED, 33)
+	check(persisted, common.SETTINGS_UPLOAD_BANDWIDTH, common.FLAG_SETTINGS_PERSISTED, 5)
+	if len(persisted) != 2 {
+		t.Errorf("persisted %v", persisted)
+	}
+
+	//the peer sent GOAWAY on the first session,the next one replays the persisted SETTINGS.
+	select {
+	case <-goaway:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no GOAWAY on the first session")
+	}
+	get()
+	second := sent()
+	check(second, common.SETTINGS_ROUND_TRIP_TIME, common.FLAG_SETTINGS_PERSISTED, 33)
+	check(second, common.SETTINGS_UPLOAD_BANDWIDTH, 0, 9)
+	if second[common.SETTINGS_DOWNLOAD_BANDWIDTH] != nil {
+		t.Errorf("SETTINGS not asked to persist were sent back")
+	}
+	if persisted := tr.PersistedSettings(host); len(persisted) != 0 {
+		t.Errorf("persisted %v after CLEAR_SETTINGS", persisted)
+	}
+}
@@ -0,0 +1,427 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"crypto/tls"
+	"errors"
+	"math/bits"
+	"net"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//Sample is the measurement of one finished request.
+type Sample struct {
+	Start      time.Time
+	Latency    time.Duration
+	StatusCode int //0 if the request failed before a response was read
+	Err        error
+	Connect    time.Duration //0 if the connection was reused
+	TLS        time.Duration //0 if there was no handshake
+	TTFB       time.Duration //from the request start to the first response byte
+}
+
+//Bucket aggregates the samples which started within the same second of the run.
+type Bucket struct {
+	Second     int
+	Requests   int
+	Errors     int
+	SumLatency time.Duration
+	MaxLatency time.Duration
+}
+
+//AvgLatency returns the mean latency of the bucket.
+func (b Bucket) AvgLatency() time.Duration {
+	if b.Requests == 0 {
+		return 0
+	}
+	return b.SumLatency / time.Duration(b.Requests)
+}
+
+//Statistics collects the samples of a run.Each worker records into its own
+//Recorder,so the load generator never waits on a shared lock;the recorders are
+//merged when the statistics are read.Memory stays fixed however long the run is.
+type Statistics struct {
+	start     time.Time
+	mu        sync.Mutex //protects recorders and timeline
+	recorders []*Recorder
+	timeline  []Bucket
+}
+
+//NewStatistics returns a Statistics which counts the seconds of the timeline from start.
+func NewStatistics(start time.Time) *Statistics {
+	return &Statistics{start: start}
+}
+
+//NewRecorder returns a Recorder for one worker.
+func (s *Statistics) NewRecorder() *Recorder {
+	r := &Recorder{
+		stats:       s,
+		statusCodes: make(map[int]int),
+		errors:      make(map[string]int),
+		current:     Bucket{Second: -1},
+	}
+	s.mu.Lock()
+	s.recorders = append(s.recorders, r)
+	s.mu.Unlock()
+	return r
+}
+
+//Recorder aggregates the samples of one worker.
+type Recorder struct {
+	stats       *Statistics
+	mu          sync.Mutex //only contended while the statistics are read
+	latencies   Histogram
+	connects    Histogram
+	handshakes  Histogram
+	ttfbs       Histogram
+	statusCodes map[int]int
+	errors      map[string]int
+	current     Bucket //the second being filled,handed to the shared timeline once it's over
+}
+
+//Record adds sample to the worker's statistics.
+func (r *Recorder) Record(sample Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies.Add(sample.Latency)
+	if sample.Connect > 0 {
+		r.connects.Add(sample.Connect)
+	}
+	if sample.TLS > 0 {
+		r.handshakes.Add(sample.TLS)
+	}
+	if sample.TTFB > 0 {
+		r.ttfbs.Add(sample.TTFB)
+	}
+	if sample.Err != nil {
+		r.errors[ClassifyError(sample.Err)]++
+	} else {
+		r.statusCodes[sample.StatusCode]++
+	}
+
+	second := int(sample.Start.Sub(r.stats.start) / time.Second)
+	if second < 0 {
+		second = 0
+	}
+	if second != r.current.Second {
+		//a worker starts a new second at most once per second,so the shared lock stays cold.
+		r.stats.addBucket(r.current)
+		r.current = Bucket{Second: second}
+	}
+	b := &r.current
+	b.Requests++
+	if sample.Err != nil || sample.StatusCode >= 400 {
+		b.Errors++
+	}
+	b.SumLatency += sample.Latency
+	if sample.Latency > b.MaxLatency {
+		b.MaxLatency = sample.Latency
+	}
+}
+
+func (s *Statistics) addBucket(b Bucket) {
+	if b.Second < 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeline = mergeBucket(s.timeline, b)
+}
+
+func mergeBucket(timeline []Bucket, b Bucket) []Bucket {
+	for len(timeline) <= b.Second {
+		timeline = append(timeline, Bucket{Second: len(timeline)})
+	}
+	t := &timeline[b.Second]
+	t.Requests += b.Requests
+	t.Errors += b.Errors
+	t.SumLatency += b.SumLatency
+	if b.MaxLatency > t.MaxLatency {
+		t.MaxLatency = b.MaxLatency
+	}
+	return timeline
+}
+
+//each calls f with every recorder locked in turn.
+func (s *Statistics) each(f func(r *Recorder)) {
+	s.mu.Lock()
+	recorders := append([]*Recorder(nil), s.recorders...)
+	s.mu.Unlock()
+	for _, r := range recorders {
+		r.mu.Lock()
+		f(r)
+		r.mu.Unlock()
+	}
+}
+
+//Count returns the number of recorded samples.
+func (s *Statistics) Count() int {
+	var n uint64
+	s.each(func(r *Recorder) { n += r.latencies.Count() })
+	return int(n)
+}
+
+//Percentiles returns the latency at each of the given percentiles (0-100),nil if nothing was recorded.
+func (s *Statistics) Percentiles(ps ...float64) []time.Duration {
+	var h Histogram
+	s.each(func(r *Recorder) { h.Merge(&r.latencies) })
+	return h.Percentiles(ps...)
+}
+
+//Phases returns the percentiles of the connect,TLS handshake and time to first byte phases.
+//A phase without any sample is nil,eg the SPDY transport doesn't trace its phases.
+func (s *Statistics) Phases(ps ...float64) (connect, handshake, ttfb []time.Duration) {
+	var c, h, t Histogram
+	s.each(func(r *Recorder) {
+		c.Merge(&r.connects)
+		h.Merge(&r.handshakes)
+		t.Merge(&r.ttfbs)
+	})
+	return c.Percentiles(ps...), h.Percentiles(ps...), t.Percentiles(ps...)
+}
+
+//StatusCodes returns the response counts by status code.
+func (s *Statistics) StatusCodes() map[int]int {
+	m := make(map[int]int)
+	s.each(func(r *Recorder) {
+		for k, v := range r.statusCodes {
+			m[k] += v
+		}
+	})
+	return m
+}
+
+//Errors returns the failure counts by error class.
+func (s *Statistics) Errors() map[string]int {
+	m := make(map[string]int)
+	s.each(func(r *Recorder) {
+		for k, v := range r.errors {
+			m[k] += v
+		}
+	})
+	return m
+}
+
+//Timeline returns the per second buckets,including the seconds the workers are still filling.
+func (s *Statistics) Timeline() []Bucket {
+	s.mu.Lock()
+	timeline := append([]Bucket(nil), s.timeline...)
+	s.mu.Unlock()
+	s.each(func(r *Recorder) {
+		if r.current.Second >= 0 {
+			timeline = mergeBucket(timeline, r.current)
+		}
+	})
+	return timeline
+}
+
+//Histogram counts durations in log-linear buckets of microseconds:exact below
+//histogramLinear,then histogramSub buckets per power of two,which keeps the
+//relative error of a percentile under 3%.The zero value is ready to use.
+type Histogram struct {
+	counts [histogramSize]uint64
+	count  uint64
+	max    time.Duration
+}
+
+const (
+	histogramSubBits = 5
+	histogramSub     = 1 << histogramSubBits
+	histogramLinear  = 2 * histogramSub
+	histogramMaxBits = 36 //about 19 hours in microseconds,larger values are clamped.
+	histogramSize    = histogramLinear + (histogramMaxBits-histogramSubBits-1)*histogramSub
+)
+
+func histogramIndex(d time.Duration) int {
+	v := uint64(d / time.Microsecond)
+	if d < 0 {
+		v = 0
+	}
+	if v >= 1<<histogramMaxBits {
+		v = 1<<histogramMaxBits - 1
+	}
+	if v < histogramLinear {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histogramSubBits - 1
+	return histogramLinear + (shift-1)*histogramSub + int(v>>uint(shift)) - histogramSub
+}
+
+//histogramValue returns the middle of the bucket at index i.
+func histogramValue(i int) time.Duration {
+	if i < histogramLinear {
+		return time.Duration(i) * time.Microsecond
+	}
+	k := i - histogramLinear
+	shift := uint(k/histogramSub + 1)
+	lower := uint64(k%histogramSub+histogramSub) << shift
+	return time.Duration(lower+(1<<shift)/2) * time.Microsecond
+}
+
+//Add counts d.
+func (h *Histogram) Add(d time.Duration) {
+	h.counts[histogramIndex(d)]++
+	h.count++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+//Merge adds the counts of o to h.
+func (h *Histogram) Merge(o *Histogram) {
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.count += o.count
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+//Count returns the number of durations counted.
+func (h *Histogram) Count() uint64 { return h.count }
+
+//Percentiles returns the nearest rank of each p (0-100),nil if h is empty.
+//The exact maximum is returned for the last rank.
+func (h *Histogram) Percentiles(ps ...float64) []time.Duration {
+	if h.count == 0 {
+		return nil
+	}
+	out := make([]time.Duration, len(ps))
+	for i, p := range ps {
+		rank := uint64(p/100*float64(h.count) + 0.5)
+		if rank < 1 {
+			rank = 1
+		}
+		if rank >= h.count {
+			out[i] = h.max
+			continue
+		}
+		var seen uint64
+		for j, c := range h.counts {
+			seen += c
+			if seen >= rank {
+				out[i] = histogramValue(j)
+				break
+			}
+		}
+		if out[i] > h.max {
+			out[i] = h.max
+		}
+	}
+	return out
+}
+
+//ClassifyError maps err to a short class name used in the error breakdown.
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrDataExhausted) {
+		return "data exhausted"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &recordErr) {
+		return "tls"
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "connection reset"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		//no free source port is left for the source address and the target.
+		return "port exhaustion"
+	case errors.Is(err, syscall.EADDRINUSE):
+		return "source port in use"
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "tls:"), strings.Contains(msg, "x509:"):
+		return "tls"
+	case strings.Contains(msg, "EOF"):
+		return "eof"
+	case strings.Contains(msg, "no such host"), strings.Contains(msg, "no suitable address"):
+		return "dns"
+	}
+	return "other"
+}
+
+//Tracer times the phases of one request through the httptrace hooks.
+type Tracer struct {
+	start        time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	Connect      time.Duration
+	TLS          time.Duration
+	TTFB         time.Duration
+	//RemoteAddr is the address of the connection the request was sent on.
+	RemoteAddr string
+}
+
+//NewTracer returns a Tracer and the ClientTrace which feeds it.
+//The request is considered started when NewTracer is called.
+func NewTracer() (*Tracer, *httptrace.ClientTrace) {
+	t := &Tracer{start: time.Now()}
+	return t, &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) { t.connectStart = time.Now() },
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				t.Connect = time.Since(t.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() { t.tlsStart = time.Now() },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				t.TLS = time.Since(t.tlsStart)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Conn != nil {
+				t.RemoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		GotFirstResponseByte: func() { t.TTFB = time.Since(t.start) },
+	}
+}
+
+//Addr returns the remote address of the request,or the one a failed dial was
+//aimed at,empty if neither is known.
+func (t *Tracer) Addr(err error) string {
+	if t.RemoteAddr != "" {
+		return t.RemoteAddr
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Addr != nil {
+		return opErr.Addr.String()
+	}
+	return ""
+}
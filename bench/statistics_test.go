@@ -0,0 +1,154 @@
+package ibench
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHistogramIndexError(t *testing.T) {
+	for _, d := range []time.Duration{
+		0,
+		time.Microsecond,
+		63 * time.Microsecond,
+		64 * time.Microsecond,
+		127 * time.Microsecond,
+		time.Millisecond,
+		1234567 * time.Microsecond,
+		time.Hour,
+	} {
+		i := histogramIndex(d)
+		if i < 0 || i >= histogramSize {
+			t.Fatalf("index of %v out of range: %d", d, i)
+		}
+		got := histogramValue(i)
+		diff := got - d
+		if diff < 0 {
+			diff = -diff
+		}
+		if d >= histogramLinear*time.Microsecond && float64(diff)/float64(d) > 0.03 {
+			t.Errorf("value of %v is %v, more than 3%% off", d, got)
+		}
+		if d < histogramLinear*time.Microsecond && got != d {
+			t.Errorf("value of %v is %v, want exact", d, got)
+		}
+	}
+	if i := histogramIndex(1000 * time.Hour); i != histogramSize-1 {
+		t.Errorf("large values must clamp to the last bucket, got %d", i)
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	var h Histogram
+	if h.Percentiles(50) != nil {
+		t.Fatal("empty histogram must return nil percentiles")
+	}
+	for i := 1; i <= 100; i++ {
+		h.Add(time.Duration(i) * time.Microsecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1 * time.Microsecond},
+		{1, 1 * time.Microsecond},
+		{50, 50 * time.Microsecond},
+		{99, 99 * time.Microsecond},
+		{100, 100 * time.Microsecond},
+	}
+	got := h.Percentiles(0, 1, 50, 99, 100)
+	for i, tt := range tests {
+		if got[i] != tt.want {
+			t.Errorf("p%g = %v, want %v", tt.p, got[i], tt.want)
+		}
+	}
+}
+
+func TestStatisticsMergesRecorders(t *testing.T) {
+	start := time.Now()
+	s := NewStatistics(start)
+	a, b := s.NewRecorder(), s.NewRecorder()
+	a.Record(Sample{Start: start, Latency: time.Millisecond, StatusCode: 200})
+	a.Record(Sample{Start: start.Add(1500 * time.Millisecond), Latency: 3 * time.Millisecond, StatusCode: 500})
+	b.Record(Sample{Start: start.Add(100 * time.Millisecond), Latency: 2 * time.Millisecond, Err: io.EOF})
+
+	if n := s.Count(); n != 3 {
+		t.Errorf("Count = %d, want 3", n)
+	}
+	if codes := s.StatusCodes(); codes[200] != 1 || codes[500] != 1 {
+		t.Errorf("StatusCodes = %v", codes)
+	}
+	if errs := s.Errors(); errs["eof"] != 1 {
+		t.Errorf("Errors = %v", errs)
+	}
+	timeline := s.Timeline()
+	if len(timeline) != 2 {
+		t.Fatalf("Timeline has %d seconds, want 2", len(timeline))
+	}
+	if timeline[0].Requests != 2 || timeline[0].Errors != 1 || timeline[0].MaxLatency != 2*time.Millisecond {
+		t.Errorf("second 0 = %+v", timeline[0])
+	}
+	if timeline[1].Requests != 1 || timeline[1].Errors != 1 {
+		t.Errorf("second 1 = %+v", timeline[1])
+	}
+	if connect, _, _ := s.Phases(50); connect != nil {
+		t.Errorf("untraced phases must be nil, got %v", connect)
+	}
+}
+
+func TestWriteHTMLUntracedPhases(t *testing.T) {
+	r := &Reporter{Stats: NewStatistics(time.Now())}
+	r.Stats.NewRecorder().Record(Sample{Start: time.Now(), Latency: time.Millisecond, StatusCode: 200})
+	var buf bytes.Buffer
+	if err := r.WriteHTML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "<th>Connect</th><td>n/a</td>") {
+		t.Error("untraced connect phase must be shown as n/a")
+	}
+	if strings.Contains(out, "0.000ms") {
+		t.Error("report must not show zero timings for untraced phases")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{&net.OpError{Op: "dial", Err: timeoutError{}}, "timeout"},
+		{os.ErrDeadlineExceeded, "timeout"},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, "connection refused"},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), "connection reset"},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EADDRNOTAVAIL)}, "port exhaustion"},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}, "source port in use"},
+		{tls.RecordHeaderError{Msg: "bad"}, "tls"},
+		{errors.New("tls: handshake failure"), "tls"},
+		{io.ErrUnexpectedEOF, "eof"},
+		{errors.New("lookup x: no such host"), "dns"},
+		{&net.DNSError{Err: "no tcp6 address", Name: "x"}, "dns"},
+		{&net.OpError{Op: "dial", Net: "tcp6", Err: &net.AddrError{Err: "no suitable address found", Addr: "x"}}, "dns"},
+		{errors.New("something else"), "other"},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("ClassifyError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
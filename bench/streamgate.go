@@ -0,0 +1,138 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"errors"
+	"sync"
+)
+
+//noLimit stands for a connection without a stream limit.
+const noLimit = -1
+
+//errNoStreams fails the requests over a connection whose server allows no streams.
+var errNoStreams = errors.New("the server allows no concurrent streams")
+
+//streamGate caps the concurrent streams of a connection of an H2Pool.Until the
+//first response came over a new connection only one stream is opened,the
+//transport would dial another connection for each of the others.The spdy
+//transport caps its streams itself,the gates of a SPDYPool only count them.
+type streamGate struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	active   int
+	peak     int
+	requests int64
+	streams  int64 //the active streams seen by all requests,for the mean
+	max      int   //the configured stream cap,0 for none
+	server   int   //the stream limit of the server,noLimit until it sets one
+	ready    bool  //whether a response came over the current connection
+}
+
+func newStreamGate(max int) *streamGate {
+	g := &streamGate{max: max, server: noLimit}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+//limit returns the streams the connection may have open,noLimit for no limit.
+func (g *streamGate) limit() int {
+	switch {
+	case g.server == 0:
+		return 0
+	case !g.ready:
+		return 1
+	case g.max > 0 && (g.server == noLimit || g.max < g.server):
+		return g.max
+	}
+	return g.server
+}
+
+//acquire waits for a free stream,it fails with errNoStreams while the server
+//allows none.
+func (g *streamGate) acquire() error {
+	g.mu.Lock()
+	for limit := g.limit(); limit != noLimit && g.active >= limit; limit = g.limit() {
+		if limit == 0 {
+			g.mu.Unlock()
+			return errNoStreams
+		}
+		g.cond.Wait()
+	}
+	g.active++
+	if g.active > g.peak {
+		g.peak = g.active
+	}
+	g.requests++
+	g.streams += int64(g.active)
+	g.mu.Unlock()
+	return nil
+}
+
+//release frees a stream.
+func (g *streamGate) release() {
+	g.mu.Lock()
+	g.active--
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+//settle marks the connection as ready once a response came over it.
+func (g *streamGate) settle() {
+	g.mu.Lock()
+	settled := !g.ready
+	g.ready = true
+	g.mu.Unlock()
+	if settled {
+		g.cond.Broadcast()
+	}
+}
+
+//reset starts over for a new connection.
+func (g *streamGate) reset() {
+	g.mu.Lock()
+	g.ready, g.server = false, noLimit
+	g.mu.Unlock()
+}
+
+//setServer takes the stream limit of the server,0 if it allows no streams.
+func (g *streamGate) setServer(limit int) {
+	g.mu.Lock()
+	changed := g.server != limit
+	g.server = limit
+	g.mu.Unlock()
+	if changed {
+		g.cond.Broadcast()
+	}
+}
+
+//meanStreams returns the most streams a connection of gates had open at once and
+//the mean number of open streams a request shared its connection with,itself included.
+func meanStreams(gates []*streamGate) (peak int, mean float64) {
+	var requests, streams int64
+	for _, g := range gates {
+		g.mu.Lock()
+		if g.peak > peak {
+			peak = g.peak
+		}
+		requests += g.requests
+		streams += g.streams
+		g.mu.Unlock()
+	}
+	if requests > 0 {
+		mean = float64(streams) / float64(requests)
+	}
+	return peak, mean
+}
@@ -0,0 +1,102 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamGateLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		max, server int
+		ready       bool
+		limit       int
+	}{
+		{name: "new connection", max: 4, server: 8, limit: 1},
+		{name: "no limits", server: noLimit, ready: true, limit: noLimit},
+		{name: "server", server: 8, ready: true, limit: 8},
+		{name: "cap below server", max: 4, server: 8, ready: true, limit: 4},
+		{name: "server below cap", max: 8, server: 4, ready: true, limit: 4},
+		{name: "cap only", max: 4, server: noLimit, ready: true, limit: 4},
+		{name: "server allows none", max: 4, server: 0, limit: 0},
+		{name: "server allows none once ready", server: 0, ready: true, limit: 0},
+	}
+	for _, tt := range tests {
+		g := newStreamGate(tt.max)
+		g.server, g.ready = tt.server, tt.ready
+		if limit := g.limit(); limit != tt.limit {
+			t.Errorf("%s: limit = %d, want %d", tt.name, limit, tt.limit)
+		}
+	}
+}
+
+func TestStreamGate(t *testing.T) {
+	g := newStreamGate(2)
+	g.acquire()
+	acquired := make(chan bool)
+	go func() {
+		g.acquire()
+		acquired <- true
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("a second stream opened before the connection was ready")
+	case <-time.After(20 * time.Millisecond):
+	}
+	g.settle()
+	<-acquired
+	go func() {
+		g.acquire()
+		acquired <- true
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("a third stream opened over the cap of 2")
+	case <-time.After(20 * time.Millisecond):
+	}
+	g.release()
+	<-acquired
+	g.release()
+	g.release()
+	if peak, mean := meanStreams([]*streamGate{g}); peak != 2 || mean != 5.0/3 {
+		t.Errorf("peak %d mean %v, want 2 and %v", peak, mean, 5.0/3)
+	}
+	g.reset()
+	if limit := g.limit(); limit != 1 {
+		t.Errorf("limit after reset = %d, want 1", limit)
+	}
+
+	//a server allowing no streams fails the waiting requests and the new ones.
+	g.acquire()
+	failed := make(chan error)
+	go func() {
+		failed <- g.acquire()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	g.setServer(0)
+	if err := <-failed; err != errNoStreams {
+		t.Errorf("waiting request: err = %v, want %v", err, errNoStreams)
+	}
+	if err := g.acquire(); err != errNoStreams {
+		t.Errorf("new request: err = %v, want %v", err, errNoStreams)
+	}
+	g.setServer(2)
+	g.settle()
+	if err := g.acquire(); err != nil {
+		t.Errorf("after the limit was raised: %v", err)
+	}
+}
@@ -0,0 +1,426 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//Template is a text with placeholders which are expanded per request:
+//	{{seq}}               the sequence number of the request in the run,from 1
+//	{{worker}}            the id of the worker sending the request,from 0
+//	{{int:MIN,MAX}}       a random integer between MIN and MAX
+//	{{string:N}}          N random alphanumeric characters
+//	{{uuid}}              a random version 4 UUID
+//	{{timestamp}}         the unix time in seconds,{{timestamp:ms}},{{timestamp:us}},
+//	                      {{timestamp:ns}} or {{timestamp:rfc3339}} for other formats
+//	{{data:COLUMN}}       the column of the data file row drawn for the request
+//	{{var:NAME}}          a value extracted from an earlier response of the session
+//All placeholders of one request see the same sequence number and data row,in a
+//session they are shared by all its steps.
+type Template struct {
+	raw   string
+	parts []templatePart
+}
+
+type templatePart struct {
+	text string //the literal text if fn is empty
+	fn   string
+	arg  string
+	min  int64
+	max  int64
+}
+
+//ParseTemplate parses s.A text without placeholders is returned as a static template.
+func ParseTemplate(s string) (*Template, error) {
+	parts, err := parseParts(s, parsePlaceholder)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{raw: s, parts: parts}, nil
+}
+
+//parseParts splits s into literal texts and the placeholders parsed by placeholder.
+func parseParts(s string, placeholder func(string) (templatePart, error)) ([]templatePart, error) {
+	var parts []templatePart
+	rest := s
+	for {
+		i := strings.Index(rest, "{{")
+		if i == -1 {
+			break
+		}
+		j := strings.Index(rest[i:], "}}")
+		if j == -1 {
+			return nil, fmt.Errorf("unterminated placeholder in %q", s)
+		}
+		if i > 0 {
+			parts = append(parts, templatePart{text: rest[:i]})
+		}
+		p, err := placeholder(strings.TrimSpace(rest[i+2 : i+j]))
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+		rest = rest[i+j+2:]
+	}
+	if rest != "" {
+		parts = append(parts, templatePart{text: rest})
+	}
+	return parts, nil
+}
+
+func parsePlaceholder(s string) (templatePart, error) {
+	p := templatePart{fn: s}
+	if i := strings.Index(s, ":"); i != -1 {
+		p.fn, p.arg = s[:i], s[i+1:]
+	}
+	var err error
+	switch p.fn {
+	case "seq", "worker", "uuid":
+		if p.arg != "" {
+			err = errors.New("takes no argument")
+		}
+	case "int":
+		args := strings.Split(p.arg, ",")
+		if len(args) != 2 {
+			err = errors.New("needs MIN,MAX")
+			break
+		}
+		if p.min, err = strconv.ParseInt(strings.TrimSpace(args[0]), 10, 64); err == nil {
+			p.max, err = strconv.ParseInt(strings.TrimSpace(args[1]), 10, 64)
+		}
+		if err == nil && p.min > p.max {
+			err = errors.New("MIN is larger than MAX")
+		}
+	case "string":
+		if p.max, err = strconv.ParseInt(p.arg, 10, 64); err == nil && p.max <= 0 {
+			err = errors.New("needs a positive length")
+		}
+	case "timestamp":
+		switch p.arg {
+		case "", "s", "ms", "us", "ns", "rfc3339":
+		default:
+			err = errors.New("unknown format " + strconv.Quote(p.arg))
+		}
+	case "data":
+		if p.arg == "" {
+			err = errors.New("needs a column")
+		}
+	case "var":
+		if p.arg == "" {
+			err = errors.New("needs a name")
+		}
+	default:
+		err = errors.New("unknown placeholder")
+	}
+	if err != nil {
+		return p, fmt.Errorf("template {{%s}}: %v", s, err)
+	}
+	return p, nil
+}
+
+//IsStatic reports whether t has no placeholders.
+func (t *Template) IsStatic() bool {
+	for _, p := range t.parts {
+		if p.fn != "" {
+			return false
+		}
+	}
+	return true
+}
+
+//Sample returns the text of t with every placeholder replaced by 0,
+//which is enough to check the shape of a url.
+func (t *Template) Sample() string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.fn == "" {
+			b.WriteString(p.text)
+		} else {
+			b.WriteString("0")
+		}
+	}
+	return b.String()
+}
+
+//String returns the text t was parsed from.
+func (t *Template) String() string { return t.raw }
+
+//Execute expands the placeholders of t for the request of ctx.
+func (t *Template) Execute(ctx *TemplateContext) (string, error) {
+	if t.IsStatic() {
+		return t.raw, nil
+	}
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.fn == "" {
+			b.WriteString(p.text)
+			continue
+		}
+		v, err := p.execute(ctx)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(v)
+	}
+	return b.String(), nil
+}
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func (p templatePart) execute(ctx *TemplateContext) (string, error) {
+	switch p.fn {
+	case "seq":
+		return strconv.FormatUint(ctx.Seq, 10), nil
+	case "worker":
+		return strconv.Itoa(ctx.Worker), nil
+	case "int":
+		return strconv.FormatInt(p.min+mrand.Int63n(p.max-p.min+1), 10), nil
+	case "string":
+		b := make([]byte, p.max)
+		for i := range b {
+			b[i] = alphanumeric[mrand.Intn(len(alphanumeric))]
+		}
+		return string(b), nil
+	case "uuid":
+		var b [16]byte
+		mrand.Read(b[:])
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+	case "timestamp":
+		now := time.Now()
+		switch p.arg {
+		case "ms":
+			return strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10), nil
+		case "us":
+			return strconv.FormatInt(now.UnixNano()/int64(time.Microsecond), 10), nil
+		case "ns":
+			return strconv.FormatInt(now.UnixNano(), 10), nil
+		case "rfc3339":
+			return now.UTC().Format(time.RFC3339), nil
+		}
+		return strconv.FormatInt(now.Unix(), 10), nil
+	case "data":
+		row, err := ctx.dataRow()
+		if err != nil {
+			return "", err
+		}
+		v, ok := row[p.arg]
+		if !ok {
+			return "", fmt.Errorf("no column %q in the data file", p.arg)
+		}
+		return v, nil
+	case "var":
+		v, ok := ctx.Vars[p.arg]
+		if !ok {
+			return "", fmt.Errorf("variable %q was not extracted", p.arg)
+		}
+		return v, nil
+	}
+	return "", errors.New("unknown placeholder " + p.fn)
+}
+
+//TemplateContext holds what the templates of one request share.
+type TemplateContext struct {
+	Worker int
+	Seq    uint64
+	Vars   map[string]string //the values extracted in a session
+	data   DataSource
+	row    map[string]string
+}
+
+//dataRow draws the row of the request on first use.
+func (ctx *TemplateContext) dataRow() (map[string]string, error) {
+	if ctx.row != nil {
+		return ctx.row, nil
+	}
+	if ctx.data == nil {
+		return nil, errors.New("{{data}} needs a data file")
+	}
+	row, err := ctx.data.Next()
+	if err != nil {
+		return nil, err
+	}
+	ctx.row = row
+	return row, nil
+}
+
+//HeaderTemplate is a request header whose value is a template.
+type HeaderTemplate struct {
+	Name  string
+	Value *Template
+}
+
+//RequestTemplate builds the requests of a run.Each request gets its own header map,
+//so the transports may modify them while other workers build theirs.
+type RequestTemplate struct {
+	seq    uint64 //first for the alignment of atomic operations
+	Method string
+	URL    *Template
+	//Targets are more urls,the requests go to URL and each of them in turn.
+	Targets []*Template
+	Header  []HeaderTemplate
+	Body    BodySource
+	Data    DataSource
+	vars    map[string]bool
+}
+
+//SetVars declares the variables available to the templates of t.
+func (t *RequestTemplate) SetVars(names map[string]bool) { t.vars = names }
+
+//SetHeader sets the header name to the template value,replacing an earlier value of the same name.
+func (t *RequestTemplate) SetHeader(name, value string) error {
+	v, err := ParseTemplate(value)
+	if err != nil {
+		return err
+	}
+	name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	for i := range t.Header {
+		if t.Header[i].Name == name {
+			t.Header[i].Value = v
+			return nil
+		}
+	}
+	t.Header = append(t.Header, HeaderTemplate{Name: name, Value: v})
+	return nil
+}
+
+//Check verifies the data file has the columns the templates read and the
+//variables they read are extracted,see SetVars.
+func (t *RequestTemplate) Check() error {
+	templates := append([]*Template{t.URL}, t.Targets...)
+	for _, h := range t.Header {
+		templates = append(templates, h.Value)
+	}
+	if b, ok := t.Body.(*templateBody); ok {
+		templates = append(templates, b.template)
+	}
+	for _, tmpl := range templates {
+		for _, p := range tmpl.parts {
+			if p.fn == "var" && !t.vars[p.arg] {
+				return fmt.Errorf("{{var:%s}} is not extracted before", p.arg)
+			}
+			if p.fn != "data" {
+				continue
+			}
+			if t.Data == nil {
+				return errors.New("{{data:" + p.arg + "}} needs a data file")
+			}
+			if !t.Data.HasColumn(p.arg) {
+				return fmt.Errorf("no column %q in the data file", p.arg)
+			}
+		}
+	}
+	return nil
+}
+
+//NewContext returns the template context of the next request of worker.
+func (t *RequestTemplate) NewContext(worker int) *TemplateContext {
+	return &TemplateContext{Worker: worker, Seq: atomic.AddUint64(&t.seq, 1), data: t.Data}
+}
+
+//URLFor returns the url template of the request of ctx,see Targets.
+func (t *RequestTemplate) URLFor(ctx *TemplateContext) *Template {
+	if len(t.Targets) == 0 || ctx.Seq == 0 {
+		return t.URL
+	}
+	if i := (ctx.Seq - 1) % uint64(len(t.Targets)+1); i > 0 {
+		return t.Targets[i-1]
+	}
+	return t.URL
+}
+
+//NewRequest builds a request for ctx.The body is streamed,it's closed by the transport.
+func (t *RequestTemplate) NewRequest(ctx *TemplateContext) (*http.Request, error) {
+	url, err := t.URLFor(ctx).Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	header := make(http.Header, len(t.Header))
+	for _, h := range t.Header {
+		v, err := h.Value.Execute(ctx)
+		if err != nil {
+			return nil, err
+		}
+		header.Add(h.Name, v)
+	}
+	var body io.ReadCloser
+	var length int64
+	if b, ok := t.Body.(*templateBody); ok {
+		body, length, err = b.nextWith(ctx)
+	} else if t.Body != nil {
+		body, length, err = t.Body.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(t.Method, url, body)
+	if err != nil {
+		if body != nil {
+			body.Close()
+		}
+		return nil, err
+	}
+	req.ContentLength = length
+	if b, ok := body.(*stringBody); ok {
+		//literal bodies can be read again,eg to sign them at send time.
+		text := b.text
+		req.GetBody = func() (io.ReadCloser, error) { return newStringBody(text), nil }
+	}
+	req.Header = header
+	if host := header.Get("Host"); host != "" {
+		//net/http ignores a Host entry of the header map and sends req.Host.
+		req.Host = host
+	}
+	return req, nil
+}
+
+//templateBody is a literal body with placeholders.
+type templateBody struct {
+	template *Template
+}
+
+func (b *templateBody) Next() (io.ReadCloser, int64, error) {
+	return b.nextWith(&TemplateContext{})
+}
+
+func (b *templateBody) nextWith(ctx *TemplateContext) (io.ReadCloser, int64, error) {
+	s, err := b.template.Execute(ctx)
+	if err != nil || s == "" {
+		return nil, 0, err
+	}
+	return newStringBody(s), int64(len(s)), nil
+}
+
+//stringBody is the body of a literal text.
+type stringBody struct {
+	*strings.Reader
+	text string
+}
+
+func newStringBody(text string) *stringBody {
+	return &stringBody{Reader: strings.NewReader(text), text: text}
+}
+
+func (b *stringBody) Close() error { return nil }
@@ -0,0 +1,188 @@
+package ibench
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, s := range []string{
+		"{{seq",
+		"{{nope}}",
+		"{{seq:1}}",
+		"{{int:5}}",
+		"{{int:9,1}}",
+		"{{string:0}}",
+		"{{timestamp:hours}}",
+		"{{data:}}",
+	} {
+		if _, err := ParseTemplate(s); err == nil {
+			t.Errorf("ParseTemplate(%q) must fail", s)
+		}
+	}
+}
+
+func TestTemplateExecute(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string //a regular expression
+	}{
+		{"plain {not} a template}}", `^plain \{not\} a template\}\}$`},
+		{"/item/{{seq}}?w={{worker}}", `^/item/7\?w=3$`},
+		{"{{int:10,12}}", `^1[0-2]$`},
+		{"{{string:8}}", `^[a-zA-Z0-9]{8}$`},
+		{"{{uuid}}", `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{"{{timestamp}}", `^[0-9]{10}$`},
+		{"{{timestamp:ms}}", `^[0-9]{13}$`},
+		{"{{timestamp:rfc3339}}", `^[0-9]{4}-[0-9]{2}-[0-9]{2}T`},
+	}
+	for _, tt := range tests {
+		tmpl, err := ParseTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tmpl.Execute(&TemplateContext{Worker: 3, Seq: 7})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(tt.want).MatchString(got) {
+			t.Errorf("%q expanded to %q", tt.template, got)
+		}
+	}
+}
+
+func writeData(t *testing.T, name, content string) string {
+	t.Helper()
+	name = filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestOpenData(t *testing.T) {
+	csvFile := writeData(t, "users.csv", "id,name\n1,ann\n2,bob\n")
+	jsonFile := writeData(t, "users.jsonl", `{"id":1,"name":"ann","tags":["a"]}`+"\n\n"+`{"id":2,"name":"bob","tags":null}`+"\n")
+	for _, name := range []string{csvFile, jsonFile} {
+		d, err := OpenData(name, DataSequential)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.HasColumn("name") || d.HasColumn("missing") {
+			t.Errorf("%s: wrong columns", name)
+		}
+		var got []string
+		for i := 0; i < 3; i++ {
+			row, err := d.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, row["id"]+row["name"])
+		}
+		if got[0] != "1ann" || got[1] != "2bob" || got[2] != "1ann" {
+			t.Errorf("%s: sequential rows %v", name, got)
+		}
+	}
+
+	d, err := OpenData(jsonFile, DataUnique)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, _ := d.Next()
+	if row["tags"] != `["a"]` {
+		t.Errorf("nested value = %q, want json", row["tags"])
+	}
+	d.Next()
+	if _, err := d.Next(); err != ErrDataExhausted {
+		t.Errorf("unique data must be exhausted after every row, got %v", err)
+	}
+	if ClassifyError(ErrDataExhausted) != "data exhausted" {
+		t.Error("exhausted data must have its own error class")
+	}
+
+	if _, err := OpenData(csvFile, "shuffle"); err == nil {
+		t.Error("unknown modes must be rejected")
+	}
+	if _, err := OpenData(writeData(t, "empty.csv", "id\n"), DataRandom); err == nil {
+		t.Error("files without rows must be rejected")
+	}
+}
+
+func TestRequestTemplate(t *testing.T) {
+	url, _ := ParseTemplate("http://127.0.0.1/user/{{data:id}}?seq={{seq}}")
+	body, err := ParseBody(`{"name":"{{data:name}}","worker":{{worker}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := OpenData(writeData(t, "users.csv", "id,name\n1,ann\n2,bob\n"), DataSequential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &RequestTemplate{Method: "POST", URL: url, Body: body}
+	if err := rt.SetHeader("x-user", "{{data:name}}"); err != nil {
+		t.Fatal(err)
+	}
+	rt.SetHeader("Host", "example.com")
+	if err := rt.Check(); err == nil {
+		t.Error("data placeholders without a data file must fail the check")
+	}
+	rt.Data = data
+	if err := rt.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	var headers []map[string][]string
+	for i := 1; i <= 2; i++ {
+		req, err := rt.NewRequest(rt.NewContext(5))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(req.Body)
+		user := []string{"ann", "bob"}[i-1]
+		if want := "/user/" + strconv.Itoa(i) + "?seq=" + strconv.Itoa(i); req.URL.RequestURI() != want {
+			t.Errorf("url = %q, want %q", req.URL.RequestURI(), want)
+		}
+		if want := `{"name":"` + user + `","worker":5}`; string(b) != want || req.ContentLength != int64(len(want)) {
+			t.Errorf("body = %q (%d), want %q", b, req.ContentLength, want)
+		}
+		if req.Header.Get("X-User") != user || req.Host != "example.com" {
+			t.Errorf("header = %v, host = %q", req.Header, req.Host)
+		}
+		headers = append(headers, req.Header)
+	}
+	headers[0]["X-Changed"] = []string{"1"}
+	if _, ok := headers[1]["X-Changed"]; ok {
+		t.Error("requests must not share their header map")
+	}
+}
+
+func TestRequestTemplateTargets(t *testing.T) {
+	url, _ := ParseTemplate("http://a/{{seq}}")
+	other, _ := ParseTemplate("http://b/{{data:id}}")
+	rt := &RequestTemplate{Method: "GET", URL: url, Targets: []*Template{other}}
+	if err := rt.Check(); err == nil {
+		t.Error("the placeholders of a target must be checked")
+	}
+	other, _ = ParseTemplate("http://b/{{seq}}")
+	rt.Targets = []*Template{other}
+	var got []string
+	for i := 0; i < 4; i++ {
+		ctx := rt.NewContext(0)
+		req, err := rt.NewRequest(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rt.URLFor(ctx).String() != "http://"+req.URL.Host+"/{{seq}}" {
+			t.Errorf("URLFor = %s for %s", rt.URLFor(ctx), req.URL)
+		}
+		got = append(got, req.URL.String())
+	}
+	for i, want := range []string{"http://a/1", "http://b/2", "http://a/3", "http://b/4"} {
+		if got[i] != want {
+			t.Errorf("url %d = %q, want %q", i, got[i], want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+//tlsVersions maps the versions of TLSOptions to their ids.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//TLSOptions are the client tls settings of a run.
+type TLSOptions struct {
+	MinVersion string //"1.0" to "1.3",empty for go's default
+	MaxVersion string
+	ServerName string //sent instead of the host of the url,empty for the host
+	CAFile     string //pem file of the CAs the server must chain to,empty to skip the verification
+	CertFile   string //pem client certificate and key,both or neither
+	KeyFile    string
+}
+
+//Apply sets the options on c.
+func (o TLSOptions) Apply(c *tls.Config) error {
+	var ok bool
+	if o.MinVersion != "" {
+		if c.MinVersion, ok = tlsVersions[o.MinVersion]; !ok {
+			return fmt.Errorf("unknown tls version %q,want 1.0,1.1,1.2 or 1.3", o.MinVersion)
+		}
+	}
+	if o.MaxVersion != "" {
+		if c.MaxVersion, ok = tlsVersions[o.MaxVersion]; !ok {
+			return fmt.Errorf("unknown tls version %q,want 1.0,1.1,1.2 or 1.3", o.MaxVersion)
+		}
+	}
+	if c.MinVersion != 0 && c.MaxVersion != 0 && c.MinVersion > c.MaxVersion {
+		return fmt.Errorf("tls version %s is above %s", o.MinVersion, o.MaxVersion)
+	}
+	c.ServerName = o.ServerName
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate in %s", o.CAFile)
+		}
+		c.InsecureSkipVerify = false
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return errors.New("a client certificate needs both the certificate and the key file")
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return nil
+}
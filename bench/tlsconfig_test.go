@@ -0,0 +1,109 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package ibench
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+//writePEM writes the blocks of type to a file in a temporary directory.
+func writePEM(t *testing.T, name, typ string, blocks ...[]byte) string {
+	t.Helper()
+	var data []byte
+	for _, b := range blocks {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b})...)
+	}
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestTLSOptions(t *testing.T) {
+	c := &tls.Config{InsecureSkipVerify: true}
+	if err := (TLSOptions{MinVersion: "1.2", MaxVersion: "1.3", ServerName: "example.com"}).Apply(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.MinVersion != tls.VersionTLS12 || c.MaxVersion != tls.VersionTLS13 || c.ServerName != "example.com" || !c.InsecureSkipVerify {
+		t.Errorf("config %+v", c)
+	}
+	for _, o := range []TLSOptions{
+		{MinVersion: "1.4"},
+		{MinVersion: "1.3", MaxVersion: "1.2"},
+		{CertFile: "cert.pem"},
+		{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		if err := o.Apply(&tls.Config{}); err == nil {
+			t.Errorf("%+v: no error", o)
+		}
+	}
+}
+
+func TestTLSOptionsVerify(t *testing.T) {
+	client, err := SelfSignedCertificate("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the self-signed certificate is only for servers,so it's required but not verified.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	key, err := x509.MarshalPKCS8PrivateKey(client.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := TLSOptions{
+		ServerName: "example.com",
+		CAFile:     writePEM(t, "ca.pem", "CERTIFICATE", srv.Certificate().Raw),
+		CertFile:   writePEM(t, "cert.pem", "CERTIFICATE", client.Certificate[0]),
+		KeyFile:    writePEM(t, "key.pem", "PRIVATE KEY", key),
+	}
+	get := func(o TLSOptions) error {
+		c := &tls.Config{InsecureSkipVerify: true}
+		if err := o.Apply(c); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: c}}).Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err := get(options); err != nil {
+		t.Errorf("verified request with a client certificate:%v", err)
+	}
+	wrongName := options
+	wrongName.ServerName = "other.org"
+	if get(wrongName) == nil {
+		t.Error("a server name the certificate doesn't cover was accepted")
+	}
+	noCert := options
+	noCert.CertFile, noCert.KeyFile = "", ""
+	if get(noCert) == nil {
+		t.Error("the server accepted a client without certificate")
+	}
+}
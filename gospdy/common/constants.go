@@ -7,6 +7,7 @@ package common
 import (
 	"net/url"
 	"strings"
+	"time"
 )
 
 // Frame types in SPDY/2
@@ -106,6 +107,9 @@ const MAX_DELTA_WINDOW_SIZE = 0x7fffffff
 // The default maximum number of concurrent streams.
 const DEFAULT_STREAM_LIMIT = 1000
 
+// The default time a PING may wait for its reply.
+const DEFAULT_PING_TIMEOUT = 10 * time.Second
+
 // NO_STREAM_LIMIT can be used to disable the stream limit.
 const NO_STREAM_LIMIT = 0x80000000
 
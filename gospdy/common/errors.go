@@ -25,6 +25,9 @@ var (
 	ErrConnNil        = errors.New("Error: Connection is nil.")
 	ErrConnClosed     = errors.New("Error: Connection is closed.")
 	ErrGoaway         = errors.New("Error: GOAWAY received.")
+	ErrRefused        = errors.New("Error: Stream refused by GOAWAY.")
+	ErrPingTimeout    = errors.New("Error: PING timed out.")
+	ErrNoStreams      = errors.New("Error: The server allows no new streams.")
 	ErrNoFlowControl  = errors.New("Error: This connection does not use flow control.")
 	ErrConnectFail    = errors.New("Error: Failed to connect.")
 	ErrInvalidVersion = errors.New("Error: Invalid SPDY version.")
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"time"
 )
 
 // Connection represents a SPDY connection. The connection should
@@ -23,6 +24,7 @@ type Conn interface {
 	Request(request *http.Request, receiver Receiver, priority Priority) (Stream, error)
 	RequestResponse(request *http.Request, receiver Receiver, priority Priority) (*http.Response, error)
 	Run() error
+	Stats() ConnStats
 }
 
 // Stream contains a single SPDY stream.
@@ -96,6 +98,34 @@ type SetFlowController interface {
 	SetFlowControl(FlowControl)
 }
 
+// SettingsConfigurer represents a client connection
+// whose first SETTINGS can be chosen, and which
+// reports the SETTINGS the server asks it to persist.
+// Both should be set before the connection is run.
+//
+// The persister is called with each SETTINGS frame
+// from the server which clears the persisted settings
+// or asks for some to be persisted, already flagged
+// as FLAG_SETTINGS_PERSISTED.
+type SettingsConfigurer interface {
+	SetSettings(Settings)
+	SetSettingsPersister(func(clear bool, persist Settings))
+}
+
+// FrameObserver is told of every frame a connection
+// sends or receives. ObserveFrame is called from the
+// connection's read and write loops, so it should not
+// block for long.
+type FrameObserver interface {
+	ObserveFrame(when time.Time, dir Direction, frame Frame)
+}
+
+// FrameObservable represents a connection whose
+// frames can be observed.
+type FrameObservable interface {
+	SetFrameObserver(FrameObserver)
+}
+
 // Objects implementing the Receiver interface can be
 // registered to receive requests on the Client.
 //
@@ -34,6 +34,8 @@ func (s *StreamLimit) SetLimit(l uint32) {
 
 // Limit returns the current limit.
 func (s *StreamLimit) Limit() uint32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	return s.limit
 }
 
@@ -0,0 +1,296 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConnStats is a snapshot of the activity on a SPDY
+// connection.
+type ConnStats struct {
+	// Frames counts the frames by type, such as "SYN_STREAM".
+	FramesSent     map[string]int
+	FramesReceived map[string]int
+
+	// The name/value header blocks, before and after
+	// compression.
+	HeaderBytesSent               int64
+	CompressedHeaderBytesSent     int64
+	HeaderBytesReceived           int64
+	CompressedHeaderBytesReceived int64
+
+	// The payload of DATA frames.
+	DataBytesSent     int64
+	DataBytesReceived int64
+
+	WindowUpdatesSent     int
+	WindowUpdatesReceived int
+
+	// FlowControlStall is the time streams had data held
+	// back by their transfer window, summed over the streams.
+	FlowControlStall time.Duration
+
+	// RSTStream counts the RST_STREAM frames by status.
+	RSTStreamSent     map[StatusCode]int
+	RSTStreamReceived map[StatusCode]int
+
+	// Pings counts the answered PINGs which were sent, and
+	// PingsFailed those which were not.
+	Pings        int
+	PingsFailed  int
+	MinPingRTT   time.Duration
+	MaxPingRTT   time.Duration
+	TotalPingRTT time.Duration
+
+	// PeakStreams is the most streams open at once.
+	PeakStreams int
+}
+
+// MeanPingRTT gives the mean round-trip time of the
+// answered PINGs.
+func (s *ConnStats) MeanPingRTT() time.Duration {
+	if s.Pings == 0 {
+		return 0
+	}
+	return s.TotalPingRTT / time.Duration(s.Pings)
+}
+
+// Add sums o into s, as for several connections. The
+// peak streams are those of the busiest connection.
+func (s *ConnStats) Add(o ConnStats) {
+	s.FramesSent = addCounts(s.FramesSent, o.FramesSent)
+	s.FramesReceived = addCounts(s.FramesReceived, o.FramesReceived)
+	s.HeaderBytesSent += o.HeaderBytesSent
+	s.CompressedHeaderBytesSent += o.CompressedHeaderBytesSent
+	s.HeaderBytesReceived += o.HeaderBytesReceived
+	s.CompressedHeaderBytesReceived += o.CompressedHeaderBytesReceived
+	s.DataBytesSent += o.DataBytesSent
+	s.DataBytesReceived += o.DataBytesReceived
+	s.WindowUpdatesSent += o.WindowUpdatesSent
+	s.WindowUpdatesReceived += o.WindowUpdatesReceived
+	s.FlowControlStall += o.FlowControlStall
+	s.RSTStreamSent = addStatusCounts(s.RSTStreamSent, o.RSTStreamSent)
+	s.RSTStreamReceived = addStatusCounts(s.RSTStreamReceived, o.RSTStreamReceived)
+	if o.Pings > 0 {
+		if s.Pings == 0 || o.MinPingRTT < s.MinPingRTT {
+			s.MinPingRTT = o.MinPingRTT
+		}
+		if o.MaxPingRTT > s.MaxPingRTT {
+			s.MaxPingRTT = o.MaxPingRTT
+		}
+	}
+	s.Pings += o.Pings
+	s.PingsFailed += o.PingsFailed
+	s.TotalPingRTT += o.TotalPingRTT
+	if o.PeakStreams > s.PeakStreams {
+		s.PeakStreams = o.PeakStreams
+	}
+}
+
+func addCounts(dst, src map[string]int) map[string]int {
+	if dst == nil {
+		dst = make(map[string]int, len(src))
+	}
+	for k, v := range src {
+		dst[k] += v
+	}
+	return dst
+}
+
+func addStatusCounts(dst, src map[StatusCode]int) map[StatusCode]int {
+	if dst == nil {
+		dst = make(map[StatusCode]int, len(src))
+	}
+	for k, v := range src {
+		dst[k] += v
+	}
+	return dst
+}
+
+// Stats collects the ConnStats of a connection. It is
+// safe for concurrent use.
+type Stats struct {
+	sync.Mutex
+	stats ConnStats
+}
+
+// Snapshot returns a copy of the statistics so far.
+func (s *Stats) Snapshot() ConnStats {
+	s.Lock()
+	defer s.Unlock()
+	var out ConnStats
+	out.Add(s.stats)
+	return out
+}
+
+// Frame counts a frame of the given type.
+func (s *Stats) Frame(sent bool, name string) {
+	s.Lock()
+	defer s.Unlock()
+	if sent {
+		if s.stats.FramesSent == nil {
+			s.stats.FramesSent = make(map[string]int)
+		}
+		s.stats.FramesSent[name]++
+		if name == "WINDOW_UPDATE" {
+			s.stats.WindowUpdatesSent++
+		}
+	} else {
+		if s.stats.FramesReceived == nil {
+			s.stats.FramesReceived = make(map[string]int)
+		}
+		s.stats.FramesReceived[name]++
+		if name == "WINDOW_UPDATE" {
+			s.stats.WindowUpdatesReceived++
+		}
+	}
+}
+
+// Data counts the n bytes of a DATA frame.
+func (s *Stats) Data(sent bool, n int) {
+	s.Lock()
+	if sent {
+		s.stats.DataBytesSent += int64(n)
+	} else {
+		s.stats.DataBytesReceived += int64(n)
+	}
+	s.Unlock()
+}
+
+// RSTStream counts an RST_STREAM frame.
+func (s *Stats) RSTStream(sent bool, status StatusCode) {
+	s.Lock()
+	if sent {
+		if s.stats.RSTStreamSent == nil {
+			s.stats.RSTStreamSent = make(map[StatusCode]int)
+		}
+		s.stats.RSTStreamSent[status]++
+	} else {
+		if s.stats.RSTStreamReceived == nil {
+			s.stats.RSTStreamReceived = make(map[StatusCode]int)
+		}
+		s.stats.RSTStreamReceived[status]++
+	}
+	s.Unlock()
+}
+
+// Stall adds time a stream's data was held back.
+func (s *Stats) Stall(d time.Duration) {
+	s.Lock()
+	s.stats.FlowControlStall += d
+	s.Unlock()
+}
+
+// Ping counts a PING which was answered after rtt, or
+// failed with err.
+func (s *Stats) Ping(rtt time.Duration, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if err != nil {
+		s.stats.PingsFailed++
+		return
+	}
+	if s.stats.Pings == 0 || rtt < s.stats.MinPingRTT {
+		s.stats.MinPingRTT = rtt
+	}
+	if rtt > s.stats.MaxPingRTT {
+		s.stats.MaxPingRTT = rtt
+	}
+	s.stats.Pings++
+	s.stats.TotalPingRTT += rtt
+}
+
+// Streams records the number of open streams.
+func (s *Stats) Streams(n int) {
+	s.Lock()
+	if n > s.stats.PeakStreams {
+		s.stats.PeakStreams = n
+	}
+	s.Unlock()
+}
+
+// Compressor returns c, counting the header bytes it
+// compresses. version is the SPDY version of c.
+func (s *Stats) Compressor(c Compressor, version uint16) Compressor {
+	return &countingCompressor{Compressor: c, stats: s, version: version}
+}
+
+// Decompressor returns d, counting the header bytes it
+// decompresses. version is the SPDY version of d.
+func (s *Stats) Decompressor(d Decompressor, version uint16) Decompressor {
+	return &countingDecompressor{Decompressor: d, stats: s, version: version}
+}
+
+type countingCompressor struct {
+	Compressor
+	stats   *Stats
+	version uint16
+}
+
+func (c *countingCompressor) Compress(h http.Header) ([]byte, error) {
+	out, err := c.Compressor.Compress(h)
+	if err == nil {
+		c.stats.header(true, headerBlockSize(h, c.version), len(out))
+	}
+	return out, err
+}
+
+type countingDecompressor struct {
+	Decompressor
+	stats   *Stats
+	version uint16
+}
+
+func (d *countingDecompressor) Decompress(data []byte) (http.Header, error) {
+	h, err := d.Decompressor.Decompress(data)
+	if err == nil {
+		d.stats.header(false, headerBlockSize(h, d.version), len(data))
+	}
+	return h, err
+}
+
+func (s *Stats) header(sent bool, plain, compressed int) {
+	s.Lock()
+	if sent {
+		s.stats.HeaderBytesSent += int64(plain)
+		s.stats.CompressedHeaderBytesSent += int64(compressed)
+	} else {
+		s.stats.HeaderBytesReceived += int64(plain)
+		s.stats.CompressedHeaderBytesReceived += int64(compressed)
+	}
+	s.Unlock()
+}
+
+// headerBlockSize gives the size of h as an uncompressed
+// name/value header block of the given SPDY version.
+func headerBlockSize(h http.Header, version uint16) int {
+	size := 4 // Size of length values.
+	if version == 2 {
+		size = 2
+	}
+	n := size
+	for name, values := range h {
+		if name == "" {
+			continue
+		}
+		n += size + len(name) + size + len(strings.Join(values, "\x00"))
+	}
+	return n
+}
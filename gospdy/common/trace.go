@@ -0,0 +1,152 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Direction indicates whether a frame was sent or
+// received.
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "out"
+	}
+	return "in"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Direction) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "in":
+		*d = Inbound
+	case "out":
+		*d = Outbound
+	default:
+		return fmt.Errorf("Error: Unknown frame direction %q.", text)
+	}
+	return nil
+}
+
+// FrameRecord is a frame as written by a FrameWriter,
+// one JSON object per line.
+type FrameRecord struct {
+	Time    time.Time       `json:"time"`
+	Session string          `json:"session,omitempty"`
+	Dir     Direction       `json:"dir"`
+	Type    string          `json:"type"`
+	Frame   json.RawMessage `json:"frame"`
+}
+
+// FrameWriter is a FrameObserver which writes the
+// frames to an io.Writer as JSON lines. It is safe
+// for use by several connections at once, see Session.
+type FrameWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	enc    *json.Encoder
+	err    error
+	closed bool
+}
+
+// NewFrameWriter returns a FrameWriter writing to w.
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w, enc: json.NewEncoder(w)}
+}
+
+// ObserveFrame writes the frame without a session.
+func (w *FrameWriter) ObserveFrame(when time.Time, dir Direction, frame Frame) {
+	w.write("", when, dir, frame)
+}
+
+// Session returns a FrameObserver which writes to w,
+// naming the session of each frame so that several
+// connections can share the output.
+func (w *FrameWriter) Session(name string) FrameObserver {
+	return &sessionWriter{w, name}
+}
+
+// Err returns the first error encountered while
+// writing, after which nothing more is written.
+func (w *FrameWriter) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close stops the writing of frames, and flushes the
+// io.Writer if it has a Flush method, as a bufio.Writer
+// does. It returns the first error encountered. The
+// io.Writer is not closed.
+func (w *FrameWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if f, ok := w.w.(interface {
+		Flush() error
+	}); ok && w.err == nil {
+		w.err = f.Flush()
+	}
+	return w.err
+}
+
+func (w *FrameWriter) write(session string, when time.Time, dir Direction, frame Frame) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.err != nil {
+		return
+	}
+	body, err := json.Marshal(frame)
+	if err != nil {
+		w.err = err
+		return
+	}
+	w.err = w.enc.Encode(&FrameRecord{
+		Time:    when,
+		Session: session,
+		Dir:     dir,
+		Type:    frame.Name(),
+		Frame:   body,
+	})
+}
+
+type sessionWriter struct {
+	w    *FrameWriter
+	name string
+}
+
+func (s *sessionWriter) ObserveFrame(when time.Time, dir Direction, frame Frame) {
+	s.w.write(s.name, when, dir, frame)
+}
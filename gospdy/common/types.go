@@ -228,3 +228,36 @@ func (s Settings) Settings() []*Setting {
 
 	return out
 }
+
+// Update sets the settings of o in s, replacing
+// any with the same ID.
+func (s Settings) Update(o Settings) {
+	for id, setting := range o {
+		copied := *setting
+		s[id] = &copied
+	}
+}
+
+// Persist returns the settings of s which are flagged
+// with FLAG_SETTINGS_PERSIST_VALUE, flagged instead with
+// FLAG_SETTINGS_PERSISTED, as a client sends them back.
+func (s Settings) Persist() Settings {
+	out := make(Settings)
+	for id, setting := range s {
+		if setting.Flags.PERSIST_VALUE() {
+			out[id] = &Setting{Flags: FLAG_SETTINGS_PERSISTED, ID: id, Value: setting.Value}
+		}
+	}
+	return out
+}
+
+// SettingID returns the ID of the setting with the
+// given name, such as "MAX_CONCURRENT_STREAMS".
+func SettingID(name string) (id uint32, ok bool) {
+	for id, text := range settingText {
+		if text == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
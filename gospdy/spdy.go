@@ -28,20 +28,22 @@ func SetMaxBenignErrors(n int) {
 }
 
 // AddSPDY adds SPDY support to srv, and must be called before srv begins serving.
+// SPDY is offered with ALPN ahead of any other protocols in srv.TLSConfig, so
+// that clients offering it too get a SPDY session.
 func AddSPDY(srv *http.Server) {
 	if srv == nil {
 		return
 	}
 
-	npnStrings := npn()
-	if len(npnStrings) <= 1 {
+	alpnStrings := alpn()
+	if len(alpnStrings) <= 1 {
 		return
 	}
 	if srv.TLSConfig == nil {
 		srv.TLSConfig = new(tls.Config)
 	}
 	if srv.TLSConfig.NextProtos == nil {
-		srv.TLSConfig.NextProtos = npnStrings
+		srv.TLSConfig.NextProtos = alpnStrings
 	} else {
 		// Collect compatible alternative protocols.
 		others := make([]string, 0, len(srv.TLSConfig.NextProtos))
@@ -52,8 +54,8 @@ func AddSPDY(srv *http.Server) {
 		}
 
 		// Start with spdy.
-		srv.TLSConfig.NextProtos = make([]string, 0, len(others)+len(npnStrings))
-		srv.TLSConfig.NextProtos = append(srv.TLSConfig.NextProtos, npnStrings[:len(npnStrings)-1]...)
+		srv.TLSConfig.NextProtos = make([]string, 0, len(others)+len(alpnStrings))
+		srv.TLSConfig.NextProtos = append(srv.TLSConfig.NextProtos, alpnStrings[:len(alpnStrings)-1]...)
 
 		// Add the others.
 		srv.TLSConfig.NextProtos = append(srv.TLSConfig.NextProtos, others...)
@@ -62,10 +64,8 @@ func AddSPDY(srv *http.Server) {
 	if srv.TLSNextProto == nil {
 		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
 	}
-	for _, str := range npnStrings {
+	for _, str := range alpnStrings {
 		switch str {
-		case "spdy/2":
-			srv.TLSNextProto[str] = spdy2.NextProto
 		case "spdy/3":
 			srv.TLSNextProto[str] = spdy3.NextProto
 		case "spdy/3.1":
@@ -212,8 +212,8 @@ func PingServer(c http.Client, server string) (<-chan bool, error) {
 				u.Host += ":443"
 			}
 		}
-		conn, ok := transport.spdyConns[u.Host]
-		if !ok || conn == nil {
+		conn := transport.session(u.Host)
+		if conn == nil {
 			return nil, common.ErrNotConnected
 		}
 		return conn.(Pinger).Ping()
@@ -34,20 +34,27 @@ type Conn struct {
 	output      [8]chan common.Frame              // one output channel per priority level.
 
 	// other state
-	compressor       common.Compressor   // outbound compression state.
-	decompressor     common.Decompressor // inbound decompression state.
-	receivedSettings common.Settings     // settings sent by client.
-	goawayReceived   bool                // goaway has been received.
-	goawaySent       bool                // goaway has been sent.
-	goawayLock       sync.Mutex          // protects goawaySent and goawayReceived.
-	numBenignErrors  int                 // number of non-serious errors encountered.
-	readTimeout      time.Duration       // optional timeout for network reads.
-	writeTimeout     time.Duration       // optional timeout for network writes.
-	timeoutLock      sync.Mutex          // protects changes to readTimeout and writeTimeout.
+	compressor       common.Compressor           // outbound compression state.
+	decompressor     common.Decompressor         // inbound decompression state.
+	receivedSettings common.Settings             // settings sent by client.
+	goawayReceived   bool                        // goaway has been received.
+	goawaySent       bool                        // goaway has been sent.
+	goawayLock       sync.Mutex                  // protects goawaySent and goawayReceived.
+	numBenignErrors  int                         // number of non-serious errors encountered.
+	readTimeout      time.Duration               // optional timeout for network reads.
+	writeTimeout     time.Duration               // optional timeout for network writes.
+	timeoutLock      sync.Mutex                  // protects changes to readTimeout and writeTimeout.
+	stats            common.Stats                // activity on the connection.
+	observer         common.FrameObserver        // observer of the frames, if any.
+	observerLock     sync.Mutex                  // protects observer.
+	settings         common.Settings             // settings to send, replacing the defaults.
+	persister        func(bool, common.Settings) // called with the settings to persist.
+	settingsLock     sync.Mutex                  // protects settings and persister.
 
 	// SPDY features
 	pings                map[uint32]chan<- bool                // response channel for pings.
-	pingsLock            sync.Mutex                            // protects pings.
+	pingTimes            map[uint32]time.Time                  // when the pings were sent.
+	pingsLock            sync.Mutex                            // protects pings and pingTimes.
 	nextPingID           uint32                                // next outbound ping ID.
 	nextPingIDLock       sync.Mutex                            // protects nextPingID.
 	pushStreamLimit      *common.StreamLimit                   // Limit on streams started by the server.
@@ -97,8 +104,9 @@ func NewConn(conn net.Conn, server *http.Server) *Conn {
 	out.output[6] = make(chan common.Frame)
 	out.output[7] = make(chan common.Frame)
 	out.pings = make(map[uint32]chan<- bool)
-	out.compressor = common.NewCompressor(2)
-	out.decompressor = common.NewDecompressor(2)
+	out.pingTimes = make(map[uint32]time.Time)
+	out.compressor = out.stats.Compressor(common.NewCompressor(2), 2)
+	out.decompressor = out.stats.Decompressor(common.NewDecompressor(2), 2)
 	out.receivedSettings = make(common.Settings)
 	out.lastPushStreamID = 0
 	out.lastRequestStreamID = 0
@@ -136,6 +144,9 @@ func NewConn(conn net.Conn, server *http.Server) *Conn {
 			// Initialise the connection by sending the connection settings.
 			settings := new(frames.SETTINGS)
 			settings.Settings = defaultClientSettings(common.DEFAULT_STREAM_LIMIT)
+			out.settingsLock.Lock()
+			settings.Settings.Update(out.settings)
+			out.settingsLock.Unlock()
 			out.output[0] <- settings
 		}
 	}
@@ -4,6 +4,7 @@ import (
 	"github.com/albus01/ibenchmark/gospdy/common"
 	"github.com/albus01/ibenchmark/gospdy/spdy2/frames"
 	"runtime"
+	"time"
 )
 
 // readFrames is the main processing loop, where frames
@@ -51,6 +52,7 @@ func (c *Conn) readFrames() {
 
 		// Print frame once the content's been decompressed.
 		debug.Println(frame)
+		c.noteFrame(false, frame)
 
 		// This is the main frame handling.
 		if c.processFrame(frame) {
@@ -112,6 +114,31 @@ func (c *Conn) send() {
 			c.handleReadWriteError(err)
 			return
 		}
+		c.noteFrame(true, frame)
+	}
+}
+
+// noteFrame adds a frame sent or received to the
+// connection's statistics, and passes it to the
+// frame observer, if any.
+func (c *Conn) noteFrame(sent bool, frame common.Frame) {
+	c.observerLock.Lock()
+	observer := c.observer
+	c.observerLock.Unlock()
+	if observer != nil {
+		dir := common.Inbound
+		if sent {
+			dir = common.Outbound
+		}
+		observer.ObserveFrame(time.Now(), dir, frame)
+	}
+
+	c.stats.Frame(sent, frame.Name())
+	switch frame := frame.(type) {
+	case *frames.DATA:
+		c.stats.Data(sent, len(frame.Data))
+	case *frames.RST_STREAM:
+		c.stats.RSTStream(sent, frame.Status)
 	}
 }
 
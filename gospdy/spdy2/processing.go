@@ -5,6 +5,7 @@ import (
 	"github.com/albus01/ibenchmark/gospdy/spdy2/frames"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 // processFrame handles the initial processing of the given
@@ -35,6 +36,9 @@ func (c *Conn) processFrame(frame common.Frame) bool {
 		c.handleRstStream(frame)
 
 	case *frames.SETTINGS:
+		if c.server == nil {
+			c.persistSettings(frame.Flags.CLEAR_SETTINGS(), frame.Settings.Persist())
+		}
 		for _, setting := range frame.Settings {
 			c.receivedSettings[setting.ID] = setting
 			switch setting.ID {
@@ -66,9 +70,11 @@ func (c *Conn) processFrame(frame common.Frame) bool {
 				c.pingsLock.Unlock()
 				return false
 			}
+			c.stats.Ping(time.Since(c.pingTimes[frame.PingID]), nil)
 			c.pings[frame.PingID] <- true
 			close(c.pings[frame.PingID])
 			delete(c.pings, frame.PingID)
+			delete(c.pingTimes, frame.PingID)
 			c.pingsLock.Unlock()
 		} else {
 			debug.Println("Received PING. Replying...")
@@ -318,6 +324,7 @@ func (c *Conn) handleRequest(frame *frames.SYN_STREAM) {
 	// Set and prepare.
 	c.streamsLock.Lock()
 	c.streams[sid] = nextStream
+	c.stats.Streams(len(c.streams))
 	c.streamsLock.Unlock()
 	c.lastRequestStreamIDLock.Lock()
 	c.lastRequestStreamID = sid
@@ -128,6 +128,7 @@ func (c *Conn) Request(request *http.Request, receiver common.Receiver, priority
 	// Store in the connection map.
 	c.streamsLock.Lock()
 	c.streams[syn.StreamID] = out
+	c.stats.Streams(len(c.streams))
 	c.streamsLock.Unlock()
 
 	return out, nil
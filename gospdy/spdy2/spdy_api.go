@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // Ping is used by spdy.PingServer and spdy.PingClient to send
@@ -30,6 +31,9 @@ func (c *Conn) Ping() (<-chan bool, error) {
 	}
 	c.nextPingIDLock.Unlock()
 	ping.PingID = pid
+	c.pingsLock.Lock()
+	c.pingTimes[pid] = time.Now()
+	c.pingsLock.Unlock()
 	c.output[0] <- ping
 	ch := make(chan bool, 1)
 	c.pingsLock.Lock()
@@ -116,7 +120,54 @@ func (c *Conn) Push(resource string, origin common.Stream) (common.PushStream, e
 	// Store in the connection map.
 	c.streamsLock.Lock()
 	c.streams[newID] = out
+	c.stats.Streams(len(c.streams))
 	c.streamsLock.Unlock()
 
 	return out, nil
 }
+
+// Stats returns a snapshot of the activity on the connection.
+func (c *Conn) Stats() common.ConnStats {
+	return c.stats.Snapshot()
+}
+
+// SetFrameObserver sets the observer of the frames sent
+// and received, nil for none. It should be set before
+// Run, to see the frames which start the connection.
+func (c *Conn) SetFrameObserver(o common.FrameObserver) {
+	c.observerLock.Lock()
+	c.observer = o
+	c.observerLock.Unlock()
+}
+
+// SetSettings sets the SETTINGS sent by a client when
+// the connection starts, replacing the defaults with
+// the same ID. It should be called before Run.
+func (c *Conn) SetSettings(s common.Settings) {
+	settings := make(common.Settings)
+	settings.Update(s)
+	c.settingsLock.Lock()
+	c.settings = settings
+	c.settingsLock.Unlock()
+}
+
+// SetSettingsPersister sets the function called with
+// the SETTINGS the server asks a client to persist, see
+// common.SettingsConfigurer. It should be called before
+// Run.
+func (c *Conn) SetSettingsPersister(p func(clear bool, persist common.Settings)) {
+	c.settingsLock.Lock()
+	c.persister = p
+	c.settingsLock.Unlock()
+}
+
+// persistSettings passes the SETTINGS to persist to the
+// persister, if any.
+func (c *Conn) persistSettings(clear bool, persist common.Settings) {
+	c.settingsLock.Lock()
+	p := c.persister
+	c.settingsLock.Unlock()
+	if p != nil && (clear || len(persist) > 0) {
+		p(clear, persist)
+	}
+}
@@ -27,6 +27,7 @@ type Conn struct {
 	// SPDY/3.1
 	connectionWindowLock      sync.Mutex
 	dataBuffer                []*frames.DATA // used to store frames witheld for flow control.
+	windowGrown               chan struct{}  // signalled when connectionWindowSize grows.
 	connectionWindowSize      int64
 	initialWindowSizeThere    uint32
 	connectionWindowSizeThere int64
@@ -41,6 +42,7 @@ type Conn struct {
 	streams     map[common.StreamID]common.Stream // map of active streams.
 	streamsLock sync.Mutex                        // protects streams.
 	output      [8]chan common.Frame              // one output channel per priority level.
+	outputLock  sync.RWMutex                      // held to close output, read-held to send without panicking.
 
 	// other state
 	compressor       common.Compressor              // outbound compression state.
@@ -48,7 +50,10 @@ type Conn struct {
 	receivedSettings common.Settings                // settings sent by client.
 	goawayReceived   bool                           // goaway has been received.
 	goawaySent       bool                           // goaway has been sent.
-	goawayLock       sync.Mutex                     // protects goawaySent and goawayReceived.
+	goawayFromPeer   bool                           // goaway has been received from the other endpoint.
+	lastGoodStreamID common.StreamID                // last stream processed by the other endpoint, from its goaway.
+	draining         bool                           // close once the last stream is done.
+	goawayLock       sync.Mutex                     // protects goawaySent, goawayReceived and the above.
 	numBenignErrors  int                            // number of non-serious errors encountered.
 	readTimeout      time.Duration                  // optional timeout for network reads.
 	writeTimeout     time.Duration                  // optional timeout for network writes.
@@ -57,9 +62,15 @@ type Conn struct {
 	certificates     map[uint16][]*x509.Certificate // certificates from CREDENTIALs and TLS handshake.
 	flowControl      common.FlowControl             // flow control module.
 	flowControlLock  sync.Mutex                     // protects flowControl.
+	stats            common.Stats                   // activity on the connection.
+	observer         common.FrameObserver           // observer of the frames, if any.
+	observerLock     sync.Mutex                     // protects observer.
+	settings         common.Settings                // settings to send, replacing the defaults.
+	persister        func(bool, common.Settings)    // called with the settings to persist.
+	settingsLock     sync.Mutex                     // protects settings and persister.
 
 	// SPDY features
-	pings                map[uint32]chan<- bool                // response channel for pings.
+	pings                map[uint32]*pendingPing               // pings awaiting their reply.
 	pingsLock            sync.Mutex                            // protects pings.
 	nextPingID           uint32                                // next outbound ping ID.
 	nextPingIDLock       sync.Mutex                            // protects nextPingID.
@@ -87,6 +98,12 @@ type Conn struct {
 	shutdownError error         // error that caused shutdown if non-nil
 }
 
+// pendingPing is a PING awaiting its reply.
+type pendingPing struct {
+	sent time.Time
+	rtt  chan time.Duration
+}
+
 // NewConn produces an initialised spdy3 connection.
 func NewConn(conn net.Conn, server *http.Server, subversion int) *Conn {
 	out := new(Conn)
@@ -109,9 +126,10 @@ func NewConn(conn net.Conn, server *http.Server, subversion int) *Conn {
 	out.output[5] = make(chan common.Frame)
 	out.output[6] = make(chan common.Frame)
 	out.output[7] = make(chan common.Frame)
-	out.pings = make(map[uint32]chan<- bool)
-	out.compressor = common.NewCompressor(3)
-	out.decompressor = common.NewDecompressor(3)
+	out.windowGrown = make(chan struct{}, 1)
+	out.pings = make(map[uint32]*pendingPing)
+	out.compressor = out.stats.Compressor(common.NewCompressor(3), 3)
+	out.decompressor = out.stats.Decompressor(common.NewDecompressor(3), 3)
 	out.receivedSettings = make(common.Settings)
 	out.lastPushStreamID = 0
 	out.lastRequestStreamID = 0
@@ -159,8 +177,16 @@ func NewConn(conn net.Conn, server *http.Server, subversion int) *Conn {
 		out.pushRequests = make(map[common.StreamID]*http.Request)
 		out.init = func() {
 			// Initialise the connection by sending the connection settings.
+			// The window is that of the flow control, which
+			// may have been replaced since NewConn.
+			out.flowControlLock.Lock()
+			window := out.flowControl.InitialWindowSize()
+			out.flowControlLock.Unlock()
 			settings := new(frames.SETTINGS)
-			settings.Settings = defaultClientSettings(common.DEFAULT_STREAM_LIMIT)
+			settings.Settings = defaultClientSettings(common.DEFAULT_STREAM_LIMIT, window)
+			out.settingsLock.Lock()
+			settings.Settings.Update(out.settings)
+			out.settingsLock.Unlock()
 			out.output[0] <- settings
 		}
 		out.flowControl = DefaultFlowControl(common.DEFAULT_INITIAL_CLIENT_WINDOW_SIZE)
@@ -171,7 +197,7 @@ func NewConn(conn net.Conn, server *http.Server, subversion int) *Conn {
 	}
 
 	if subversion == 1 {
-		out.initialWindowSizeThere = out.flowControl.InitialWindowSize()
+		out.initialWindowSizeThere = out.windowThere(out.flowControl)
 		out.connectionWindowSizeThere = int64(out.initialWindowSizeThere)
 	}
 	return out
@@ -9,6 +9,7 @@ import (
 	"github.com/albus01/ibenchmark/gospdy/common"
 	"github.com/albus01/ibenchmark/gospdy/spdy3/frames"
 	"sync"
+	"time"
 )
 
 type DefaultFlowControl uint32
@@ -39,6 +40,7 @@ type flowControl struct {
 	sent                uint32
 	buffer              [][]byte
 	constrained         bool
+	stalled             time.Time // when the stream last became constrained.
 	initialWindowThere  uint32
 	transferWindowThere int64
 	flowControl         common.FlowControl
@@ -67,8 +69,8 @@ func (s *PushStream) AddFlowControl(f common.FlowControl) {
 	s.flow.transferWindow = int64(initialWindow)
 	s.flow.stream = s
 	s.flow.flowControl = f
-	s.flow.initialWindowThere = f.InitialWindowSize()
-	s.flow.transferWindowThere = int64(s.flow.transferWindowThere)
+	s.flow.initialWindowThere = s.conn.windowThere(f)
+	s.flow.transferWindowThere = int64(s.flow.initialWindowThere)
 }
 
 // AddFlowControl initialises flow control for
@@ -93,7 +95,7 @@ func (s *RequestStream) AddFlowControl(f common.FlowControl) {
 	s.flow.transferWindow = int64(initialWindow)
 	s.flow.stream = s
 	s.flow.flowControl = f
-	s.flow.initialWindowThere = f.InitialWindowSize()
+	s.flow.initialWindowThere = s.conn.windowThere(f)
 	s.flow.transferWindowThere = int64(s.flow.initialWindowThere)
 }
 
@@ -119,7 +121,7 @@ func (s *ResponseStream) AddFlowControl(f common.FlowControl) {
 	s.flow.transferWindow = int64(initialWindow)
 	s.flow.stream = s
 	s.flow.flowControl = f
-	s.flow.initialWindowThere = f.InitialWindowSize()
+	s.flow.initialWindowThere = s.conn.windowThere(f)
 	s.flow.transferWindowThere = int64(s.flow.initialWindowThere)
 }
 
@@ -140,13 +142,11 @@ func (f *flowControl) CheckInitialWindow() {
 	f.conn.initialWindowSizeLock.Unlock()
 
 	if f.initialWindow != newWindow {
-		if f.initialWindow > newWindow {
-			f.transferWindow = int64(newWindow - f.sent)
-		} else if f.initialWindow < newWindow {
-			f.transferWindow += int64(newWindow - f.initialWindow)
-		}
+		// The window moves by the change, which can leave
+		// it negative if more than the new window was sent.
+		f.transferWindow += int64(newWindow) - int64(f.initialWindow)
 		if f.transferWindow <= 0 {
-			f.constrained = true
+			f.stall()
 		}
 		f.initialWindow = newWindow
 	}
@@ -165,35 +165,40 @@ func (f *flowControl) Close() {
 // sent with a single flush.
 func (f *flowControl) Flush() {
 	f.CheckInitialWindow()
-	if !f.constrained || f.transferWindow == 0 {
+	if !f.constrained || f.transferWindow <= 0 {
 		return
 	}
 
 	out := make([]byte, 0, f.transferWindow)
 	left := f.transferWindow
-	for i := 0; i < len(f.buffer); i++ {
-		if l := int64(len(f.buffer[i])); l <= left {
-			out = append(out, f.buffer[i]...)
+	for len(f.buffer) > 0 && left > 0 {
+		if l := int64(len(f.buffer[0])); l <= left {
+			out = append(out, f.buffer[0]...)
 			left -= l
 			f.buffer = f.buffer[1:]
 		} else {
-			out = append(out, f.buffer[i][:left]...)
-			f.buffer[i] = f.buffer[i][left:]
+			out = append(out, f.buffer[0][:left]...)
+			f.buffer[0] = f.buffer[0][left:]
 			left = 0
 		}
-
-		if left == 0 {
-			break
-		}
 	}
 
 	f.transferWindow -= int64(len(out))
 
 	if f.transferWindow > 0 {
 		f.constrained = false
+		if !f.stalled.IsZero() {
+			f.conn.stats.Stall(time.Since(f.stalled))
+			f.stalled = time.Time{}
+		}
 		debug.Printf("Stream %d is no longer constrained.\n", f.streamID)
 	}
 
+	// An empty DATA frame without FIN is invalid.
+	if len(out) == 0 {
+		return
+	}
+
 	dataFrame := new(frames.DATA)
 	dataFrame.StreamID = f.streamID
 	dataFrame.Data = out
@@ -201,11 +206,27 @@ func (f *flowControl) Flush() {
 	f.output <- dataFrame
 }
 
+// stall marks the stream as constrained by its
+// transfer window.
+func (f *flowControl) stall() {
+	if !f.constrained {
+		f.stalled = time.Now()
+	}
+	f.constrained = true
+}
+
 // Paused indicates whether there is data buffered.
 // A Stream should not be closed until after the
 // last data has been sent and then Paused returns
 // false.
 func (f *flowControl) Paused() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.paused()
+}
+
+// paused is Paused with f held.
+func (f *flowControl) paused() bool {
 	f.CheckInitialWindow()
 	return f.constrained
 }
@@ -266,7 +287,7 @@ func (f *flowControl) UpdateWindow(deltaWindowSize uint32) error {
 func (f *flowControl) Wait() error {
 	f.Lock()
 	f.Flush()
-	if !f.Paused() {
+	if !f.paused() {
 		f.Unlock()
 		return nil
 	}
@@ -276,13 +297,18 @@ func (f *flowControl) Wait() error {
 		return errors.New("waiting for flow control twice")
 	}
 
-	f.waiting = make(chan bool)
+	// Buffered, so that an update between the checks
+	// below is not lost.
+	f.waiting = make(chan bool, 1)
 	f.Unlock()
 
 	for {
 		<-f.waiting
+		f.Lock()
 		f.Flush()
-		if !f.Paused() {
+		paused := f.paused()
+		f.Unlock()
+		if !paused {
 			return nil
 		}
 	}
@@ -303,12 +329,12 @@ func (f *flowControl) Write(data []byte) (int, error) {
 	}
 
 	// Transfer window processing.
+	f.Lock()
 	f.CheckInitialWindow()
 	if f.constrained {
 		f.Flush()
 	}
 
-	f.Lock()
 	var window uint32
 	if f.transferWindow < 0 {
 		window = 0
@@ -329,7 +355,7 @@ func (f *flowControl) Write(data []byte) (int, error) {
 	if constrained {
 		f.buffer = append(f.buffer, data[window:])
 		data = data[:window]
-		f.constrained = true
+		f.stall()
 		debug.Printf("Stream %d is now constrained.\n", f.streamID)
 	}
 	f.Unlock()
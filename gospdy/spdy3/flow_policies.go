@@ -0,0 +1,136 @@
+/*
+   Copyright 2015 Albus <albus@shaheng.me>.
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package spdy3
+
+import (
+	"github.com/albus01/ibenchmark/gospdy/common"
+	"sync"
+	"time"
+)
+
+// FixedFlowControl keeps a window of the given size,
+// topping it up after every DATA frame, so the sender
+// is never held back by a window it has used.
+type FixedFlowControl uint32
+
+func (f FixedFlowControl) InitialWindowSize() uint32 {
+	return uint32(f)
+}
+
+func (f FixedFlowControl) ReceiveData(_ common.StreamID, initialWindowSize uint32, newWindowSize int64) uint32 {
+	if newWindowSize < int64(initialWindowSize) {
+		return uint32(int64(initialWindowSize) - newWindowSize)
+	}
+
+	return 0
+}
+
+// StarvedFlowControl keeps a window of the given size,
+// which is only regrown once it has been used up. With
+// a small size, this starves the sender, which must
+// wait for a WINDOW_UPDATE after every few bytes.
+type StarvedFlowControl uint32
+
+func (f StarvedFlowControl) InitialWindowSize() uint32 {
+	return uint32(f)
+}
+
+func (f StarvedFlowControl) ReceiveData(_ common.StreamID, initialWindowSize uint32, newWindowSize int64) uint32 {
+	if newWindowSize <= 0 {
+		return uint32(int64(initialWindowSize) - newWindowSize)
+	}
+
+	return 0
+}
+
+// AutoFlowControl sizes the window to twice the
+// bandwidth-delay product of the connection, between
+// its initial and maximum sizes. The bandwidth and
+// delay are given to Sample, typically after each
+// PING. While the window limits the throughput, the
+// estimate equals the window, so the window doubles
+// until it no longer does.
+//
+// An AutoFlowControl holds the state of a single
+// connection, and is safe for concurrent use.
+type AutoFlowControl struct {
+	mu       sync.Mutex
+	initial  uint32
+	max      uint32
+	window   uint32
+	sampled  time.Time
+	received int64
+}
+
+// NewAutoFlowControl returns an AutoFlowControl which
+// starts with the initial window size and grows to at
+// most max, or the largest allowed window if max is 0.
+func NewAutoFlowControl(initial, max uint32) *AutoFlowControl {
+	if max == 0 || max >= common.MAX_TRANSFER_WINDOW_SIZE {
+		max = common.MAX_TRANSFER_WINDOW_SIZE - 1
+	}
+	if initial > max {
+		initial = max
+	}
+	return &AutoFlowControl{initial: initial, max: max, window: initial}
+}
+
+func (f *AutoFlowControl) InitialWindowSize() uint32 {
+	return f.initial
+}
+
+func (f *AutoFlowControl) ReceiveData(_ common.StreamID, _ uint32, newWindowSize int64) uint32 {
+	window := int64(f.Window())
+	if newWindowSize < window/2 {
+		return uint32(window - newWindowSize)
+	}
+
+	return 0
+}
+
+// Window returns the current window size.
+func (f *AutoFlowControl) Window() uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.window
+}
+
+// Sample updates the window from the round-trip time
+// of the connection and the DATA bytes it has received
+// in all. The first sample only sets the starting point.
+func (f *AutoFlowControl) Sample(rtt time.Duration, received int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(f.sampled)
+	if f.sampled.IsZero() || elapsed <= 0 || rtt <= 0 {
+		f.sampled, f.received = now, received
+		return
+	}
+
+	bandwidth := float64(received-f.received) / elapsed.Seconds()
+	f.sampled, f.received = now, received
+	target := 2 * bandwidth * rtt.Seconds()
+	switch {
+	case target < float64(f.initial):
+		f.window = f.initial
+	case target > float64(f.max):
+		f.window = f.max
+	default:
+		f.window = uint32(target)
+	}
+	debug.Printf("Flow: Window tuned to %d bytes.\n", f.window)
+}
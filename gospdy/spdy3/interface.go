@@ -33,6 +33,7 @@ func (c *Conn) refreshReadTimeout() {
 	c.timeoutLock.Unlock()
 }
 
+// refreshWriteTimeout is called with connLock held.
 func (c *Conn) refreshWriteTimeout() {
 	c.timeoutLock.Lock()
 	if d := c.writeTimeout; d != 0 && c.conn != nil {
@@ -4,6 +4,7 @@ import (
 	"github.com/albus01/ibenchmark/gospdy/common"
 	"github.com/albus01/ibenchmark/gospdy/spdy3/frames"
 	"runtime"
+	"time"
 )
 
 // readFrames is the main processing loop, where frames
@@ -45,6 +46,7 @@ func (c *Conn) readFrames() {
 		}
 
 		debug.Println(frame) // Print frame once the content's been decompressed.
+		c.noteFrame(false, frame)
 
 		if c.processFrame(frame) {
 			return
@@ -85,11 +87,20 @@ func (c *Conn) send() {
 		// Process connection-level flow control.
 		if c.Subversion > 0 {
 			c.connectionWindowLock.Lock()
-			if frame, ok := frame.(*frames.DATA); ok {
-				size := int64(len(frame.Data))
+			if data, ok := frame.(*frames.DATA); ok {
+				// Keep DATA in order behind any already buffered.
+				if len(c.dataBuffer) > 0 && c.dataBuffer[0] == data {
+					c.dataBuffer = c.dataBuffer[1:]
+				} else if len(c.dataBuffer) > 0 {
+					c.dataBuffer = append(c.dataBuffer, data)
+					c.connectionWindowLock.Unlock()
+					continue
+				}
+
+				size := int64(len(data.Data))
 				constrained := false
 				sending := size
-				if sending > c.connectionWindowSize {
+				if sending > c.connectionWindowSize && size > 0 {
 					sending = c.connectionWindowSize
 					constrained = true
 				}
@@ -100,33 +111,39 @@ func (c *Conn) send() {
 				c.connectionWindowSize -= sending
 
 				if constrained {
-					// Chop off what we can send now.
-					partial := new(frames.DATA)
-					partial.Flags = frame.Flags
-					partial.StreamID = frame.StreamID
-					partial.Data = make([]byte, int(sending))
-					copy(partial.Data, frame.Data[:sending])
-					frame.Data = frame.Data[sending:]
-
-					// Buffer this frame and try again.
-					if c.dataBuffer == nil {
-						c.dataBuffer = []*frames.DATA{frame}
-					} else {
-						buffer := make([]*frames.DATA, 1, len(c.dataBuffer)+1)
-						buffer[0] = frame
-						buffer = append(buffer, c.dataBuffer...)
-						c.dataBuffer = buffer
+					// Buffer what can't be sent now, and send
+					// what can, if any, without the FIN.
+					rest := new(frames.DATA)
+					rest.Flags = data.Flags
+					rest.StreamID = data.StreamID
+					rest.Data = data.Data[sending:]
+					c.dataBuffer = append([]*frames.DATA{rest}, c.dataBuffer...)
+					if sending == 0 {
+						c.connectionWindowLock.Unlock()
+						continue
 					}
 
+					partial := new(frames.DATA)
+					partial.StreamID = data.StreamID
+					partial.Data = data.Data[:sending]
 					frame = partial
 				}
 			}
 			c.connectionWindowLock.Unlock()
 		}
 
+		// Hold connLock while the compressor and the
+		// connection are used, shutdown clears them.
+		c.connLock.Lock()
+		if c.conn == nil {
+			c.connLock.Unlock()
+			return
+		}
+
 		// Compress any name/value header blocks.
 		err := frame.Compress(c.compressor)
 		if err != nil {
+			c.connLock.Unlock()
 			log.Printf("Error in compression: %v (type %T).\n", err, frame)
 			c.Close()
 			return
@@ -138,10 +155,64 @@ func (c *Conn) send() {
 		// Leave the specifics of writing to the
 		// connection up to the frame.
 		c.refreshWriteTimeout()
-		if _, err = frame.WriteTo(c.conn); err != nil {
+		_, err = frame.WriteTo(c.conn)
+		c.connLock.Unlock()
+		if err != nil {
 			c.handleReadWriteError(err)
 			return
 		}
+		c.noteFrame(true, frame)
+		c.noteSent(frame)
+	}
+}
+
+// noteSent tells the request stream whose final frame
+// has been written that its request is sent.
+func (c *Conn) noteSent(frame common.Frame) {
+	var id common.StreamID
+	switch frame := frame.(type) {
+	case *frames.SYN_STREAM:
+		if !frame.Flags.FIN() {
+			return
+		}
+		id = frame.StreamID
+	case *frames.DATA:
+		if !frame.Flags.FIN() {
+			return
+		}
+		id = frame.StreamID
+	default:
+		return
+	}
+	c.streamsLock.Lock()
+	stream, ok := c.streams[id].(*RequestStream)
+	c.streamsLock.Unlock()
+	if ok {
+		stream.markSent()
+	}
+}
+
+// noteFrame adds a frame sent or received to the
+// connection's statistics, and passes it to the
+// frame observer, if any.
+func (c *Conn) noteFrame(sent bool, frame common.Frame) {
+	c.observerLock.Lock()
+	observer := c.observer
+	c.observerLock.Unlock()
+	if observer != nil {
+		dir := common.Inbound
+		if sent {
+			dir = common.Outbound
+		}
+		observer.ObserveFrame(time.Now(), dir, frame)
+	}
+
+	c.stats.Frame(sent, frame.Name())
+	switch frame := frame.(type) {
+	case *frames.DATA:
+		c.stats.Data(sent, len(frame.Data))
+	case *frames.RST_STREAM:
+		c.stats.RSTStream(sent, frame.Status)
 	}
 }
 
@@ -155,23 +226,17 @@ func (c *Conn) selectFrameToSend(prioritise bool) (frame common.Frame) {
 		return nil
 	}
 
-	// Try buffered DATA frames first.
+	// Try buffered DATA frames first, send takes
+	// them off the buffer, splitting them again if
+	// the window is still too small.
 	if c.Subversion > 0 {
-		if c.dataBuffer != nil {
-			if len(c.dataBuffer) == 0 {
-				c.dataBuffer = nil
-			} else {
-				first := c.dataBuffer[0]
-				if c.connectionWindowSize >= int64(8+len(first.Data)) {
-					if len(c.dataBuffer) > 1 {
-						c.dataBuffer = c.dataBuffer[1:]
-					} else {
-						c.dataBuffer = nil
-					}
-					return first
-				}
-			}
+		c.connectionWindowLock.Lock()
+		if len(c.dataBuffer) > 0 && (c.connectionWindowSize > 0 || len(c.dataBuffer[0].Data) == 0) {
+			first := c.dataBuffer[0]
+			c.connectionWindowLock.Unlock()
+			return first
 		}
+		c.connectionWindowLock.Unlock()
 	}
 
 	// Then in priority order.
@@ -214,6 +279,8 @@ func (c *Conn) selectFrameToSend(prioritise bool) (frame common.Frame) {
 		return frame
 	case frame = <-c.output[7]:
 		return frame
+	case <-c.windowGrown:
+		return c.selectFrameToSend(prioritise)
 	case _ = <-c.stop:
 		return nil
 	}
@@ -6,6 +6,7 @@ import (
 	"github.com/albus01/ibenchmark/gospdy/spdy3/frames"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 // processFrame handles the initial processing of the given
@@ -49,6 +50,9 @@ func (c *Conn) processFrame(frame common.Frame) bool {
 		c.handleRstStream(frame)
 
 	case *frames.SETTINGS:
+		if c.server == nil {
+			c.persistSettings(frame.Flags.CLEAR_SETTINGS(), frame.Settings.Persist())
+		}
 		for _, setting := range frame.Settings {
 			c.receivedSettings[setting.ID] = setting
 			switch setting.ID {
@@ -83,12 +87,12 @@ func (c *Conn) processFrame(frame common.Frame) bool {
 		c.nextPingIDLock.Unlock()
 		if frame.PingID&1 == next&1 {
 			c.pingsLock.Lock()
-			if c.check(c.pings[frame.PingID] == nil, "Ignored unrequested PING %d", frame.PingID) {
+			p := c.pings[frame.PingID]
+			if c.check(p == nil, "Ignored unrequested PING %d", frame.PingID) {
 				c.pingsLock.Unlock()
 				return false
 			}
-			c.pings[frame.PingID] <- true
-			close(c.pings[frame.PingID])
+			p.rtt <- time.Since(p.sent)
 			delete(c.pings, frame.PingID)
 			c.pingsLock.Unlock()
 		} else {
@@ -97,20 +101,30 @@ func (c *Conn) processFrame(frame common.Frame) bool {
 		}
 
 	case *frames.GOAWAY:
+		// Record the GOAWAY before closing the streams, so their
+		// requesters can tell whether they were refused.
 		lastProcessed := frame.LastGoodStreamID
+		c.goawayLock.Lock()
+		c.goawayReceived = true
+		c.goawayFromPeer = true
+		c.lastGoodStreamID = lastProcessed
+		c.goawayLock.Unlock()
+
+		// Stream.Close removes the stream from the map, so
+		// collect the streams first.
+		var refused []common.Stream
 		c.streamsLock.Lock()
 		for streamID, stream := range c.streams {
 			if streamID&1 == c.oddity && streamID > lastProcessed {
 				// Stream is locally-sent and has not been processed.
 				// TODO: Inform the server that the push has not been successful.
-				stream.Close()
+				refused = append(refused, stream)
 			}
 		}
 		c.streamsLock.Unlock()
-		c.shutdownError = frame
-		c.goawayLock.Lock()
-		c.goawayReceived = true
-		c.goawayLock.Unlock()
+		for _, stream := range refused {
+			stream.Close()
+		}
 
 	case *frames.HEADERS:
 		c.handleHeaders(frame)
@@ -368,6 +382,7 @@ func (c *Conn) handleRequest(frame *frames.SYN_STREAM) {
 
 	c.streamsLock.Lock()
 	c.streams[sid] = nextStream
+	c.stats.Streams(len(c.streams))
 	c.streamsLock.Unlock()
 	c.lastRequestStreamIDLock.Lock()
 	c.lastRequestStreamID = sid
@@ -505,6 +520,10 @@ func (c *Conn) handleWindowUpdate(frame *frames.WINDOW_UPDATE) {
 			return
 		}
 		c.connectionWindowSize += int64(delta)
+		select {
+		case c.windowGrown <- struct{}{}:
+		default:
+		}
 		return
 	}
 
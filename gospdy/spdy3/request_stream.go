@@ -33,6 +33,8 @@ type RequestStream struct {
 	responseCode int
 	stop         <-chan bool
 	finished     chan struct{}
+	sent         chan struct{}
+	sentOnce     sync.Once
 }
 
 func NewRequestStream(conn *Conn, streamID common.StreamID, output chan<- common.Frame) *RequestStream {
@@ -45,6 +47,7 @@ func NewRequestStream(conn *Conn, streamID common.StreamID, output chan<- common
 	out.state.CloseHere()
 	out.header = make(http.Header)
 	out.finished = make(chan struct{})
+	out.sent = make(chan struct{})
 	out.headerChan = make(chan func(), 5)
 	go out.processFrames()
 	return out
@@ -128,6 +131,15 @@ func (s *RequestStream) shutdown() {
 	if s.flow != nil {
 		s.flow.Close()
 	}
+
+	// Free the slot before Run returns, so the caller
+	// can start another stream straight away.
+	s.conn.requestStreamLimit.Close()
+	s.conn.streamsLock.Lock()
+	delete(s.conn.streams, s.streamID)
+	s.conn.streamsLock.Unlock()
+	s.conn.closeIfDrained()
+
 	select {
 	case <-s.finished:
 	default:
@@ -138,16 +150,11 @@ func (s *RequestStream) shutdown() {
 	default:
 		close(s.headerChan)
 	}
-	s.conn.requestStreamLimit.Close()
 	s.output = nil
 	s.Request = nil
 	s.Receiver = nil
 	s.header = nil
 	s.stop = nil
-
-	s.conn.streamsLock.Lock()
-	delete(s.conn.streams, s.streamID)
-	s.conn.streamsLock.Unlock()
 }
 
 /**********
@@ -253,6 +260,16 @@ func (s *RequestStream) StreamID() common.StreamID {
 	return s.streamID
 }
 
+// Sent is closed once the last frame of the request has
+// been written to the connection.
+func (s *RequestStream) Sent() <-chan struct{} {
+	return s.sent
+}
+
+func (s *RequestStream) markSent() {
+	s.sentOnce.Do(func() { close(s.sent) })
+}
+
 func (s *RequestStream) closed() bool {
 	if s.conn == nil || s.state == nil || s.Receiver == nil {
 		return true
@@ -113,21 +113,24 @@ func (c *Conn) Request(request *http.Request, receiver common.Receiver, priority
 	if syn.StreamID > common.MAX_STREAM_ID {
 		return nil, errors.New("Error: All client streams exhausted.")
 	}
-	c.output[0] <- syn
-	for _, frame := range body {
-		frame.StreamID = syn.StreamID
-		c.output[0] <- frame
-	}
 
-	// Create the request stream.
+	// Create the request stream before sending, as the reply
+	// may arrive before the frames have all been queued.
 	out := NewRequestStream(c, syn.StreamID, c.output[0])
 	out.Request = request
 	out.Receiver = receiver
 	out.AddFlowControl(c.flowControl)
 	c.streamsLock.Lock()
 	c.streams[syn.StreamID] = out // Store in the connection map.
+	c.stats.Streams(len(c.streams))
 	c.streamsLock.Unlock()
 
+	c.output[0] <- syn
+	for _, frame := range body {
+		frame.StreamID = syn.StreamID
+		c.output[0] <- frame
+	}
+
 	return out, nil
 }
 
@@ -143,5 +146,8 @@ func (c *Conn) RequestResponse(request *http.Request, receiver common.Receiver,
 	// Let the request run its course.
 	stream.Run()
 
+	if err := c.StreamError(stream.StreamID()); err != nil {
+		return nil, err
+	}
 	return res.Response(), c.shutdownError
 }
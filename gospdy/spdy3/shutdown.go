@@ -97,19 +97,25 @@ func (c *Conn) shutdown() {
 		close(c.stop)
 	}
 
+	// Closing the connection first ends a write in progress,
+	// which holds connLock.
 	if c.conn != nil {
 		c.conn.Close()
-		c.conn = nil
 	}
 
+	c.connLock.Lock()
+	c.conn = nil
 	if c.compressor != nil {
 		c.compressor.Close()
 		c.compressor = nil
 	}
+	c.connLock.Unlock()
 	c.decompressor = nil
 
 	c.pushedResources = nil
 
+	c.outputLock.Lock()
+	defer c.outputLock.Unlock()
 	for _, stream := range c.output {
 		select {
 		case _, ok := <-stream:
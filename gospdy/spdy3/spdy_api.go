@@ -7,11 +7,72 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // Ping is used by spdy.PingServer and spdy.PingClient to send
-// SPDY PINGs.
+// SPDY PINGs. The channel receives true when the reply arrives,
+// or false if none does within common.DEFAULT_PING_TIMEOUT.
 func (c *Conn) Ping() (<-chan bool, error) {
+	wait, err := c.ping()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan bool, 1)
+	go func() {
+		_, err := wait(common.DEFAULT_PING_TIMEOUT)
+		ch <- err == nil
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// PingRTT sends a PING and returns its round-trip time. It
+// fails with ErrPingTimeout if no reply arrives within timeout.
+func (c *Conn) PingRTT(timeout time.Duration) (time.Duration, error) {
+	wait, err := c.ping()
+	if err != nil {
+		return 0, err
+	}
+	return wait(timeout)
+}
+
+// Keepalive sends a PING every interval until the connection
+// closes, and closes it as dead once a PING gets no reply within
+// timeout. pinged, if non-nil, is called with the result of each
+// PING.
+func (c *Conn) Keepalive(interval, timeout time.Duration, pinged func(rtt time.Duration, err error)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+			rtt, err := c.PingRTT(timeout)
+			if c.Closed() {
+				return
+			}
+			if err != nil {
+				debug.Printf("PING unanswered after %v, closing the connection.\n", timeout)
+				c.Close()
+			}
+			if pinged != nil {
+				pinged(rtt, err)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// ping sends a PING, wait returns its round-trip time.
+func (c *Conn) ping() (wait func(timeout time.Duration) (time.Duration, error), err error) {
 	if c.Closed() {
 		return nil, errors.New("Error: Conn has been closed.")
 	}
@@ -30,14 +91,56 @@ func (c *Conn) Ping() (<-chan bool, error) {
 	}
 	c.nextPingIDLock.Unlock()
 
+	// Register the PING before sending it, the reply
+	// can come straight away.
 	ping.PingID = pid
-	c.output[0] <- ping
-	ch := make(chan bool, 1)
+	p := &pendingPing{sent: time.Now(), rtt: make(chan time.Duration, 1)}
 	c.pingsLock.Lock()
-	c.pings[pid] = ch
+	c.pings[pid] = p
 	c.pingsLock.Unlock()
+	if !c.sendPing(ping) {
+		c.pingsLock.Lock()
+		delete(c.pings, pid)
+		c.pingsLock.Unlock()
+		return nil, common.ErrConnClosed
+	}
 
-	return ch, nil
+	return func(timeout time.Duration) (time.Duration, error) {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		err := common.ErrPingTimeout
+		select {
+		case rtt := <-p.rtt:
+			c.stats.Ping(rtt, nil)
+			return rtt, nil
+		case <-timer.C:
+		case <-c.stop:
+			err = common.ErrConnClosed
+		}
+		c.pingsLock.Lock()
+		delete(c.pings, pid)
+		c.pingsLock.Unlock()
+		c.stats.Ping(0, err)
+		return 0, err
+	}, nil
+}
+
+// sendPing queues a PING, reporting false if the connection
+// has closed.
+func (c *Conn) sendPing(ping *frames.PING) bool {
+	// The output channels are closed on shutdown, after stop,
+	// so they stay open while stop is open and the lock held.
+	c.outputLock.RLock()
+	defer c.outputLock.RUnlock()
+	if c.Closed() {
+		return false
+	}
+	select {
+	case c.output[0] <- ping:
+		return true
+	case <-c.stop:
+		return false
+	}
 }
 
 // Push is used to issue a server push to the client. Note that this cannot be performed
@@ -118,13 +221,149 @@ func (c *Conn) Push(resource string, origin common.Stream) (common.PushStream, e
 	// Store in the connection map.
 	c.streamsLock.Lock()
 	c.streams[newID] = out
+	c.stats.Streams(len(c.streams))
 	c.streamsLock.Unlock()
 
 	return out, nil
 }
 
+// StreamLimit returns the limit on concurrent requests, which the
+// server can set with SETTINGS_MAX_CONCURRENT_STREAMS.
+func (c *Conn) StreamLimit() uint32 {
+	return c.requestStreamLimit.Limit()
+}
+
+// Stats returns a snapshot of the activity on the connection.
+func (c *Conn) Stats() common.ConnStats {
+	return c.stats.Snapshot()
+}
+
+// GoawayReceived indicates whether the server sent GOAWAY,
+// after which the connection takes no new requests.
+func (c *Conn) GoawayReceived() bool {
+	c.goawayLock.Lock()
+	defer c.goawayLock.Unlock()
+	return c.goawayFromPeer
+}
+
+// StreamError returns ErrRefused if the server sent GOAWAY
+// without having processed the stream with the given ID,
+// which can then be sent again on another connection.
+func (c *Conn) StreamError(id common.StreamID) error {
+	c.goawayLock.Lock()
+	refused := c.goawayFromPeer && id > c.lastGoodStreamID
+	c.goawayLock.Unlock()
+	if refused {
+		return common.ErrRefused
+	}
+	return nil
+}
+
+// Drain closes the connection once its remaining streams
+// have finished. No new requests can be made on it, which
+// makes it suitable for a connection that received GOAWAY.
+func (c *Conn) Drain() {
+	c.goawayLock.Lock()
+	c.goawayReceived = true
+	c.draining = true
+	c.goawayLock.Unlock()
+	c.closeIfDrained()
+}
+
+// closeIfDrained closes a draining connection without streams.
+func (c *Conn) closeIfDrained() {
+	c.goawayLock.Lock()
+	draining := c.draining
+	c.goawayLock.Unlock()
+	if !draining {
+		return
+	}
+	c.streamsLock.Lock()
+	done := len(c.streams) == 0
+	c.streamsLock.Unlock()
+	if done {
+		go c.Close()
+	}
+}
+
+// SetFlowControl replaces the flow control of the
+// connection. A client advertises the initial window
+// size of f, so it should be set before Run.
 func (c *Conn) SetFlowControl(f common.FlowControl) {
 	c.flowControlLock.Lock()
 	c.flowControl = f
 	c.flowControlLock.Unlock()
+	c.resetWindowThere()
+}
+
+// windowThere returns the initial window advertised to
+// the other endpoint, that of f unless the SETTINGS of
+// a client replace it with an INITIAL_WINDOW_SIZE.
+func (c *Conn) windowThere(f common.FlowControl) uint32 {
+	if c.server == nil {
+		c.settingsLock.Lock()
+		s := c.settings[common.SETTINGS_INITIAL_WINDOW_SIZE]
+		c.settingsLock.Unlock()
+		if s != nil {
+			return s.Value
+		}
+	}
+	return f.InitialWindowSize()
+}
+
+// resetWindowThere starts the connection window of a
+// client at the advertised size.
+func (c *Conn) resetWindowThere() {
+	if c.server != nil || c.Subversion == 0 {
+		return
+	}
+	c.flowControlLock.Lock()
+	f := c.flowControl
+	c.flowControlLock.Unlock()
+	c.initialWindowSizeThere = c.windowThere(f)
+	c.connectionWindowSizeThere = int64(c.initialWindowSizeThere)
+}
+
+// SetFrameObserver sets the observer of the frames sent
+// and received, nil for none. It should be set before
+// Run, to see the frames which start the connection.
+func (c *Conn) SetFrameObserver(o common.FrameObserver) {
+	c.observerLock.Lock()
+	c.observer = o
+	c.observerLock.Unlock()
+}
+
+// SetSettings sets the SETTINGS sent by a client when
+// the connection starts, replacing the defaults with
+// the same ID. An INITIAL_WINDOW_SIZE replaces the
+// window of the flow control, which the streams are
+// then regrown to. It should be called before Run.
+func (c *Conn) SetSettings(s common.Settings) {
+	settings := make(common.Settings)
+	settings.Update(s)
+	c.settingsLock.Lock()
+	c.settings = settings
+	c.settingsLock.Unlock()
+	c.resetWindowThere()
+}
+
+// SetSettingsPersister sets the function called with
+// the SETTINGS the server asks a client to persist, see
+// common.SettingsConfigurer. It should be called before
+// Run.
+func (c *Conn) SetSettingsPersister(p func(clear bool, persist common.Settings)) {
+	c.settingsLock.Lock()
+	c.persister = p
+	c.settingsLock.Unlock()
+}
+
+// persistSettings passes the SETTINGS to persist to the
+// persister, if any.
+func (c *Conn) persistSettings(clear bool, persist common.Settings) {
+	c.settingsLock.Lock()
+	p := c.persister
+	c.settingsLock.Unlock()
+	if p != nil && (clear || len(persist) > 0) {
+		p(clear, persist)
+	}
 }
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/url"
 	"strings"
@@ -30,9 +31,9 @@ type Transport struct {
 	Proxy func(*http.Request) (*url.URL, error)
 
 	// Dial specifies the dial function for creating TCP
-	// connections.
+	// connections. TLS connections are layered on top of it.
 	// If Dial is nil, net.Dial is used.
-	Dial func(network, addr string) (net.Conn, error) // TODO: use
+	Dial func(network, addr string) (net.Conn, error)
 
 	// TLSClientConfig specifies the TLS configuration to use with
 	// tls.Client. If nil, the default configuration is used.
@@ -63,9 +64,65 @@ type Transport struct {
 	// time does not include the time to read the response body.
 	ResponseHeaderTimeout time.Duration
 
-	spdyConns map[string]common.Conn   // SPDY connections mapped to host:port.
-	tcpConns  map[string]chan net.Conn // Non-SPDY connections mapped to host:port.
-	connLimit map[string]chan struct{} // Used to enforce the TCP conn limit.
+	// IdleTimeout, if non-zero, limits the time a SPDY stream may
+	// go without receiving a frame.
+	IdleTimeout time.Duration
+
+	// Timeout, if non-zero, limits the time of a whole request,
+	// from dialing to the end of the response. It also bounds the
+	// TLS handshake, and the dial if Dial is nil.
+	//
+	// A SPDY stream which runs into one of the timeouts is
+	// cancelled with RST_STREAM CANCEL, and its request fails
+	// with a net.Error whose Timeout method returns true.
+	Timeout time.Duration
+
+	// SessionsPerHost is the number of SPDY sessions which may
+	// be open to each host. Another session is only opened when
+	// none of the open ones is idle, and it takes one of the
+	// MaxIdleConnsPerHost connections, which is raised to match.
+	// If zero, a single session is used.
+	SessionsPerHost int
+
+	// MaxStreamsPerSession, if non-zero, limits the concurrent
+	// streams of each SPDY session below the server's limit. A
+	// request waits while all sessions are at their limit, and
+	// fails with common.ErrNoStreams if the server set a limit
+	// of 0 on all of them.
+	MaxStreamsPerSession int
+
+	// Balance chooses the SPDY session of each request, among
+	// those below their stream limit.
+	Balance Balance
+
+	// Streams, if non-nil, is called with the connection of a
+	// SPDY session and 1 when the session is given a stream, or
+	// -1 when the stream ends. It is called with the Transport's
+	// lock held, in the order the streams start and end.
+	Streams func(conn net.Conn, delta int)
+
+	// PingInterval, if non-zero, makes each SPDY session send a
+	// keepalive PING at this interval. A session whose PING gets
+	// no reply within PingTimeout, or PingInterval if that is
+	// zero, is closed as dead and replaced.
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+
+	// Pinged, if non-nil, is called with the round-trip time of
+	// each keepalive PING, or the error it failed with.
+	Pinged func(rtt time.Duration, err error)
+
+	spdyConns  map[string]*sessionPool  // SPDY connections mapped to host:port.
+	tcpConns   map[string]chan net.Conn // Non-SPDY connections mapped to host:port.
+	connLimit  map[string]chan struct{} // Used to enforce the TCP conn limit.
+	streamDone *sync.Cond               // signalled when a SPDY stream or session ends.
+	goaways    int                      // GOAWAYs received on SPDY connections.
+	retries    int                      // requests sent again after GOAWAY.
+	retired    []common.Conn            // SPDY sessions removed from the pool, yet to close.
+	oldStats   common.ConnStats         // activity of the closed SPDY sessions.
+
+	persisted   map[string]common.Settings // SETTINGS persisted by each host.
+	persistLock sync.Mutex                 // protects persisted.
 
 	// Priority is used to determine the request priority of SPDY
 	// requests. If nil, spdy.DefaultPriority is used.
@@ -81,6 +138,61 @@ type Transport struct {
 	// sent with the server push. See Receiver for more detail on
 	// its methods.
 	PushReceiver common.Receiver
+
+	// FlowControl, if non-nil, returns the flow control of each
+	// new SPDY session, or nil to keep the default. The window it
+	// gives is advertised to the server. See spdy3 for the built-in
+	// policies, an AutoFlowControl is tuned after each keepalive
+	// PING, so it needs PingInterval.
+	FlowControl func() common.FlowControl
+
+	// Settings are sent in the first SETTINGS frame of each new
+	// SPDY session, replacing the defaults with the same ID, with
+	// their flags as given. An INITIAL_WINDOW_SIZE here replaces
+	// the window of FlowControl, both the one advertised and the
+	// one its streams are regrown to, though an AutoFlowControl
+	// still regrows them to its tuned window.
+	//
+	// The SETTINGS which a server asks to persist are sent back,
+	// flagged FLAG_SETTINGS_PERSISTED, on the later sessions to
+	// it, unless Settings has the same ID.
+	Settings common.Settings
+
+	// FrameObserver, if non-nil, is called with the connection
+	// of every new SPDY session, before it starts, and returns
+	// the observer of its frames, or nil. See common.FrameWriter
+	// for one which writes them as JSON lines.
+	FrameObserver func(conn net.Conn) common.FrameObserver
+
+	// Negotiated, if non-nil, is called with the protocol the
+	// server chose by ALPN for every new TLS connection, or ""
+	// if it chose none and HTTP/1.1 is used.
+	Negotiated func(proto string)
+}
+
+// Balance is a policy for spreading requests over the SPDY
+// sessions to a host.
+type Balance int
+
+const (
+	// LeastStreams sends a request over the session with the
+	// fewest open streams.
+	LeastStreams Balance = iota
+
+	// RoundRobin sends requests over the sessions in turn.
+	RoundRobin
+)
+
+// session is a SPDY connection of a Transport.
+type session struct {
+	conn    common.Conn
+	streams int // open streams of the Transport's requests.
+}
+
+// sessionPool holds the SPDY sessions to a host.
+type sessionPool struct {
+	sessions []*session
+	next     int // next session for RoundRobin.
 }
 
 // NewTransport gives a simple initialised Transport.
@@ -88,7 +200,7 @@ func NewTransport(insecureSkipVerify bool) *Transport {
 	return &Transport{
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: insecureSkipVerify,
-			NextProtos:         npn(),
+			NextProtos:         alpn(),
 		},
 	}
 }
@@ -98,20 +210,43 @@ func (t *Transport) dial(u *url.URL) (conn net.Conn, err error) {
 
 	if t.TLSClientConfig == nil {
 		t.TLSClientConfig = &tls.Config{
-			NextProtos: npn(),
+			NextProtos: alpn(),
 		}
 	} else if t.TLSClientConfig.NextProtos == nil {
-		t.TLSClientConfig.NextProtos = npn()
+		t.TLSClientConfig.NextProtos = alpn()
 	}
 
 	// Wait for a connection slot to become available.
 	<-t.connLimit[u.Host]
 
+	dial := t.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: t.Timeout}).Dial
+	}
+
 	switch u.Scheme {
 	case "http":
-		conn, err = net.Dial("tcp", u.Host)
+		conn, err = dial("tcp", u.Host)
 	case "https":
-		conn, err = tls.Dial("tcp", u.Host, t.TLSClientConfig)
+		conn, err = dial("tcp", u.Host)
+		if err == nil {
+			config := t.TLSClientConfig
+			if config.ServerName == "" {
+				config = config.Clone()
+				config.ServerName, _, _ = net.SplitHostPort(u.Host)
+			}
+			tlsConn := tls.Client(conn, config)
+			conn = tlsConn
+			if t.Timeout > 0 {
+				tlsConn.SetDeadline(time.Now().Add(t.Timeout))
+			}
+			if err = tlsConn.Handshake(); err != nil {
+				conn.Close()
+				conn = nil
+			} else {
+				tlsConn.SetDeadline(time.Time{})
+			}
+		}
 	default:
 		err = errors.New(fmt.Sprintf("Error: URL has invalid scheme %q.", u.Scheme))
 	}
@@ -125,9 +260,23 @@ func (t *Transport) dial(u *url.URL) (conn net.Conn, err error) {
 }
 
 // doHTTP is used to process an HTTP(S) request, using the TCP connection pool.
-func (t *Transport) doHTTP(conn net.Conn, req *http.Request) (*http.Response, error) {
+func (t *Transport) doHTTP(conn net.Conn, req *http.Request, start time.Time) (*http.Response, error) {
 	debug.Printf("Requesting %q over HTTP.\n", req.URL.String())
 
+	// Apply the timeouts, the pooled connections get new
+	// deadlines with every request.
+	var deadline time.Time
+	if t.Timeout > 0 {
+		deadline = start.Add(t.Timeout)
+	}
+	conn.SetDeadline(deadline)
+	if t.ResponseHeaderTimeout > 0 {
+		header := time.Now().Add(t.ResponseHeaderTimeout)
+		if deadline.IsZero() || header.Before(deadline) {
+			conn.SetReadDeadline(header)
+		}
+	}
+
 	// Create the HTTP ClientConn, which handles the
 	// HTTP details.
 	httpConn := httputil.NewClientConn(conn, nil)
@@ -135,6 +284,7 @@ func (t *Transport) doHTTP(conn net.Conn, req *http.Request) (*http.Response, er
 	if err != nil {
 		return nil, err
 	}
+	conn.SetReadDeadline(deadline)
 
 	if !res.Close {
 		t.tcpConns[req.URL.Host] <- conn
@@ -153,7 +303,13 @@ func (t *Transport) doHTTP(conn net.Conn, req *http.Request) (*http.Response, er
 // RoundTrip handles the actual request; ensuring a connection is
 // made, determining which protocol to use, and performing the
 // request.
+//
+// When a SPDY server sends GOAWAY, its connection is replaced by
+// a new one. Requests which had not been sent yet, and idempotent
+// requests the server refused, are then sent again, at most
+// MaxGoawayRetries times.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
 	u := req.URL
 	// Make sure the URL host contains the port.
 	if !strings.Contains(u.Host, ":") {
@@ -165,14 +321,86 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 			u.Host += ":443"
 		}
 	}
+	for attempt := 0; ; attempt++ {
+		res, s, err := t.roundTrip(req, start)
+		if s == nil || !t.retry(req, s, err, attempt) {
+			return res, err
+		}
+	}
+}
+
+// MaxGoawayRetries limits how often a request is sent again
+// after GOAWAY.
+const MaxGoawayRetries = 3
+
+// idempotent reports whether a request with the given method
+// can be sent twice.
+func idempotent(method string) bool {
+	switch method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// retry reports whether a request, which failed with err on the
+// SPDY session s, should be sent again.
+func (t *Transport) retry(req *http.Request, s *session, err error, attempt int) bool {
+	var again bool
+	switch err {
+	case common.ErrGoaway:
+		// The request was never sent.
+		again = true
+	case common.ErrRefused:
+		// The server did not process the request.
+		again = idempotent(req.Method)
+	default:
+		return false
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.retire(req.URL.Host, s)
+	if !again || attempt >= MaxGoawayRetries {
+		return false
+	}
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return false
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return false
+		}
+		req.Body = body
+	}
+	t.retries++
+	return true
+}
+
+// roundTrip sends the request once, s is the SPDY session it
+// used, if any.
+func (t *Transport) roundTrip(req *http.Request, start time.Time) (*http.Response, *session, error) {
+	u := req.URL
 	//fmt.Println("roundtrip process req start")
-	conn, tcpConn, err := t.process(req)
+	s, tcpConn, err := t.process(req)
 	//fmt.Println("roundtrip process req end")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+
+	// Tell a client trace which connection carries the request.
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && trace.GotConn != nil {
+		if tcpConn != nil {
+			trace.GotConn(httptrace.GotConnInfo{Conn: tcpConn})
+		} else {
+			trace.GotConn(httptrace.GotConnInfo{Conn: s.conn.Conn()})
+		}
+	}
+
 	if tcpConn != nil {
-		return t.doHTTP(tcpConn, req)
+		res, err := t.doHTTP(tcpConn, req, start)
+		return res, nil, err
 	}
 
 	// The connection has now been established.
@@ -187,10 +415,360 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		priority = common.DefaultPriority(req.URL)
 	}
 
-	return conn.RequestResponse(req, t.Receiver, priority)
+	var res *http.Response
+	if t.ResponseHeaderTimeout == 0 && t.IdleTimeout == 0 && t.Timeout == 0 {
+		res, err = s.conn.RequestResponse(req, t.Receiver, priority)
+	} else {
+		res, err = t.requestResponse(s.conn, req, priority, start)
+	}
+	t.release(s)
+	if err == nil && res.StatusCode == 0 {
+		// The stream ended without SYN_REPLY, as the session
+		// closed or the server reset it.
+		res, err = nil, errNoReply
+	}
+	return res, s, err
+}
+
+var errNoReply = errors.New("spdy: stream ended without a reply")
+
+// timeoutError is the error of a request which ran into one of
+// the Transport's timeouts.
+type timeoutError struct {
+	msg string
+}
+
+func (e *timeoutError) Error() string   { return e.msg }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// watchedReceiver tells of every frame given to its Receiver.
+type watchedReceiver struct {
+	common.Receiver
+	header chan struct{}
+	frame  chan struct{}
+}
+
+func (w *watchedReceiver) ReceiveHeader(req *http.Request, header http.Header) {
+	w.Receiver.ReceiveHeader(req, header)
+	notify(w.header)
+	notify(w.frame)
+}
+
+func (w *watchedReceiver) ReceiveData(req *http.Request, data []byte, final bool) {
+	w.Receiver.ReceiveData(req, data, final)
+	notify(w.frame)
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// requestResponse is RequestResponse with the Transport's
+// timeouts applied to the stream, which was started at start.
+func (t *Transport) requestResponse(conn common.Conn, req *http.Request, priority common.Priority, start time.Time) (*http.Response, error) {
+	res := common.NewResponse(req, t.Receiver)
+	w := &watchedReceiver{Receiver: res, header: make(chan struct{}, 1), frame: make(chan struct{}, 1)}
+	stream, err := conn.Request(req, w, priority)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- stream.Run()
+	}()
+
+	// The response header timeout starts once the request
+	// has been written, if the stream tells when that is.
+	var header, idle, total <-chan time.Time
+	var sent <-chan struct{}
+	var headerTimer *time.Timer
+	startHeader := func() {
+		headerTimer = time.NewTimer(t.ResponseHeaderTimeout)
+		header = headerTimer.C
+	}
+	defer func() {
+		if headerTimer != nil {
+			headerTimer.Stop()
+		}
+	}()
+	if t.ResponseHeaderTimeout > 0 {
+		if s, ok := stream.(interface {
+			Sent() <-chan struct{}
+		}); ok {
+			sent = s.Sent()
+		} else {
+			startHeader()
+		}
+	}
+	var idleTimer *time.Timer
+	if t.IdleTimeout > 0 {
+		idleTimer = time.NewTimer(t.IdleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+	if t.Timeout > 0 {
+		timer := time.NewTimer(t.Timeout - time.Since(start))
+		defer timer.Stop()
+		total = timer.C
+	}
+
+	var msg string
+	for msg == "" {
+		select {
+		case err := <-done:
+			if err != nil {
+				return nil, err
+			}
+			if err := streamError(conn, stream.StreamID()); err != nil {
+				return nil, err
+			}
+			return res.Response(), nil
+		case <-sent:
+			sent = nil
+			startHeader()
+		case <-w.header:
+			header, sent = nil, nil
+		case <-w.frame:
+			if idleTimer != nil {
+				idleTimer.Reset(t.IdleTimeout)
+			}
+		case <-header:
+			msg = "awaiting response headers"
+		case <-idle:
+			msg = "stream idle"
+		case <-total:
+			msg = "request total"
+		}
+	}
+
+	// Closing a stream still open on the server's side sends
+	// RST_STREAM CANCEL.
+	stream.Close()
+	return nil, &timeoutError{fmt.Sprintf("spdy: timeout %s on stream %d", msg, stream.StreamID())}
+}
+
+// Sessions returns the number of open SPDY sessions to host, as
+// host:port.
+func (t *Transport) Sessions(host string) int {
+	t.m.Lock()
+	defer t.m.Unlock()
+	n := 0
+	if p := t.spdyConns[host]; p != nil {
+		for _, s := range p.sessions {
+			if !s.conn.Closed() {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// session returns the first SPDY session to host, nil if there
+// is none.
+func (t *Transport) session(host string) common.Conn {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if p := t.spdyConns[host]; p != nil && len(p.sessions) > 0 {
+		return p.sessions[0].conn
+	}
+	return nil
+}
+
+// limit returns the number of streams s may have open, -1 if
+// there is no limit.
+func (t *Transport) limit(s *session) int {
+	limit := t.MaxStreamsPerSession
+	if limit == 0 {
+		limit = -1
+	}
+	if l, ok := s.conn.(interface {
+		StreamLimit() uint32
+	}); ok {
+		if server := int(l.StreamLimit()); limit < 0 || server < limit {
+			limit = server
+		}
+	}
+	return limit
+}
+
+// pick returns the session to host of a new stream, or nil if
+// another session should be opened. It waits while all sessions
+// are at their limit, unless the server allows no streams on any
+// of them. t.m must be held.
+func (t *Transport) pick(host string) (*session, error) {
+	max := t.SessionsPerHost
+	if max < 1 {
+		max = 1
+	}
+	for {
+		p := t.spdyConns[host]
+		if p == nil {
+			return nil, nil
+		}
+		for _, s := range append([]*session(nil), p.sessions...) {
+			if s.conn.Closed() || goawayReceived(s.conn) {
+				//this exists bugs:server always close the connection,so the conn.Closed.Closed() is true.
+				//And client will dial,it will consume one conn idles,the default idles is 2.So the server close 2 times can make the client block.see:<-t.connLimit[u.Host] in dial()
+				//if do not handle this bug,client will block
+				t.retire(host, s)
+			}
+		}
+
+		var free []int
+		idle := false
+		refused := 0
+		for i, s := range p.sessions {
+			if s.streams == 0 {
+				idle = true
+			}
+			switch limit := t.limit(s); {
+			case limit == 0:
+				refused++
+			case limit < 0 || s.streams < limit:
+				free = append(free, i)
+			}
+		}
+		if len(p.sessions) < max && !idle {
+			return nil, nil
+		}
+		if len(free) > 0 {
+			i := free[0]
+			switch t.Balance {
+			case RoundRobin:
+				// The first free session from next on.
+				for _, j := range free {
+					if j >= p.next%len(p.sessions) {
+						i = j
+						break
+					}
+				}
+				p.next = i + 1
+			default:
+				for _, j := range free {
+					if p.sessions[j].streams < p.sessions[i].streams {
+						i = j
+					}
+				}
+			}
+			return p.sessions[i], nil
+		}
+		if refused > 0 && refused == len(p.sessions) {
+			return nil, common.ErrNoStreams
+		}
+		t.streamDone.Wait()
+	}
+}
+
+// release ends a stream of s.
+func (t *Transport) release(s *session) {
+	t.m.Lock()
+	s.streams--
+	if t.Streams != nil {
+		t.Streams(s.conn.Conn(), -1)
+	}
+	t.m.Unlock()
+	t.streamDone.Broadcast()
+}
+
+// Goaways returns the number of GOAWAYs received from SPDY
+// servers, and the number of requests sent again after them.
+func (t *Transport) Goaways() (goaways, retries int) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.goaways, t.retries
+}
+
+// Stats returns the activity on the SPDY sessions to all hosts,
+// summed over the open sessions and those already closed.
+func (t *Transport) Stats() common.ConnStats {
+	t.m.Lock()
+	defer t.m.Unlock()
+	// Fold the retired sessions which have closed since.
+	retired := t.retired[:0]
+	for _, conn := range t.retired {
+		if conn.Closed() {
+			t.oldStats.Add(conn.Stats())
+		} else {
+			retired = append(retired, conn)
+		}
+	}
+	t.retired = retired
+	var stats common.ConnStats
+	stats.Add(t.oldStats)
+	for _, conn := range t.retired {
+		stats.Add(conn.Stats())
+	}
+	for _, p := range t.spdyConns {
+		for _, s := range p.sessions {
+			stats.Add(s.conn.Stats())
+		}
+	}
+	return stats
+}
+
+// goawayReceived reports whether the server of conn sent GOAWAY.
+func goawayReceived(conn common.Conn) bool {
+	g, ok := conn.(interface {
+		GoawayReceived() bool
+	})
+	return ok && g.GoawayReceived()
+}
+
+// streamError returns the error of a stream which was refused by
+// the server of conn.
+func streamError(conn common.Conn, id common.StreamID) error {
+	s, ok := conn.(interface {
+		StreamError(common.StreamID) error
+	})
+	if !ok {
+		return nil
+	}
+	return s.StreamError(id)
+}
+
+// retire removes the SPDY session s to host, if it is still in
+// the pool, as it has been closed or received GOAWAY. A session
+// which is still open is closed once its remaining streams are
+// done. t.m must be held.
+func (t *Transport) retire(host string, s *session) {
+	p := t.spdyConns[host]
+	if p == nil {
+		return
+	}
+	i := 0
+	for i < len(p.sessions) && p.sessions[i] != s {
+		i++
+	}
+	if i == len(p.sessions) {
+		return
+	}
+	p.sessions = append(p.sessions[:i], p.sessions[i+1:]...)
+	t.retired = append(t.retired, s.conn)
+	// Free the slot the connection took in dial.
+	t.connLimit[host] <- struct{}{}
+	t.streamDone.Broadcast()
+	if goawayReceived(s.conn) {
+		t.goaways++
+	}
+	if s.conn.Closed() {
+		return
+	}
+	if d, ok := s.conn.(interface {
+		Drain()
+	}); ok {
+		d.Drain()
+	} else {
+		s.conn.Close()
+	}
 }
 
-func (t *Transport) process(req *http.Request) (common.Conn, net.Conn, error) {
+// process returns the SPDY session or the TCP connection for req.
+// A session is returned with the stream of req counted.
+func (t *Transport) process(req *http.Request) (*session, net.Conn, error) {
 	t.m.Lock()
 	defer t.m.Unlock()
 
@@ -198,7 +776,10 @@ func (t *Transport) process(req *http.Request) (common.Conn, net.Conn, error) {
 
 	// Initialise structures if necessary.
 	if t.spdyConns == nil {
-		t.spdyConns = make(map[string]common.Conn)
+		t.spdyConns = make(map[string]*sessionPool)
+	}
+	if t.streamDone == nil {
+		t.streamDone = sync.NewCond(&t.m)
 	}
 	if t.tcpConns == nil {
 		t.tcpConns = make(map[string]chan net.Conn)
@@ -209,6 +790,9 @@ func (t *Transport) process(req *http.Request) (common.Conn, net.Conn, error) {
 	if t.MaxIdleConnsPerHost == 0 {
 		t.MaxIdleConnsPerHost = http.DefaultMaxIdleConnsPerHost
 	}
+	if t.MaxIdleConnsPerHost < t.SessionsPerHost {
+		t.MaxIdleConnsPerHost = t.SessionsPerHost
+	}
 	if _, ok := t.connLimit[u.Host]; !ok {
 		limitChan := make(chan struct{}, t.MaxIdleConnsPerHost)
 		t.connLimit[u.Host] = limitChan
@@ -230,14 +814,14 @@ func (t *Transport) process(req *http.Request) (common.Conn, net.Conn, error) {
 	}
 
 	// Check the SPDY connection pool.
-	conn, ok := t.spdyConns[u.Host]
-	if bug := (conn != nil && conn.Closed()); !ok || u.Scheme == "http" || bug {
-		//this exists bugs:server always close the connection,so the conn.Closed.Closed() is true.
-		//And client will dial,it will consume one conn idles,the default idles is 2.So the server close 2 times can make the client block.see:<-t.connLimit[u.Host] in dial()
-		//if do not handle this bug,client will block
-		if bug {
-			t.connLimit[req.URL.Host] <- struct{}{}
+	var s *session
+	if u.Scheme != "http" {
+		var err error
+		if s, err = t.pick(u.Host); err != nil {
+			return nil, nil, err
 		}
+	}
+	if s == nil {
 		tcpConn, err := t.dial(req.URL)
 		if err != nil {
 			return nil, nil, err
@@ -271,14 +855,14 @@ func (t *Transport) process(req *http.Request) (common.Conn, net.Conn, error) {
 				}
 			}
 
-			// If a protocol could not be negotiated, assume HTTPS.
-			if !state.NegotiatedProtocolIsMutual {
-				return nil, tcpConn, nil
+			if t.Negotiated != nil {
+				t.Negotiated(state.NegotiatedProtocol)
 			}
 
-			// Scan the list of supported NPN strings.
+			// Scan the list of supported ALPN strings. Without
+			// ALPN the server chose none and HTTPS is assumed.
 			supported := false
-			for _, proto := range npn() {
+			for _, proto := range alpn() {
 				if state.NegotiatedProtocol == proto {
 					supported = true
 					break
@@ -301,30 +885,134 @@ func (t *Transport) process(req *http.Request) (common.Conn, net.Conn, error) {
 				if err != nil {
 					return nil, nil, err
 				}
-				go newConn.Run()
-				t.spdyConns[u.Host] = newConn
-				conn = newConn
+				s = t.add(u.Host, newConn, tlsConn)
 
 			case "spdy/3":
 				newConn, err := NewClientConn(tlsConn, t.PushReceiver, 3, 0)
 				if err != nil {
 					return nil, nil, err
 				}
-				go newConn.Run()
-				t.spdyConns[u.Host] = newConn
-				conn = newConn
+				s = t.add(u.Host, newConn, tlsConn)
 
-			case "spdy/2":
-				newConn, err := NewClientConn(tlsConn, t.PushReceiver, 2, 0)
-				if err != nil {
-					return nil, nil, err
-				}
-				go newConn.Run()
-				t.spdyConns[u.Host] = newConn
-				conn = newConn
 			}
 		}
 	}
 
-	return conn, nil, nil
+	s.streams++
+	if t.Streams != nil {
+		t.Streams(s.conn.Conn(), 1)
+	}
+	return s, nil, nil
+}
+
+// observe sets the frame observer of a new SPDY session
+// over conn.
+func (t *Transport) observe(s common.Conn, conn net.Conn) {
+	if t.FrameObserver == nil {
+		return
+	}
+	o, ok := s.(common.FrameObservable)
+	if !ok {
+		return
+	}
+	if observer := t.FrameObserver(conn); observer != nil {
+		o.SetFrameObserver(observer)
+	}
+}
+
+// flowControl sets the flow control of a new SPDY session,
+// returning it, or nil if the session keeps its default.
+func (t *Transport) flowControl(s common.Conn) common.FlowControl {
+	if t.FlowControl == nil {
+		return nil
+	}
+	f, ok := s.(common.SetFlowController)
+	if !ok {
+		return nil
+	}
+	flow := t.FlowControl()
+	if flow != nil {
+		f.SetFlowControl(flow)
+	}
+	return flow
+}
+
+// configure sets the SETTINGS of a new SPDY session to host,
+// and stores those the server asks to persist.
+func (t *Transport) configure(host string, s common.Conn) {
+	c, ok := s.(common.SettingsConfigurer)
+	if !ok {
+		return
+	}
+	settings := t.PersistedSettings(host)
+	settings.Update(t.Settings)
+	c.SetSettings(settings)
+	c.SetSettingsPersister(func(clear bool, persist common.Settings) {
+		t.persistLock.Lock()
+		defer t.persistLock.Unlock()
+		if clear {
+			delete(t.persisted, host)
+		}
+		if len(persist) == 0 {
+			return
+		}
+		if t.persisted == nil {
+			t.persisted = make(map[string]common.Settings)
+		}
+		if t.persisted[host] == nil {
+			t.persisted[host] = make(common.Settings)
+		}
+		t.persisted[host].Update(persist)
+	})
+}
+
+// PersistedSettings returns the SETTINGS which the server host,
+// as host:port, asked to persist, as they are sent back to it.
+func (t *Transport) PersistedSettings(host string) common.Settings {
+	t.persistLock.Lock()
+	defer t.persistLock.Unlock()
+	settings := make(common.Settings)
+	settings.Update(t.persisted[host])
+	return settings
+}
+
+// add starts a new SPDY session to host over conn, and puts it
+// in the pool. t.m must be held.
+func (t *Transport) add(host string, newConn common.Conn, conn net.Conn) *session {
+	t.observe(newConn, conn)
+	t.configure(host, newConn)
+	flow := t.flowControl(newConn)
+	go newConn.Run()
+
+	p := t.spdyConns[host]
+	if p == nil {
+		p = new(sessionPool)
+		t.spdyConns[host] = p
+	}
+	s := &session{conn: newConn}
+	p.sessions = append(p.sessions, s)
+	if k, ok := newConn.(interface {
+		Keepalive(interval, timeout time.Duration, pinged func(time.Duration, error))
+	}); ok && t.PingInterval > 0 {
+		timeout := t.PingTimeout
+		if timeout == 0 {
+			timeout = t.PingInterval
+		}
+		pinged := t.Pinged
+		if a, ok := flow.(interface {
+			Sample(rtt time.Duration, received int64)
+		}); ok {
+			// Tune the window from the round trips.
+			pinged = func(rtt time.Duration, err error) {
+				if err == nil {
+					a.Sample(rtt, newConn.Stats().DataBytesReceived)
+				}
+				if t.Pinged != nil {
+					t.Pinged(rtt, err)
+				}
+			}
+		}
+		k.Keepalive(t.PingInterval, timeout, pinged)
+	}
+	return s
 }
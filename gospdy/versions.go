@@ -33,19 +33,21 @@ func SupportedVersions() []float64 {
 	return s
 }
 
-var npnStrings = map[float64]string{
-	2:   "spdy/2",
+// alpnStrings are the ALPN protocol IDs of the SPDY versions. SPDY/2
+// was only ever negotiated with NPN, which Go no longer supports, so
+// it has none.
+var alpnStrings = map[float64]string{
 	3:   "spdy/3",
 	3.1: "spdy/3.1",
 }
 
-// npn returns the NPN version strings for the SPDY versions
-// currently enabled, plus HTTP/1.1.
-func npn() []string {
+// alpn returns the ALPN protocol IDs for the SPDY versions currently
+// enabled, most recent first, plus HTTP/1.1.
+func alpn() []string {
 	v := SupportedVersions()
 	s := make([]string, 0, len(v)+1)
 	for _, v := range v {
-		if str := npnStrings[float64(v)]; str != "" {
+		if str := alpnStrings[float64(v)]; str != "" {
 			s = append(s, str)
 		}
 	}
@@ -16,19 +16,27 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/albus01/ibenchmark/bench"
 	"github.com/albus01/ibenchmark/gospdy"
+	"github.com/albus01/ibenchmark/gospdy/common"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
 	gourl "net/url"
 	"os"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -56,45 +64,182 @@ var CipherSuites = map[string]uint16{
 	"TLS_FALLBACK_SCSV": uint16(0x5600),
 }
 var headers flagHeader
+
+//targets are the urls of -target.
+var targets flagList
 var (
-	help        *bool   = flag.Bool("h", false, "show help")
-	url         *string = flag.String("u", "https://0.0.0.0:28080/", "server url")
-	concurrency *int    = flag.Int("c", 1, "concurrency:the worker's number,1 default")
-	reqNum      *int    = flag.Int("r", 1, "total requests per connection,1 default")
-	dur         *int    = flag.Int("t", 0, "timelimit (second),0 second default")
-	keepAlive   *bool   = flag.Bool("k", false, "keep the connections each worker established alive,false default")
-	cipherSuite *string = flag.String("s", "TLS_RSA_WITH_RC4_128_SHA", "cipher suite,TLS_RSA_WITH_RC4_128_SHA default")
-	method      *string = flag.String("m", "GET", "HTTP Method,GET default")
-	body        *string = flag.String("B", "", "request Body,empty default")
-	out         *bool   = flag.Bool("o", false, "print response body")
-	core        *int    = flag.Int("M", 8, "max cores used,8 default")
-	SP          *bool   = flag.Bool("S", false, "turn to SPDY")
-	verb        *bool   = flag.Bool("v", true, "print schedule.True default")
+	help         *bool          = flag.Bool("h", false, "show help")
+	url          *string        = flag.String("u", "https://0.0.0.0:28080/", "server url,placeholders like {{seq}} in the url,-H and -B are expanded per request.unix:///run/app.sock[:/path] or https+unix://... for a unix socket")
+	concurrency  *int           = flag.Int("c", 1, "concurrency:the worker's number,1 default")
+	reqNum       *int           = flag.Int("r", 1, "total requests per connection,1 default")
+	dur          *int           = flag.Int("t", 0, "timelimit (second),0 second default")
+	keepAlive    *bool          = flag.Bool("k", false, "keep the connections each worker established alive,false default")
+	cipherSuite  *string        = flag.String("s", "TLS_RSA_WITH_RC4_128_SHA", "cipher suite,TLS_RSA_WITH_RC4_128_SHA default")
+	method       *string        = flag.String("m", "GET", "HTTP Method,GET default")
+	body         *string        = flag.String("B", "", "request Body,@file,@dir to rotate its files,random:SIZE or random:MIN-MAX,empty default")
+	out          *bool          = flag.Bool("o", false, "print response body")
+	core         *int           = flag.Int("M", 8, "max cores used,8 default")
+	SP           *bool          = flag.Bool("S", false, "turn to SPDY")
+	H2           *bool          = flag.Bool("h2", false, "turn to HTTP/2,h2 by alpn for https urls and h2c with prior knowledge for http urls")
+	h2Conns      *int           = flag.Int("h2-conns", 1, "HTTP/2 connections shared by the workers,1 default")
+	h2Streams    *int           = flag.Int("h2-streams", 0, "max concurrent streams per HTTP/2 connection,0 for the server's limit")
+	spdySessions *int           = flag.Int("spdy-sessions", 0, "with -S,multiplex the requests of all workers over this many SPDY sessions,0 for a session per worker")
+	spdyStreams  *int           = flag.Int("spdy-streams", 0, "max concurrent streams per -spdy-sessions session,0 for the server's limit")
+	spdyBalance  *string        = flag.String("spdy-balance", "least-streams", "how -spdy-sessions picks the session of a stream:least-streams or round-robin")
+	spdyHeaderTO *time.Duration = flag.Duration("spdy-header-timeout", 0, "with -S,cancel a stream whose response headers take longer,eg 2s,0 for none")
+	spdyIdleTO   *time.Duration = flag.Duration("spdy-idle-timeout", 0, "with -S,cancel a stream which receives no frame for this long,0 for none")
+	spdyTimeout  *time.Duration = flag.Duration("spdy-timeout", 0, "with -S,cancel a request which takes longer in all,connecting included,0 for none")
+	spdyPing     *time.Duration = flag.Duration("spdy-ping", 0, "with -S,ping every session at this interval to report the rtt,a session whose ping isn't answered within it is closed,0 for none")
+	spdyFlow     *string        = flag.String("spdy-flow", "default", "with -S,flow control of the sessions:default,fixed:SIZE regrown after every frame,starved:SIZE regrown once used up,or auto[:MIN[-MAX]] tuned by the -spdy-ping rtts")
+	spdySettings *string        = flag.String("spdy-settings", "", "with -S,SETTINGS sent by every session,NAME=VALUE[:persist|:persisted] comma separated,eg MAX_CONCURRENT_STREAMS=100,ROUND_TRIP_TIME=20:persist")
+	spdyTrace    *string        = flag.String("spdy-trace", "", "with -S,write every SPDY frame sent or received to the file as json lines,empty for none")
+	verb         *bool          = flag.Bool("v", true, "print schedule.True default")
+	htmlOut      *string        = flag.String("html", "", "write a self-contained html report to the file,empty default")
+	configFile   *string        = flag.String("config", "", "load the test definition from a json file,flags override its values")
+	dumpConf     *bool          = flag.Bool("dump-config", false, "print the effective configuration as json and exit")
+	dataFile     *string        = flag.String("data", "", "csv or json lines file read by the {{data:COLUMN}} placeholders")
+	dataMode     *string        = flag.String("data-mode", ibench.DataSequential, "how -data rows are drawn:sequential,random or unique")
+	cookies      *bool          = flag.Bool("cookies", false, "keep a cookie jar per worker,per session with -session")
+	affinitySpec *string        = flag.String("affinity", "", "report the spread over backends told apart by header:NAME or cookie:NAME")
+	sessionFile  *string        = flag.String("session", "", "json file of a multi-step flow,each query runs the whole flow")
+	auth         *string        = flag.String("auth", "", "basic:USER:PASSWORD,bearer:TOKEN or bearer:@FILE with one token per line")
+	authRotate   *string        = flag.String("auth-rotate", ibench.RotateWorker, "how the tokens of bearer:@FILE are used:worker or request")
+	hmacSecret   *string        = flag.String("hmac-secret", "", "sign every request with an hmac of this secret,@FILE to read it from FILE")
+	hmacString   *string        = flag.String("hmac-string", strings.Replace(ibench.DefaultCanonical, "\n", `\n`, -1), "canonical string signed by -hmac-secret,placeholders:{{method}} {{host}} {{path}} {{query}} {{uri}} {{header:NAME}} {{body_sha256}} {{timestamp}} {{nonce}}")
+	hmacHeader   *string        = flag.String("hmac-header", "X-Signature: {{timestamp}}:{{signature}}", "header carrying the -hmac-secret signature")
+	hmacAlgo     *string        = flag.String("hmac-algo", "sha256", "hmac hash:sha256,sha1 or sha512")
+	hmacEncoding *string        = flag.String("hmac-encoding", "hex", "signature encoding:hex or base64")
+	replayFile   *string        = flag.String("replay", "", "replay the requests of a combined format access log or a HAR file to the host of -u")
+	replaySpeed  *float64       = flag.Float64("replay-speed", 1, "scale of the recorded timing of -replay,2 twice as fast,0 as fast as possible")
+	bindAddrs    *string        = flag.String("bind", "", "comma separated source ips of the connections,spread round-robin across workers")
+	bindPorts    *string        = flag.String("bind-ports", "", "source port range MIN-MAX of the connections,empty lets the kernel choose")
+	resolve      *string        = flag.String("resolve", "", "comma separated host:port:addr overrides of the dns,repeat an entry per address")
+	dnsMode      *string        = flag.String("dns", ibench.ResolveSystem, "address lookup:system,once at the first connection or conn for every connection")
+	dnsSpread    *bool          = flag.Bool("dns-spread", false, "spread the connections over all the addresses of a host instead of the first one")
+	ipv4Only     *bool          = flag.Bool("4", false, "connect over ipv4 only")
+	ipv6Only     *bool          = flag.Bool("6", false, "connect over ipv6 only")
+	dualStack    *string        = flag.String("dual-stack", "happy", "hosts with ipv4 and ipv6 addresses:happy races the families,strict tries the addresses one after the other")
+	unixSocket   *string        = flag.String("unix", "", "connect to this unix domain socket instead of the host of -u,which still names the Host header and tls server name")
+	rate         *float64       = flag.Float64("rate", 0, "requests per second of all workers together,sessions with -session,0 for as fast as they go.With -stages the rate the first stage starts from")
+	stages       *string        = flag.String("stages", "", "comma separated DURATION:RATE stages,each ramps the rate linearly to RATE over DURATION,eg 30s:100,1m:100,30s:0.The run lasts as long as the stages")
+	tlsMin       *string        = flag.String("tls-min", "", "lowest tls version offered:1.0,1.1,1.2 or 1.3,empty for go's default")
+	tlsMax       *string        = flag.String("tls-max", "", "highest tls version offered:1.0,1.1,1.2 or 1.3,empty for go's default")
+	sni          *string        = flag.String("sni", "", "server name of the tls handshakes,the host of the url default")
+	caFile       *string        = flag.String("ca", "", "pem file of the CAs the server certificate must chain to,the certificate isn't verified without it")
+	clientCert   *string        = flag.String("client-cert", "", "pem client certificate presented in the tls handshakes,with -client-key")
+	clientKey    *string        = flag.String("client-key", "", "pem private key of -client-cert")
 )
 
+//configKeys maps the readable keys of a -config file to the short flags they set.
+//Any other key is taken as the name of the flag itself.
+var configKeys = map[string]string{
+	"url":         "u",
+	"concurrency": "c",
+	"requests":    "r",
+	"duration":    "t",
+	"keepalive":   "k",
+	"ciphers":     "s",
+	"method":      "m",
+	"body":        "B",
+	"print_body":  "o",
+	"cores":       "M",
+	"spdy":        "S",
+	"verbose":     "v",
+	"headers":     "H",
+	"targets":     "target",
+}
+
+//configExcluded are the flags which have no meaning in a -config file.
+var configExcluded = map[string]bool{"h": true, "config": true, "dump-config": true}
+
 var (
-	proto        string
-	host         string
-	port         string
-	path         string
-	swithHttp    bool            = false
-	network      string          = "tcp"
-	servers                      = struct {
+	proto     string
+	host      string
+	port      string
+	path      string
+	swithHttp bool   = false
+	network   string = "tcp"
+	servers          = struct {
 		sync.RWMutex
 		item map[string]bool
 	}{item: make(map[string]bool)}
-	header       http.Header     = make(http.Header)
-	cipherSuites []uint16
-	portMap      = map[string]string{"http": "80", "https": "443"}
-	reporter     *ibench.Reporter
+	header          http.Header = make(http.Header)
+	cipherSuites    []uint16
+	portMap         = map[string]string{"http": "80", "https": "443"}
+	reporter        *ibench.Reporter
+	requestTemplate *ibench.RequestTemplate
+	session         *ibench.Session
+	affinity        *ibench.Affinity
+	authenticators  []ibench.Authenticator
+	replay          *ibench.Replay
+	dialer          *ibench.Dialer
+	localIPs        []net.IP
+	addrStats       *ibench.Breakdown
+	familyStats     = ibench.NewBreakdown("Family", "IPv4", "IPv6")
+	h2Pool          *ibench.H2Pool
+	spdyPool        *ibench.SPDYPool
+	//pacer spaces the requests to -rate and -stages,nil for as fast as they go.
+	pacer *ibench.Pacer
+	//targetStats breaks the requests down by url with -target.
+	targetStats *ibench.Breakdown
+	//targetTLS is the tls config of the connections to the target,see clientTLS.
+	targetTLS *tls.Config
+	//spdyProtos counts the protocols the spdy connections negotiated,"" for none.
+	spdyProtos = struct {
+		sync.Mutex
+		count map[string]int
+	}{count: make(map[string]int)}
+	//spdyPings are the rtts of -spdy-ping.
+	spdyPings = &ibench.PingStats{}
+	//spdyFlowControl gives the flow control of every new spdy session,nil for the default.
+	spdyFlowControl func() common.FlowControl
+	//spdySettingsSent are the SETTINGS of -spdy-settings.
+	spdySettingsSent common.Settings
+	//spdyFrames writes the frames of -spdy-trace to spdyTraceFile.
+	spdyFrames    *common.FrameWriter
+	spdyTraceFile *os.File
+	//spdyTransports are the transports of the workers with -S,for their GOAWAY counts and stats.
+	spdyTransports = struct {
+		sync.Mutex
+		list []*spdy.Transport
+	}{}
 )
 
 type flagHeader []string
 
+func init() {
+	flag.Var(&headers, "H", "-H \"xxx\" -H \"xxx\" to set muilty headers")
+	flag.Var(&targets, "target", "another url the requests go to,-target URL -target URL for several.The requests go to -u and each -target in turn")
+}
+
+//flagList is a flag which may be given several times.
+type flagList []string
+
+func (f *flagList) String() string { return strings.Join(*f, ",") }
+
+func (f *flagList) Get() interface{} {
+	if *f == nil {
+		return []string{}
+	}
+	return []string(*f)
+}
+
+func (f *flagList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func (f *flagHeader) String() string {
 	return fmt.Sprint(headers)
 }
 
+func (f *flagHeader) Get() interface{} {
+	if headers == nil {
+		return []string{}
+	}
+	return []string(headers)
+}
+
 func (f *flagHeader) Set(value string) error {
 	if headers == nil {
 		headers = make(flagHeader, 1)
@@ -109,63 +254,150 @@ func (f *flagHeader) Set(value string) error {
 
 //the queries depend on the param dur or requests.if both were setted,depend on dur.See worker func.
 //otherwise close the connection immediately when established.
-func handle_request(start, done chan bool, client *http.Client, r *ibench.Reporter) {
+//With -session every query runs a whole session.
+func handle_request(start, done chan bool, w *workerContext, r *ibench.Reporter) {
 	for {
 		<-start
-		atomic.AddInt32(&r.TotalRequest, 1)
-		var resp *http.Response
-		var err error
-		var bout bytes.Buffer
-		req, err := http.NewRequest(*method, *url, strings.NewReader(*body))
-		if err != nil {
-			atomic.AddInt32(&r.FailedRequest, 1)
-			r.FailedRequest += 1
+		if pacer != nil && !pacer.Wait() {
+			//the stages are over,the worker ends with them.
+			return
+		}
+		if session != nil {
+			if *cookies {
+				//every session is a new user,who starts without cookies.
+				w.client.Jar, _ = cookiejar.New(nil)
+			}
+			session.Run(w.id, func(req *http.Request, readBody bool) (*http.Response, []byte, error) {
+				return send(w, req, r, readBody)
+			})
 			done <- true
 			continue
 		}
-		req.Header = header
-		if header.Get("Host") != "" {
-			//I think this should be a golang http pkg's bug.
-			//if I put Host Header in the req.Header,golang pkg can't handle it.
-			//So I have to hanlde the Host header in my code.
-			req.Host = header.Get("Host")
-		}
-		resp, err = client.Do(req)
+		ctx := requestTemplate.NewContext(w.id)
+		req, err := requestTemplate.NewRequest(ctx)
 		if err != nil {
+			atomic.AddInt32(&r.TotalRequest, 1)
 			atomic.AddInt32(&r.FailedRequest, 1)
+			w.rec.Record(ibench.Sample{Start: time.Now(), Err: err})
 			done <- true
 			continue
 		}
-		if resp != nil {
-			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-				r.Non2XXCode += 1
-			}
-			r.ContentLength = resp.ContentLength
-			for _, server := range resp.Header["Server"] {
-				servers.RLock()
-				b := servers.item[server]
-				servers.RUnlock()
-				if !b {
-					servers.Lock()
-					servers.item[server] = true
-					servers.Unlock()
-				}
-			}
-			if *out {
-				io.Copy(&bout, resp.Body)
-				if bout.String() != "" {
-					fmt.Println(bout.String())
-				}
-			}
-
-			if err := resp.Body.Close(); err != nil {
-				atomic.AddInt32(&r.FailedRequest, 1)
-			}
+		sent := time.Now()
+		resp, _, err := send(w, req, r, false)
+		if targetStats != nil {
+			targetStats.Record(requestTemplate.URLFor(ctx).String(), time.Since(sent), err != nil || resp.StatusCode >= 400)
 		}
 		done <- true
 	}
 }
 
+//workerContext is the state of one worker shared by its requests.
+type workerContext struct {
+	id       int
+	client   *http.Client
+	rec      *ibench.Recorder
+	affinity *ibench.AffinityTracker //nil without -affinity
+}
+
+//send runs req and records it.The response body is returned if readBody is set,
+//otherwise it's printed with -o or discarded.
+func send(w *workerContext, req *http.Request, r *ibench.Reporter, readBody bool) (*http.Response, []byte, error) {
+	rec := w.rec
+	atomic.AddInt32(&r.TotalRequest, 1)
+	tracer, trace := ibench.NewTracer()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	sample := ibench.Sample{Start: time.Now()}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		atomic.AddInt32(&r.FailedRequest, 1)
+		sample.Latency = time.Since(sample.Start)
+		sample.Err = err
+		rec.Record(sample)
+		recordAddr(tracer, sample.Latency, true, err)
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		r.Non2XXCode += 1
+	}
+	r.ContentLength = resp.ContentLength
+	for _, server := range resp.Header["Server"] {
+		servers.RLock()
+		b := servers.item[server]
+		servers.RUnlock()
+		if !b {
+			servers.Lock()
+			servers.item[server] = true
+			servers.Unlock()
+		}
+	}
+	var body []byte
+	switch {
+	case readBody || *out:
+		body, err = ioutil.ReadAll(resp.Body)
+		if *out && len(body) > 0 {
+			fmt.Println(string(body))
+		}
+	default:
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+	}
+	if e := resp.Body.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		atomic.AddInt32(&r.FailedRequest, 1)
+	}
+	sample.Latency = time.Since(sample.Start)
+	sample.StatusCode = resp.StatusCode
+	sample.Err = err
+	sample.Connect, sample.TLS, sample.TTFB = tracer.Connect, tracer.TLS, tracer.TTFB
+	rec.Record(sample)
+	recordAddr(tracer, sample.Latency, err != nil || resp.StatusCode >= 400, err)
+	if w.affinity != nil {
+		w.affinity.Record(resp, sample.Latency)
+	}
+	return resp, body, err
+}
+
+//recordAddr counts a request by the family of the address it was sent to,and
+//by the address itself if -resolve or -dns are set.
+func recordAddr(tracer *ibench.Tracer, latency time.Duration, failed bool, err error) {
+	addr := tracer.Addr(err)
+	if family := ibench.AddrFamily(addr); family != "" {
+		familyStats.Record(family, latency, failed)
+	}
+	if addrStats == nil {
+		return
+	}
+	if addr == "" {
+		addr = "(unknown)"
+	}
+	addrStats.Record(addr, latency, failed)
+}
+
+//replayRequests sends the recorded requests of -replay until all were sent or
+//the time limit,if any,is over.
+func replayRequests(w *workerContext, r *ibench.Reporter, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for timeout == 0 || time.Now().Before(deadline) {
+		e, ok := replay.Next()
+		if !ok {
+			return
+		}
+		start := time.Now()
+		req, err := replay.NewRequest(e, requestTemplate.NewContext(w.id))
+		if err != nil {
+			atomic.AddInt32(&r.TotalRequest, 1)
+			atomic.AddInt32(&r.FailedRequest, 1)
+			w.rec.Record(ibench.Sample{Start: start, Err: err})
+			continue
+		}
+		resp, _, err := send(w, req, r, false)
+		elapsed := time.Since(start)
+		atomic.AddInt64(&r.TimeTaken, int64(elapsed/time.Microsecond))
+		replay.Patterns.Record(e.Method+" "+ibench.Pattern(e.URI), elapsed, err != nil || resp.StatusCode >= 400)
+	}
+}
+
 func request_done(done, end chan bool, r *ibench.Reporter) {
 	for {
 		start_time := time.Now()
@@ -179,33 +411,67 @@ func request_done(done, end chan bool, r *ibench.Reporter) {
 
 //init a go routine,send queries on the transport layer ,the queries number depend on the reqNum or timeout.
 //And if both were setted,depends on timeout.
-//the finChan notify the main process wether this go routine has finished
-func worker(reqNum int, timeout time.Duration, reporter *ibench.Reporter, finChan chan bool) {
-	config := tls.Config{
-		InsecureSkipVerify:     true,
-		SessionTicketsDisabled: true,
-		CipherSuites:           cipherSuites,
-	}
+//the finChan notify the main process wether this go routine has finished,id numbers the worker for {{worker}}.
+func worker(id int, reqNum int, timeout time.Duration, reporter *ibench.Reporter, finChan chan bool) {
+	config := clientTLS()
+	d := workerDialer(id)
 	var tr http.RoundTripper
 	switch {
+	case h2Pool != nil:
+		tr = h2Pool.Transport(id)
+	case spdyPool != nil:
+		tr = spdyPool
 	case *SP:
-		tr = &spdy.Transport{
-			TLSClientConfig:   &config,
-			DisableKeepAlives: !*keepAlive,
+		t := &spdy.Transport{
+			Dial:                  d.Dial,
+			TLSClientConfig:       config,
+			DisableKeepAlives:     !*keepAlive,
+			Negotiated:            countSPDYProto,
+			ResponseHeaderTimeout: *spdyHeaderTO,
+			IdleTimeout:           *spdyIdleTO,
+			Timeout:               *spdyTimeout,
+			PingInterval:          *spdyPing,
+			Pinged:                spdyPings.Observe,
+			FrameObserver:         spdyFrameObserver,
+			FlowControl:           spdyFlowControl,
+			Settings:              spdySettingsSent,
 		}
+		spdyTransports.Lock()
+		spdyTransports.list = append(spdyTransports.list, t)
+		spdyTransports.Unlock()
+		tr = t
 	default:
 		tr = &ibench.Transport{
+			Dial:              d.Dial,
 			DisableKeepAlives: !*keepAlive,
-			TLSClientConfig:   &config,
+			TLSClientConfig:   config,
 		}
 	}
 	start := make(chan bool, 1024)
 	done := make(chan bool, 1024)
 	end := make(chan bool, 1024)
-	client := &http.Client{Transport: tr}
+	if len(authenticators) > 0 {
+		tr = &ibench.AuthTransport{Base: tr, Auth: authenticators, Worker: id}
+	}
+	w := &workerContext{
+		id:     id,
+		client: &http.Client{Transport: tr},
+		rec:    reporter.Stats.NewRecorder(),
+	}
+	if *cookies {
+		w.client.Jar, _ = cookiejar.New(nil)
+	}
+	if affinity != nil {
+		w.affinity = affinity.NewTracker()
+	}
 	end_time := time.After(timeout)
 
-	if *dur != 0 {
+	if replay != nil {
+		replayRequests(w, reporter, timeout)
+		finChan <- true
+		return
+	}
+	if timeout != 0 {
 		go func() {
 			for {
 				<-end
@@ -213,15 +479,14 @@ func worker(reqNum int, timeout time.Duration, reporter *ibench.Reporter, finCha
 			}
 
 		}()
-		go handle_request(start, done, client, reporter)
+		go handle_request(start, done, w, reporter)
 		go request_done(done, end, reporter)
 		for {
 			select {
 			case <-end_time:
 				finChan <- true
 				return
-			default:
-				start <- true
+			case start <- true:
 			}
 		}
 
@@ -236,7 +501,7 @@ func worker(reqNum int, timeout time.Duration, reporter *ibench.Reporter, finCha
 				}
 			}
 		}()
-		go handle_request(start, done, client, reporter)
+		go handle_request(start, done, w, reporter)
 		go request_done(done, end, reporter)
 		for i := 0; i < reqNum; i++ {
 			start <- true
@@ -245,30 +510,71 @@ func worker(reqNum int, timeout time.Duration, reporter *ibench.Reporter, finCha
 
 }
 
+//workerDialer returns the dialer of the worker or HTTP/2 connection id,which
+//takes the next -bind address.
+func workerDialer(id int) *ibench.Dialer {
+	if len(localIPs) > 0 {
+		return dialer.WithLocalIP(localIPs[id%len(localIPs)])
+	}
+	return dialer
+}
+
 func main() {
 	defer func() {
 		if err := recover(); err != nil {
 			printHelp(err)
 		}
 	}()
-	flag.Var(&headers, "H", "-H \"xxx\" -H \"xxx\" to set muilty headers")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			serve(os.Args[2:])
+			return
+		case "proxy":
+			proxy(os.Args[2:])
+			return
+		}
+	}
 	flag.Parse()
 	if *help {
 		printHelp(nil)
 	}
+	if *configFile != "" {
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if err := loadConfig(*configFile, set); err != nil {
+			printHelp(err)
+		}
+	}
 	//http https support only
 	checkAndInitParams()
+	if *dumpConf {
+		if err := dumpConfig(os.Stdout); err != nil {
+			printHelp(err)
+		}
+		os.Exit(0)
+	}
 	runtime.GOMAXPROCS(*core)
 
 	timeout := time.Duration(*dur) * time.Second
+	if pacer != nil && pacer.Duration() > 0 {
+		timeout = pacer.Duration()
+	}
 	finChan := make([]chan bool, *concurrency)
 
 	fmt.Println("ibenchmark start ")
 	// start workers
 	start := time.Now()
+	reporter.Stats = ibench.NewStatistics(start)
+	if replay != nil {
+		replay.Start(start)
+	}
+	if pacer != nil {
+		pacer.Start(start)
+	}
 	for i := 0; i < *concurrency; i = i + 1 {
 		finChan[i] = make(chan bool)
-		go worker(*reqNum, timeout, reporter, finChan[i])
+		go worker(i, *reqNum, timeout, reporter, finChan[i])
 	}
 	//report schedule
 	//if *verb {
@@ -284,7 +590,13 @@ func main() {
 	duration := time.Since(start).Nanoseconds() / (1000 * 1000)
 	generateReporter(duration)
 	time.Sleep(1 * time.Second)
+	closeSPDYTrace()
 	reporter.Print()
+	if *htmlOut != "" {
+		if err := reporter.WriteHTMLFile(*htmlOut); err != nil {
+			fmt.Println(err)
+		}
+	}
 }
 func generateReporter(duration int64) {
 	reporter.TimeDur = duration
@@ -310,25 +622,304 @@ func generateReporter(duration int64) {
 	}
 	//generate header info
 	reporter.Server = server
+	if replay != nil && *replaySpeed > 0 {
+		reporter.Details = append(reporter.Details, ibench.ConfigItem{
+			Name:  "Replay Max Lag",
+			Value: fmt.Sprintf("%dms behind the recorded timing", replay.MaxLag()/time.Millisecond),
+		})
+	}
+	if dialer.UnixSocket != "" {
+		reporter.Details = append(reporter.Details, ibench.ConfigItem{Name: "Unix Socket", Value: dialer.UnixSocket})
+	}
+	if pacer != nil {
+		sent := 0.0
+		if t > 0 {
+			sent = float64(reporter.TotalRequest) / t
+		}
+		reporter.Details = append(reporter.Details, ibench.ConfigItem{
+			Name:  "Request Rate",
+			Value: fmt.Sprintf("%.1f/s sent,%s targeted", sent, pacer),
+		})
+	}
+	//the families are only worth a table if both were used or one was asked for.
+	if rows := familyStats.Rows(); len(rows) > 1 || len(rows) == 1 && network != "tcp" {
+		reporter.Breakdowns = append(reporter.Breakdowns, familyStats)
+	}
+	if h2Pool != nil {
+		reporter.Details = append(reporter.Details, h2Details()...)
+	}
+	if *SP && proto == "https" {
+		reporter.Details = append(reporter.Details, ibench.ConfigItem{Name: "SPDY Negotiated", Value: spdyNegotiated()})
+		goaways, retries := spdyGoaways()
+		reporter.Details = append(reporter.Details, ibench.ConfigItem{
+			Name:  "SPDY GOAWAY",
+			Value: fmt.Sprintf("%d received,%d requests retried on a new session", goaways, retries),
+		})
+		if *spdyPing > 0 {
+			reporter.Details = append(reporter.Details, ibench.ConfigItem{Name: "SPDY PING RTT", Value: spdyPings.String()})
+		}
+		reporter.Details = append(reporter.Details, ibench.SPDYStatsDetails(spdyStats())...)
+	}
+	if spdyPool != nil {
+		peak, mean := spdyPool.StreamsPerSession()
+		limit := "the server's limit"
+		if *spdyStreams > 0 {
+			limit = strconv.Itoa(*spdyStreams)
+		}
+		reporter.Details = append(reporter.Details, ibench.ConfigItem{
+			Name:  "SPDY Streams Per Session",
+			Value: fmt.Sprintf("peak %d,mean %.1f,capped at %s", peak, mean, limit),
+		})
+	}
+	if affinity != nil {
+		responses, switches := affinity.Stickiness()
+		sticky := "n/a"
+		if responses > 0 {
+			sticky = fmt.Sprintf("%.1f%%", 100-100*float64(switches)/float64(responses))
+		}
+		reporter.Details = append(reporter.Details, ibench.ConfigItem{
+			Name:  "Backend Stickiness",
+			Value: fmt.Sprintf("%s (%d of %d follow-up responses changed backend)", sticky, switches, responses),
+		})
+	}
 	for k, v := range header {
 		var val string
 		for _, v := range v {
 			val += v + " "
 		}
-		reporter.Headers += k + ":" + val + "\r\n"
+		reporter.Headers += redactHeader(k+":"+val) + "\r\n"
+	}
+
+}
+
+//countSPDYProto counts a connection which negotiated p.
+func countSPDYProto(p string) {
+	spdyProtos.Lock()
+	spdyProtos.count[p]++
+	spdyProtos.Unlock()
+}
+
+//spdyNegotiated counts the connections by negotiated protocol,a server which
+//chose http/1.1 or none didn't speak spdy to the run.
+func spdyNegotiated() string {
+	spdyProtos.Lock()
+	defer spdyProtos.Unlock()
+	if len(spdyProtos.count) == 0 {
+		return "no tls connection"
+	}
+	names := make([]string, 0, len(spdyProtos.count))
+	for p := range spdyProtos.count {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for i, p := range names {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		name := p
+		if name == "" {
+			name = "none"
+		}
+		fmt.Fprintf(&b, "%s=%d", name, spdyProtos.count[p])
+	}
+	if n := spdyProtos.count["http/1.1"] + spdyProtos.count[""]; n > 0 {
+		b.WriteString(" (the server fell back to HTTP/1.1)")
+	}
+	return b.String()
+}
+
+//spdyFrameObserver gives the observer of the frames of a new spdy session,the
+//sessions are told apart by their local address in the -spdy-trace file.
+func spdyFrameObserver(conn net.Conn) common.FrameObserver {
+	if spdyFrames == nil {
+		return nil
+	}
+	return spdyFrames.Session(conn.LocalAddr().String())
+}
+
+//closeSPDYTrace flushes the -spdy-trace file,later frames of the sessions
+//still open are dropped.
+func closeSPDYTrace() {
+	if spdyFrames == nil {
+		return
+	}
+	err := spdyFrames.Close()
+	if cerr := spdyTraceFile.Close(); err == nil {
+		err = cerr
+	}
+	value := "written to " + *spdyTrace
+	if err != nil {
+		value = err.Error()
+	}
+	reporter.Details = append(reporter.Details, ibench.ConfigItem{Name: "SPDY Frame Trace", Value: value})
+}
+
+//spdyGoaways sums the GOAWAYs and the retried requests of all spdy transports.
+func spdyGoaways() (goaways, retries int) {
+	if spdyPool != nil {
+		return spdyPool.Goaways()
+	}
+	spdyTransports.Lock()
+	defer spdyTransports.Unlock()
+	for _, t := range spdyTransports.list {
+		g, r := t.Goaways()
+		goaways += g
+		retries += r
+	}
+	return goaways, retries
+}
+
+//spdyStats sums the activity on the sessions of all spdy transports.
+func spdyStats() common.ConnStats {
+	if spdyPool != nil {
+		return spdyPool.Stats()
+	}
+	var stats common.ConnStats
+	spdyTransports.Lock()
+	defer spdyTransports.Unlock()
+	for _, t := range spdyTransports.list {
+		stats.Add(t.Stats())
 	}
+	return stats
+}
 
+//h2Details summarizes the HTTP/2 connections of -h2.
+func h2Details() []ibench.ConfigItem {
+	stats := h2Pool.Stats
+	peak, mean := h2Pool.StreamsPerConn()
+	limit := "the server's limit"
+	if *h2Streams > 0 {
+		limit = strconv.Itoa(*h2Streams)
+	}
+	settings := stats.Settings()
+	if settings == "" {
+		settings = "none received"
+	}
+	goaways, rstsIn, rstsOut := stats.Frames()
+	goaway := strconv.FormatInt(goaways, 10)
+	if goaways > 0 {
+		goaway += fmt.Sprintf(" (last error code %d)", stats.GoAwayCode())
+	}
+	return []ibench.ConfigItem{
+		{Name: "HTTP/2 Connections", Value: fmt.Sprintf("%d,%d dialed", *h2Conns, stats.Connections())},
+		{Name: "HTTP/2 Streams Per Connection", Value: fmt.Sprintf("peak %d,mean %.1f,capped at %s", peak, mean, limit)},
+		{Name: "HTTP/2 Server Settings", Value: settings},
+		{Name: "HTTP/2 GOAWAY Received", Value: goaway},
+		{Name: "HTTP/2 RST_STREAM", Value: fmt.Sprintf("%d received,%d sent", rstsIn, rstsOut)},
+	}
 }
+
 func initReporter() {
 	reporter = new(ibench.Reporter)
 	reporter.Concurrency = *concurrency
 	reporter.Hostname = host
 	reporter.Port = port
 	reporter.Path = path
+	flag.VisitAll(func(f *flag.Flag) {
+		if reportExcluded[f.Name] {
+			return
+		}
+		value := f.Value.String()
+		switch f.Name {
+		case "H":
+			redacted := make([]string, len(headers))
+			for i, h := range headers {
+				redacted[i] = redactHeader(h)
+			}
+			value = fmt.Sprint(redacted)
+		case "auth":
+			value = ibench.RedactAuth(value)
+		case "hmac-secret":
+			if value != "" && !strings.HasPrefix(value, "@") {
+				value = "<redacted>"
+			}
+		}
+		reporter.Config = append(reporter.Config, ibench.ConfigItem{Name: f.Name, Value: value})
+	})
+}
+
+//reportExcluded are the flags left out of the configuration shown in the reports.
+var reportExcluded = map[string]bool{"h": true, "config": true, "dump-config": true, "html": true}
+
+//sensitiveHeaders carry credentials which must not end up in a report.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Auth-Token":        true,
+}
 
+//redactHeader masks the value of a "Name: value" header if it's a credential.
+func redactHeader(h string) string {
+	index := strings.Index(h, ":")
+	if index == -1 || !sensitiveHeaders[http.CanonicalHeaderKey(strings.TrimSpace(h[:index]))] {
+		return h
+	}
+	return h[:index+1] + " <redacted>"
 }
 func checkAndInitParams() {
-	url, err := gourl.ParseRequestURI(*url)
+	target, socket, err := ibench.UnixTarget(*url)
+	if err != nil {
+		printHelp(err)
+	}
+	if socket == "" {
+		socket = *unixSocket
+	} else if *unixSocket != "" {
+		printHelp(errors.New("-unix can't be combined with a unix socket -u"))
+	}
+	dialer = &ibench.Dialer{UnixSocket: socket}
+	if network, err = ibench.Family(*ipv4Only, *ipv6Only); err != nil {
+		printHelp(err)
+	}
+	dialer.Network = network
+	switch *dualStack {
+	case "happy":
+	case "strict":
+		dialer.FallbackDelay = -1
+	default:
+		printHelp(fmt.Errorf("unknown -dual-stack %q,want happy or strict", *dualStack))
+	}
+	if *bindAddrs != "" || *bindPorts != "" {
+		if socket != "" {
+			printHelp(errors.New("-bind and -bind-ports don't apply to a unix socket"))
+		}
+		if *bindAddrs != "" {
+			if localIPs, err = ibench.ParseLocalIPs(*bindAddrs); err != nil {
+				printHelp(err)
+			}
+			for _, ip := range localIPs {
+				if !familyMatches(ip.String()) {
+					printHelp(fmt.Errorf("-bind %s doesn't match -%s", ip, network[3:]))
+				}
+			}
+		}
+		if *bindPorts != "" {
+			if dialer.Ports, err = ibench.ParsePortRange(*bindPorts); err != nil {
+				printHelp(err)
+			}
+		}
+	}
+	if *dnsSpread && *dnsMode == ibench.ResolveSystem && *resolve == "" {
+		printHelp(errors.New("-dns-spread needs -dns once,-dns conn or -resolve,the system lookup only gives the first address"))
+	}
+	if *resolve != "" || *dnsMode != ibench.ResolveSystem || *dnsSpread {
+		if dialer.Resolver, err = ibench.NewResolver(*resolve, *dnsMode, *dnsSpread); err != nil {
+			printHelp(err)
+		}
+		addrStats = ibench.NewBreakdown("Address")
+	}
+	urlTemplate, err := ibench.ParseTemplate(target)
+	if err != nil {
+		printHelp(err)
+	}
+	url, err := gourl.ParseRequestURI(target)
+	if err != nil && !urlTemplate.IsStatic() {
+		//placeholders may stand for the host or port,check the url they expand to.
+		url, err = gourl.ParseRequestURI(urlTemplate.Sample())
+	}
 	if err != nil {
 		printHelp(err)
 	}
@@ -342,9 +933,37 @@ func checkAndInitParams() {
 		host = h
 		port = p
 	}
+	if !familyMatches(host) && socket == "" {
+		printHelp(fmt.Errorf("%s doesn't match -%s", host, network[3:]))
+	}
 	if path = url.Path; path == "" {
 		path = "/"
 	}
+	requestTemplate = &ibench.RequestTemplate{Method: *method, URL: urlTemplate}
+	if len(targets) > 0 {
+		if socket != "" {
+			printHelp(errors.New("-target can't be combined with a unix socket,which all the connections would go to"))
+		}
+		for _, target := range targets {
+			t, err := ibench.ParseTemplate(target)
+			if err != nil {
+				printHelp(err)
+			}
+			u, err := gourl.ParseRequestURI(t.Sample())
+			if err != nil {
+				printHelp(err)
+			}
+			if u.Scheme != proto {
+				printHelp(fmt.Errorf("-target %s:all the targets need the scheme %s of -u", target, proto))
+			}
+			requestTemplate.Targets = append(requestTemplate.Targets, t)
+		}
+		order := []string{urlTemplate.String()}
+		for _, t := range requestTemplate.Targets {
+			order = append(order, t.String())
+		}
+		targetStats = ibench.NewBreakdown("Target", order...)
+	}
 	if headers != nil {
 		for _, h := range headers {
 			index := strings.Index(h, ":")
@@ -352,17 +971,334 @@ func checkAndInitParams() {
 				printHelp(errors.New("Header format error"))
 			}
 			header.Set(h[:index], h[index+1:])
+			if err := requestTemplate.SetHeader(h[:index], strings.TrimSpace(h[index+1:])); err != nil {
+				printHelp(err)
+			}
 		}
 	}
 	if host == "" || port == "" || path == "" || proto == "" {
 		printHelp("host port path proto must have value")
 	}
-	ciphers := strings.Split(*cipherSuite, ",")
-	for _, c := range ciphers {
-		cipherSuites = append(cipherSuites, CipherSuites[c])
+	if cipherSuites, err = parseCipherSuites(*cipherSuite); err != nil {
+		printHelp(err)
+	}
+	targetTLS = clientTLSConfig(cipherSuites)
+	err = ibench.TLSOptions{
+		MinVersion: *tlsMin,
+		MaxVersion: *tlsMax,
+		ServerName: *sni,
+		CAFile:     *caFile,
+		CertFile:   *clientCert,
+		KeyFile:    *clientKey,
+	}.Apply(targetTLS)
+	if err != nil {
+		printHelp(err)
+	}
+	if *rate < 0 {
+		printHelp(errors.New("-rate can't be negative"))
+	}
+	if *stages != "" || *rate > 0 {
+		var list []ibench.Stage
+		if *stages != "" {
+			if *dur != 0 {
+				printHelp(errors.New("-t and -stages can't be combined,the stages set the duration"))
+			}
+			if list, err = ibench.ParseStages(*stages); err != nil {
+				printHelp(err)
+			}
+		}
+		pacer = ibench.NewPacer(*rate, list)
+	}
+	if requestTemplate.Body, err = ibench.ParseBody(*body); err != nil {
+		printHelp(err)
+	}
+	if *dataFile != "" {
+		if requestTemplate.Data, err = ibench.OpenData(*dataFile, *dataMode); err != nil {
+			printHelp(err)
+		}
+	}
+	if *sessionFile != "" && *replayFile != "" {
+		printHelp(errors.New("-session and -replay can't be combined"))
+	}
+	if len(targets) > 0 && (*sessionFile != "" || *replayFile != "") {
+		printHelp(errors.New("-target can't be combined with -session or -replay,which name their own urls"))
+	}
+	if pacer != nil && *replayFile != "" {
+		printHelp(errors.New("-rate and -stages can't be combined with -replay,which keeps the recorded timing"))
+	}
+	if *replayFile != "" {
+		if replay, err = ibench.NewReplay(*replayFile, proto+"://"+url.Host, *replaySpeed); err != nil {
+			printHelp(err)
+		}
+		replay.Header = requestTemplate.Header
+	}
+	if *sessionFile != "" {
+		session, err = ibench.LoadSession(*sessionFile, ibench.SessionConfig{
+			BaseURL: proto + "://" + url.Host,
+			Header:  requestTemplate.Header,
+			Data:    requestTemplate.Data,
+		})
+		if err != nil {
+			printHelp(err)
+		}
+	} else if err := requestTemplate.Check(); err != nil && replay == nil {
+		printHelp(err)
+	}
+	if *auth != "" {
+		a, err := ibench.ParseAuth(*auth, *authRotate)
+		if err != nil {
+			printHelp(err)
+		}
+		authenticators = append(authenticators, a)
+	}
+	if *hmacSecret != "" {
+		signer, err := ibench.NewSigner(ibench.SignerConfig{
+			Algorithm: *hmacAlgo,
+			Secret:    *hmacSecret,
+			Canonical: *hmacString,
+			Header:    *hmacHeader,
+			Encoding:  *hmacEncoding,
+		})
+		if err != nil {
+			printHelp(err)
+		}
+		if signer.UsesBody() && !ibench.Replayable(requestTemplate.Body) {
+			printHelp(errors.New("{{body_sha256}} needs a literal -B body"))
+		}
+		authenticators = append(authenticators, signer)
+	}
+	if *H2 {
+		if *SP {
+			printHelp(errors.New("-h2 and -S can't be combined"))
+		}
+		if len(targets) > 0 {
+			printHelp(errors.New("-target can't be combined with -h2,whose connections go to the host of -u"))
+		}
+		h2Pool = ibench.NewH2Pool(ibench.H2Config{
+			Conns:     *h2Conns,
+			Streams:   *h2Streams,
+			TLSConfig: clientTLS(),
+			Dial: func(ctx context.Context, conn int, network, addr string) (net.Conn, error) {
+				return workerDialer(conn).DialContext(ctx, network, addr)
+			},
+		})
+	}
+	if *spdyFlow != "default" {
+		if !*SP {
+			printHelp(errors.New("-spdy-flow needs -S"))
+		}
+		if spdyFlowControl, err = ibench.ParseFlowControl(*spdyFlow); err != nil {
+			printHelp(err)
+		}
+		if strings.HasPrefix(*spdyFlow, "auto") && *spdyPing == 0 {
+			printHelp(errors.New("-spdy-flow auto needs -spdy-ping,the window is tuned by the ping rtts"))
+		}
+	}
+	if *spdySettings != "" {
+		if !*SP {
+			printHelp(errors.New("-spdy-settings needs -S"))
+		}
+		if spdySettingsSent, err = ibench.ParseSPDYSettings(*spdySettings); err != nil {
+			printHelp(err)
+		}
+	}
+	if *spdyTrace != "" {
+		if !*SP {
+			printHelp(errors.New("-spdy-trace needs -S"))
+		}
+		if spdyTraceFile, err = os.Create(*spdyTrace); err != nil {
+			printHelp(err)
+		}
+		spdyFrames = common.NewFrameWriter(bufio.NewWriter(spdyTraceFile))
+	}
+	if *spdySessions > 0 {
+		if !*SP {
+			printHelp(errors.New("-spdy-sessions needs -S"))
+		}
+		if proto != "https" {
+			printHelp(errors.New("-spdy-sessions needs an https url,spdy is negotiated by alpn"))
+		}
+		if len(targets) > 0 {
+			printHelp(errors.New("-target can't be combined with -spdy-sessions,whose sessions go to the host of -u"))
+		}
+		balance, err := ibench.ParseBalance(*spdyBalance)
+		if err != nil {
+			printHelp(err)
+		}
+		spdyPool = ibench.NewSPDYPool(ibench.SPDYConfig{
+			Sessions:  *spdySessions,
+			Streams:   *spdyStreams,
+			Balance:   balance,
+			TLSConfig: clientTLS(),
+			Dial: func(session int, network, addr string) (net.Conn, error) {
+				return workerDialer(session).Dial(network, addr)
+			},
+			Negotiated:    countSPDYProto,
+			HeaderTimeout: *spdyHeaderTO,
+			IdleTimeout:   *spdyIdleTO,
+			Timeout:       *spdyTimeout,
+			PingInterval:  *spdyPing,
+			Pinged:        spdyPings.Observe,
+			FrameObserver: spdyFrameObserver,
+			FlowControl:   spdyFlowControl,
+			Settings:      spdySettingsSent,
+		})
+	}
+	if *affinitySpec != "" {
+		if affinity, err = ibench.ParseAffinity(*affinitySpec); err != nil {
+			printHelp(err)
+		}
 	}
 	initReporter()
+	if session != nil {
+		reporter.Breakdowns = append(reporter.Breakdowns, session.StepStats, session.SessionStats)
+	}
+	if affinity != nil {
+		reporter.Breakdowns = append(reporter.Breakdowns, affinity.Backends)
+	}
+	if replay != nil {
+		reporter.Breakdowns = append(reporter.Breakdowns, replay.Patterns)
+	}
+	if addrStats != nil {
+		reporter.Breakdowns = append(reporter.Breakdowns, addrStats)
+	}
+	if targetStats != nil {
+		reporter.Breakdowns = append(reporter.Breakdowns, targetStats)
+	}
+	if h2Pool != nil {
+		reporter.Breakdowns = append(reporter.Breakdowns, h2Pool.Conns)
+	}
+	if spdyPool != nil {
+		reporter.Breakdowns = append(reporter.Breakdowns, spdyPool.Sessions)
+		reporter.Gauges = append(reporter.Gauges, spdyPool.Active)
+	}
+}
+
+//parseCipherSuites maps the comma separated cipher suite names of -s to their ids.
+func parseCipherSuites(names string) ([]uint16, error) {
+	var suites []uint16
+	for _, c := range strings.Split(names, ",") {
+		id, ok := CipherSuites[strings.TrimSpace(c)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", c)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+//clientTLS returns a copy of the tls config of the target,with the -tls-* settings.
+func clientTLS() *tls.Config {
+	return targetTLS.Clone()
+}
+
+//clientTLSConfig returns the tls config used to connect with the cipher suites.
+func clientTLSConfig(suites []uint16) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify:     true,
+		SessionTicketsDisabled: true,
+		CipherSuites:           suites,
+	}
+}
+
+//loadConfig sets the flags from the json object in the file name.
+//The flags in set were given on the command line and keep their values,except
+//-H whose values are appended after the file's headers so they take precedence
+//on the same name.
+func loadConfig(name string, set map[string]bool) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		flagName := key
+		if n, ok := configKeys[key]; ok {
+			flagName = n
+		}
+		f := flag.Lookup(flagName)
+		if f == nil || configExcluded[flagName] {
+			return fmt.Errorf("%s: unknown key %q", name, key)
+		}
+		var list []string
+		switch v := values[key].(type) {
+		case nil:
+			//null leaves the flag at its default,as dumpConfig never writes it.
+			continue
+		case []interface{}:
+			for _, item := range v {
+				list = append(list, fmt.Sprint(item))
+			}
+		case float64:
+			list = []string{strconv.FormatFloat(v, 'f', -1, 64)}
+		default:
+			list = []string{fmt.Sprint(v)}
+		}
+		if flagName == "H" {
+			cmdline := headers
+			headers = nil
+			for _, h := range append(list, cmdline...) {
+				f.Value.Set(h)
+			}
+			continue
+		}
+		if set[flagName] {
+			continue
+		}
+		if l, ok := f.Value.(*flagList); ok {
+			for _, item := range list {
+				l.Set(item)
+			}
+			continue
+		}
+		if err := f.Value.Set(strings.Join(list, ",")); err != nil {
+			return fmt.Errorf("%s: %s: %v", name, key, err)
+		}
+	}
+	return nil
+}
+
+//dumpConfig writes the effective configuration as a json object which loadConfig accepts.
+func dumpConfig(w io.Writer) error {
+	names := make(map[string]string, len(configKeys))
+	for k, v := range configKeys {
+		names[v] = k
+	}
+	var lines []string
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if configExcluded[f.Name] || err != nil {
+			return
+		}
+		key := f.Name
+		if k, ok := names[key]; ok {
+			key = k
+		}
+		var value interface{} = f.Value.String()
+		if g, ok := f.Value.(flag.Getter); ok {
+			value = g.Get()
+		}
+		var b []byte
+		if b, err = json.Marshal(value); err == nil {
+			lines = append(lines, fmt.Sprintf("  %q: %s", key, b))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(lines)
+	_, err = fmt.Fprintf(w, "{\n%s\n}\n", strings.Join(lines, ",\n"))
+	return err
 }
+
 func canonicalAddr(url *gourl.URL) string {
 	addr := url.Host
 	if !hasPort(addr) {
@@ -370,11 +1306,159 @@ func canonicalAddr(url *gourl.URL) string {
 	}
 	return addr
 }
+
+//familyMatches reports whether the ip or host name addr can be reached over network.
+func familyMatches(addr string) bool {
+	family := ibench.AddrFamily(addr)
+	return family == "" || network == "tcp" || family == map[string]string{"tcp4": "IPv4", "tcp6": "IPv6"}[network]
+}
+
 func hasPort(s string) bool { return strings.LastIndex(s, ":") > strings.LastIndex(s, "]") }
 
+//serve runs the built-in target server until the process is killed.
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	httpAddr := fs.String("http", ":28081", "http listen address,empty to disable")
+	httpsAddr := fs.String("https", ":28080", "https listen address,empty to disable")
+	spdyAddr := fs.String("spdy", ":28443", "spdy listen address,empty to disable")
+	certFile := fs.String("cert", "", "certificate file,a self-signed one is generated if empty")
+	keyFile := fs.String("key", "", "private key file")
+	size := fs.Int("size", 0, "response body size in bytes,0 default")
+	status := fs.Int("status", 200, "response status code,200 default")
+	latency := fs.String("latency", "", "artificial latency:10ms,uniform:5ms,20ms,normal:20ms,5ms or exp:10ms")
+	errorRate := fs.Float64("error-rate", 0, "fraction of requests answered with -error-status,0 default")
+	errorStatus := fs.Int("error-status", 500, "status code of injected errors,500 default")
+	interval := fs.Int("i", 5, "seconds between served requests reports,0 to disable")
+	fs.Usage = func() {
+		fmt.Println("Usage: iBenchmark serve [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	dist, err := ibench.ParseDistribution(*latency)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	srv, err := ibench.NewServer(ibench.ServerConfig{
+		HTTPAddr:     *httpAddr,
+		HTTPSAddr:    *httpsAddr,
+		SPDYAddr:     *spdyAddr,
+		CertFile:     *certFile,
+		KeyFile:      *keyFile,
+		ResponseSize: *size,
+		Status:       *status,
+		Latency:      dist,
+		ErrorRate:    *errorRate,
+		ErrorStatus:  *errorStatus,
+	})
+	if err == nil {
+		err = srv.Start()
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, addr := range srv.Addrs() {
+		fmt.Println("ibenchmark serving on", addr)
+	}
+	if *interval <= 0 {
+		select {}
+	}
+	var last int64
+	for range time.Tick(time.Duration(*interval) * time.Second) {
+		served := srv.Served()
+		fmt.Printf("served:%d failed:%d rate:%d/s\n", served, srv.Failed(), (served-last)/int64(*interval))
+		last = served
+	}
+}
+
+//proxy runs the fault-injection proxy until the process is killed.
+func proxy(args []string) {
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	listen := fs.String("l", ":28090", "listen address")
+	target := fs.String("target", "127.0.0.1:28080", "target host:port")
+	listenTLS := fs.Bool("tls", false, "terminate tls on the listener,passthrough default")
+	targetTLS := fs.Bool("target-tls", false, "connect to the target with tls")
+	suites := fs.String("s", "", "cipher suites used with -target-tls,go default if empty")
+	certFile := fs.String("cert", "", "certificate file for -tls,a self-signed one is generated if empty")
+	keyFile := fs.String("key", "", "private key file for -tls")
+	latency := fs.String("latency", "", "added latency:10ms,uniform:5ms,20ms,normal:20ms,5ms or exp:10ms")
+	bandwidth := fs.Int64("bandwidth", 0, "bytes per second per direction of a connection,0 unlimited")
+	resetRate := fs.Float64("reset-rate", 0, "fraction of connections reset before the response")
+	partialRate := fs.Float64("partial-rate", 0, "fraction of connections closed after a partial response")
+	stallRate := fs.Float64("stall-rate", 0, "fraction of connections whose tls handshake is stalled")
+	stall := fs.Duration("stall", 5*time.Second, "duration of a handshake stall")
+	interval := fs.Int("i", 5, "seconds between fault reports,0 to disable")
+	fs.Usage = func() {
+		fmt.Println("Usage: iBenchmark proxy [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	dist, err := ibench.ParseDistribution(*latency)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	config := ibench.ProxyConfig{
+		ListenAddr: *listen,
+		Target:     *target,
+		Faults: ibench.FaultConfig{
+			Latency:     dist,
+			Bandwidth:   *bandwidth,
+			ResetRate:   *resetRate,
+			PartialRate: *partialRate,
+			StallRate:   *stallRate,
+			Stall:       *stall,
+		},
+	}
+	if *targetTLS {
+		var ids []uint16
+		if *suites != "" {
+			if ids, err = parseCipherSuites(*suites); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		config.TargetTLS = clientTLSConfig(ids)
+	}
+	if *listenTLS {
+		var cert tls.Certificate
+		if *certFile != "" || *keyFile != "" {
+			cert, err = tls.LoadX509KeyPair(*certFile, *keyFile)
+		} else {
+			cert, err = ibench.SelfSignedCertificate("localhost", "127.0.0.1", "::1")
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		config.ListenTLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	p, err := ibench.NewProxy(config)
+	if err == nil {
+		err = p.Start()
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("ibenchmark proxying", p.Addr(), "to", *target)
+	if *interval <= 0 {
+		select {}
+	}
+	for range time.Tick(time.Duration(*interval) * time.Second) {
+		st := p.Stats()
+		fmt.Printf("connections:%d resets:%d partials:%d stalls:%d dial errors:%d\n", st.Connections, st.Resets, st.Partials, st.Stalls, st.DialErrors)
+	}
+}
+
 func printHelp(err interface{}) {
 	fmt.Println(err)
 	fmt.Println("Usage: iBenchmark [options]")
+	fmt.Println("       iBenchmark serve [options]")
+	fmt.Println("       iBenchmark proxy [options]")
 	flag.PrintDefaults()
 	fmt.Printf("\ncihper suite:\n")
 	for k := range CipherSuites {
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactHeader(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"Host: example.com", "Host: example.com"},
+		{"Authorization: Bearer abc", "Authorization: <redacted>"},
+		{"cookie:a=b", "cookie: <redacted>"},
+		{"X-Api-Key: k", "X-Api-Key: <redacted>"},
+		{"malformed", "malformed"},
+	}
+	for _, tt := range tests {
+		if got := redactHeader(tt.header); got != tt.want {
+			t.Errorf("redactHeader(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+//isListFlag reports whether f collects its values,which are reset apart.
+func isListFlag(f *flag.Flag) bool {
+	_, ok := f.Value.(*flagList)
+	return ok || f.Name == "H"
+}
+
+//resetFlags sets iBench's flags to their defaults,and restores the values they
+//have now when the test ends.
+func resetFlags(t *testing.T) {
+	t.Helper()
+	values := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	savedHeaders, savedTargets := headers, targets
+	t.Cleanup(func() {
+		headers, targets = savedHeaders, savedTargets
+		flag.VisitAll(func(f *flag.Flag) {
+			if !isListFlag(f) && !strings.HasPrefix(f.Name, "test.") {
+				f.Value.Set(values[f.Name])
+			}
+		})
+	})
+
+	headers, targets = nil, nil
+	flag.VisitAll(func(f *flag.Flag) {
+		if !isListFlag(f) && !strings.HasPrefix(f.Name, "test.") {
+			f.Value.Set(f.DefValue)
+		}
+	})
+}
+
+//setFlag sets a flag as the command line would,without marking it as given to
+//the test binary.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	if err := flag.Lookup(name).Value.Set(value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//dumpedConfig runs dumpConfig and decodes it without the flags of the test binary.
+func dumpedConfig(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := dumpConfig(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &values); err != nil {
+		t.Fatalf("dump is not json: %v\n%s", err, buf.String())
+	}
+	for k := range values {
+		if strings.HasPrefix(k, "test.") {
+			delete(values, k)
+		}
+	}
+	return values
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	for _, content := range []string{
+		`{}`,
+		`{"url":"http://127.0.0.1:1/x","concurrency":3,"keepalive":true,"headers":["Host: a","X-A: 1"],"ciphers":["TLS_RSA_WITH_AES_128_CBC_SHA"],"html":"r.html"}`,
+		`{"url":"https://a:1/","targets":["https://b:2/x","https://c:3/y"],"rate":50,"stages":"10s:100,20s:0","tls-min":"1.2","sni":"example.com"}`,
+	} {
+		resetFlags(t)
+		if err := loadConfig(writeConfig(t, content), nil); err != nil {
+			t.Fatal(err)
+		}
+		first := dumpedConfig(t)
+		for k, v := range first {
+			if v == nil {
+				t.Errorf("dump writes null for %q", k)
+			}
+		}
+
+		resetFlags(t)
+		data, _ := json.Marshal(first)
+		if err := loadConfig(writeConfig(t, string(data)), nil); err != nil {
+			t.Fatalf("reloading the dump: %v\n%s", err, data)
+		}
+		if second := dumpedConfig(t); !reflect.DeepEqual(first, second) {
+			t.Errorf("round trip changed the config:\n%v\nbecame\n%v", first, second)
+		}
+	}
+}
+
+func TestConfigNullAndUnknownKeys(t *testing.T) {
+	resetFlags(t)
+	if err := loadConfig(writeConfig(t, `{"headers":null,"url":null}`), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 0 {
+		t.Errorf("null headers loaded as %q", headers)
+	}
+	if err := loadConfig(writeConfig(t, `{"bogus":1}`), nil); err == nil {
+		t.Error("unknown key accepted")
+	}
+	if err := loadConfig(writeConfig(t, `{"h":true}`), nil); err == nil {
+		t.Error("excluded flag accepted")
+	}
+}
+
+func TestConfigCommandLineOverrides(t *testing.T) {
+	resetFlags(t)
+	setFlag(t, "c", "7")
+	headers = flagHeader{"Host: b"}
+	targets = flagList{"http://b/"}
+	given := map[string]bool{"c": true, "H": true, "target": true}
+	if err := loadConfig(writeConfig(t, `{"concurrency":3,"headers":["Host: a"],"targets":["http://a/"],"requests":5}`), given); err != nil {
+		t.Fatal(err)
+	}
+	if *concurrency != 7 {
+		t.Errorf("concurrency = %d, want the command line's 7", *concurrency)
+	}
+	if len(headers) != 2 || headers[1] != "Host: b" {
+		t.Errorf("headers = %q, want the command line's last", headers)
+	}
+	if len(targets) != 1 || targets[0] != "http://b/" {
+		t.Errorf("targets = %q, want the command line's", targets)
+	}
+	if *reqNum != 5 {
+		t.Errorf("requests = %d, want the file's 5", *reqNum)
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	if ids, err := parseCipherSuites("TLS_RSA_WITH_AES_128_CBC_SHA,TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"); err != nil || len(ids) != 2 || ids[1] != 0xc02f {
+		t.Errorf("parseCipherSuites = %v, %v", ids, err)
+	}
+	if _, err := parseCipherSuites("TLS_NOPE"); err == nil {
+		t.Error("unknown cipher suite accepted")
+	}
+}
+
+func TestReportRedactsCredentials(t *testing.T) {
+	resetFlags(t)
+	setFlag(t, "auth", "basic:ann:secret")
+	setFlag(t, "hmac-secret", "key")
+	setFlag(t, "H", "Authorization: Bearer abc")
+	initReporter()
+	for _, item := range reporter.Config {
+		if strings.Contains(item.Value, "secret") || strings.Contains(item.Value, "key") || strings.Contains(item.Value, "abc") {
+			t.Errorf("-%s shows a credential: %q", item.Name, item.Value)
+		}
+	}
+}